@@ -2,9 +2,13 @@
 package main
 
 import (
+	"bufio"
+	"compress/gzip"
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
 	"path/filepath"
@@ -27,6 +31,7 @@ func main() {
 	var payload int
 	var batch int
 	var attributes bool
+	var inputFile string
 
 	flag.StringVar(&topicArn, "topic", "", "topic ARN")
 	flag.StringVar(&roleArn, "role", "", "role ARN")
@@ -34,16 +39,25 @@ func main() {
 	flag.IntVar(&payload, "payload", 26198, fmt.Sprintf("payload size (max %d)", maxPublishPayload))
 	flag.IntVar(&batch, "batch", 10, "batch size")
 	flag.BoolVar(&attributes, "attributes", false, "include message attributes")
+	flag.StringVar(&inputFile, "input", "", "replay JSON lines written by the sqs-to-sns file destination or archive (- for stdin)")
 	flag.Parse()
 
 	me := filepath.Base(os.Args[0])
 
+	client := snsclient.NewClient(me, topicArn, roleArn, endpointURL)
+
+	if inputFile != "" {
+		if err := replay(client, topicArn, inputFile, batch); err != nil {
+			slog.Error("replay failed", "input", inputFile, "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	var entries []snstypes.PublishBatchRequestEntry
 
 	message := strings.Repeat("a", payload)
 
-	client := snsclient.NewClient(me, topicArn, roleArn, endpointURL)
-
 	const (
 		stringType = "String"
 		value      = "value1"
@@ -109,3 +123,104 @@ func main() {
 			"SenderFault", f.SenderFault)
 	}
 }
+
+// replay publishes the records found in input, in batches of up to batch
+// entries and maxPublishPayload bytes. Records over maxPublishPayload on
+// their own are skipped. Inputs named *.gz are decompressed.
+func replay(client *sns.Client, topicArn, input string, batch int) error {
+	var f io.Reader = os.Stdin
+	if input != "-" {
+		file, err := os.Open(input)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		f = file
+	}
+	if strings.HasSuffix(input, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		f = gz
+	}
+
+	var entries []snstypes.PublishBatchRequestEntry
+	var batchSize int
+
+	flush := func() error {
+		if len(entries) == 0 {
+			return nil
+		}
+		result, err := client.PublishBatch(context.TODO(), &sns.PublishBatchInput{
+			PublishBatchRequestEntries: entries,
+			TopicArn:                   aws.String(topicArn),
+		})
+		if err != nil {
+			return err
+		}
+		slog.Info("replayed batch",
+			"successful", len(result.Successful),
+			"failed", len(result.Failed))
+		for _, f := range result.Failed {
+			slog.Error("failure",
+				"Id", aws.ToString(f.Id),
+				"code", aws.ToString(f.Code),
+				"SenderFault", f.SenderFault)
+		}
+		entries = entries[:0]
+		batchSize = 0
+		return nil
+	}
+
+	// Lines are read whole, whatever their length: a file destination
+	// record holds up to 1 MiB of body, plus escaping and record fields.
+	reader := bufio.NewReaderSize(f, 64*1024)
+
+	for line := 1; ; line++ {
+		data, errRead := reader.ReadBytes('\n')
+		if errRead == io.EOF && len(data) == 0 {
+			break
+		}
+		if errRead != nil && errRead != io.EOF {
+			return errRead
+		}
+
+		var r snsutils.Record
+		if err := json.Unmarshal(data, &r); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		entry, err := r.PublishEntry()
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+
+		const debug = false
+		_, _, size, _ := snsutils.GetSNSPayloadSize(entry, debug)
+		if size > maxPublishPayload {
+			slog.Error("record too large, skipped",
+				"line", line,
+				"message_id", r.MessageID,
+				"size", size,
+				"limit", maxPublishPayload)
+			continue
+		}
+		if batchSize+size > maxPublishPayload {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+
+		entry.Id = aws.String(strconv.Itoa(len(entries)))
+		entries = append(entries, entry)
+		batchSize += size
+		if len(entries) >= batch {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+
+	return flush()
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	snstypes "github.com/aws/aws-sdk-go-v2/service/sns/types"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/segmentio/ksuid"
+	"github.com/udhos/sqs-to-sns/v2/snsutils"
+)
+
+// Aggregate formats.
+const (
+	// aggregateJSONArray publishes bodies as elements of a JSON array.
+	aggregateJSONArray = "json_array"
+
+	// aggregateNDJSON publishes bodies separated by newlines.
+	aggregateNDJSON = "ndjson"
+)
+
+// attrAggregated holds the number of bodies packed into an aggregate.
+const attrAggregated = "sqs_to_sns_aggregated"
+
+// aggregateReserve is room kept in the aggregate payload for the
+// JSON array brackets and attribute attrAggregated.
+const aggregateReserve = 64
+
+const (
+	defaultAggregateMaxMessages = 100
+	maxAggregateMaxMessages     = 10000
+	minAggregateMaxBytes        = 1024
+)
+
+// aggregateConfig packs many small messages into one SNS message.
+// Source messages are deleted from SQS only once the aggregate publishes.
+type aggregateConfig struct {
+	Format      string `yaml:"format"`       // json_array, ndjson, empty disables
+	MaxMessages int    `yaml:"max_messages"` // bodies per aggregate (default 100)
+	MaxBytes    int    `yaml:"max_bytes"`    // aggregate payload (default 262144)
+}
+
+func (a aggregateConfig) enabled() bool {
+	return a.Format != ""
+}
+
+func validateAggregate(q queueConfig) error {
+	a := q.Aggregate
+	switch a.Format {
+	case "":
+		return nil
+	case aggregateJSONArray, aggregateNDJSON:
+	default:
+		return fmt.Errorf("aggregate format=%q must be one of: %s, %s",
+			a.Format, aggregateJSONArray, aggregateNDJSON)
+	}
+	if a.MaxMessages < 2 || a.MaxMessages > maxAggregateMaxMessages {
+		return fmt.Errorf("aggregate max_messages=%d must be between 2 and %d",
+			a.MaxMessages, maxAggregateMaxMessages)
+	}
+	if limit := q.Destination.limits().messageLimit(); a.MaxBytes < minAggregateMaxBytes || a.MaxBytes > limit {
+		return fmt.Errorf("aggregate max_bytes=%d must be between %d and %d",
+			a.MaxBytes, minAggregateMaxBytes, limit)
+	}
+	if isFifo(q.QueueURL) || q.anyFifoTopic() {
+		return errors.New("aggregate does not support FIFO queues or topics")
+	}
+	if q.Compress {
+		return errors.New("aggregate does not support compress")
+	}
+	return nil
+}
+
+// newAggregatePool creates the pool that collects the messages of the
+// next aggregate. It reuses the poolV2 byte accounting, with one byte of
+// padding per message for the separator.
+func newAggregatePool(a aggregateConfig, limits destinationLimits, perMessagePadding int) *poolV2 {
+	budget := min(a.MaxBytes, limits.messageLimit()-perMessagePadding) - aggregateReserve
+	return newPoolV2Items(budget, 1, a.MaxMessages)
+}
+
+// aggregatable reports whether the body of an entry can be packed.
+// A json_array element must be valid JSON, an ndjson line must not
+// hold a newline. Other messages are published on their own.
+func (a aggregateConfig) aggregatable(m message) bool {
+	body := aws.ToString(m.snsBatchEntry.Message)
+	if a.Format == aggregateJSONArray {
+		return json.Valid([]byte(body))
+	}
+	return !strings.ContainsAny(body, "\r\n")
+}
+
+// newAggregate packs the entry bodies of parts into one message.
+// Source message attributes are not carried over.
+func newAggregate(format string, parts []message) message {
+	bodies := make([]string, len(parts))
+	receivedAt := time.Now()
+	for i, m := range parts {
+		bodies[i] = aws.ToString(m.snsBatchEntry.Message)
+		if m.receivedAt.Before(receivedAt) {
+			receivedAt = m.receivedAt
+		}
+	}
+
+	var body string
+	if format == aggregateJSONArray {
+		body = "[" + strings.Join(bodies, ",") + "]"
+	} else {
+		body = strings.Join(bodies, "\n")
+	}
+
+	snsEntry := snstypes.PublishBatchRequestEntry{
+		Message: aws.String(body),
+		MessageAttributes: map[string]snstypes.MessageAttributeValue{
+			attrAggregated: {
+				DataType:    aws.String("Number"),
+				StringValue: aws.String(strconv.Itoa(len(parts))),
+			},
+		},
+	}
+
+	const debug = false
+	_, _, total, _ := snsutils.GetSNSPayloadSize(snsEntry, debug)
+
+	return message{
+		sqsMessage: &sqstypes.Message{
+			MessageId: aws.String("aggregate-" + ksuid.New().String()),
+			Body:      aws.String(body),
+		},
+		receivedAt:     receivedAt, // oldest part, for forward latency
+		snsBatchEntry:  &snsEntry,
+		snsPayloadSize: total,
+		parts:          parts,
+	}
+}
+
+// expandAggregates replaces aggregates with their source messages.
+func expandAggregates(msg []message) []message {
+	var found bool
+	for _, m := range msg {
+		if m.parts != nil {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return msg
+	}
+	expanded := make([]message, 0, len(msg))
+	for _, m := range msg {
+		if m.parts == nil {
+			expanded = append(expanded, m)
+			continue
+		}
+		expanded = append(expanded, m.parts...)
+	}
+	return expanded
+}
+
+// aggregate collects a message into the destination aggregator, and
+// moves full aggregates to the publish pool.
+func (app *application) aggregate(q *queue, d *destination, m message) {
+	tooBig := m.snsPayloadSize+1 > d.aggregator.snsPublishPayloadLimit
+	if tooBig || !q.queueCfg.Aggregate.aggregatable(m) {
+		d.publishPool.add(m)
+		return
+	}
+	d.aggregator.add(m)
+	for {
+		parts, found := d.aggregator.getFullBatch()
+		if !found {
+			break
+		}
+		app.addAggregate(q, d, parts)
+	}
+}
+
+// flushAggregate moves partial aggregates to the publish pool.
+func (app *application) flushAggregate(q *queue, d *destination) {
+	for {
+		parts := d.aggregator.getAvailable()
+		if len(parts) == 0 {
+			return
+		}
+		app.addAggregate(q, d, parts)
+	}
+}
+
+func (app *application) addAggregate(q *queue, d *destination, parts []message) {
+	q.stats.aggregates.Add(1)
+	q.stats.aggregatedMessages.Add(uint64(len(parts)))
+	d.publishPool.add(newAggregate(q.queueCfg.Aggregate.Format, parts))
+}
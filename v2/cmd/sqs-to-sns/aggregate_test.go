@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+func newAggregateTestQueue(format string, maxMessages int, pub publisher) (*queue, *destination) {
+	q := &queue{
+		queueCfg: queueDefaults(queueConfig{
+			TopicArn:   "topic",
+			NackPolicy: nackPolicyImmediate,
+			Aggregate:  aggregateConfig{Format: format, MaxMessages: maxMessages},
+		}),
+		deleteCh:   make(chan message, 100),
+		visibility: &visibilityMock{},
+		logger:     slog.Default(),
+	}
+	initStats(&q.stats)
+	d := newDestination(0, "topic", q.queueCfg, 0, pub, q.logger)
+	q.destinations = []*destination{d}
+	return q, d
+}
+
+func newAggregateTestMessage(t *testing.T, body string) message {
+	sqsMsg := &sqstypes.Message{
+		MessageId: aws.String(getRandomID()),
+		Body:      aws.String(body),
+	}
+	m, err := newMessage(sqsMsg, time.Now(), messageOptions{}, 0)
+	if err != nil {
+		t.Fatalf("new message: %v", err)
+	}
+	return m
+}
+
+// go test -count 1 -run '^TestAggregate$' ./...
+func TestAggregate(t *testing.T) {
+	pub := &publisherMock{}
+	q, d := newAggregateTestQueue(aggregateJSONArray, 3, pub)
+	if err := validateQueueConfig(q.queueCfg); err != nil {
+		t.Fatalf("config: %v", err)
+	}
+	app := &application{}
+
+	for i := range 4 {
+		app.forward(q, newAggregateTestMessage(t, fmt.Sprintf(`{"n":%d}`, i)))
+		app.aggregate(q, d, <-d.publishCh)
+	}
+
+	// not JSON, published on its own
+	app.forward(q, newAggregateTestMessage(t, "plain"))
+	app.aggregate(q, d, <-d.publishCh)
+
+	if got := q.stats.aggregates.Load(); got != 1 {
+		t.Fatalf("expected 1 full aggregate, got %d", got)
+	}
+
+	app.flushAggregate(q, d)
+
+	batch := d.publishPool.getAvailable()
+	if len(batch) != 3 {
+		t.Fatalf("expected 3 entries (2 aggregates, 1 plain), got %d", len(batch))
+	}
+
+	expected := []string{`[{"n":0},{"n":1},{"n":2}]`, "plain", `[{"n":3}]`}
+	for i, m := range batch {
+		if got := aws.ToString(m.snsBatchEntry.Message); got != expected[i] {
+			t.Errorf("entry %d: expected=%s got=%s", i, expected[i], got)
+		}
+	}
+	if got := aws.ToString(batch[0].snsBatchEntry.MessageAttributes[attrAggregated].StringValue); got != "3" {
+		t.Errorf("expected aggregated count 3, got %s", got)
+	}
+
+	app.batchPublish(q, d, batch)
+
+	if pub.getMessages() != 3 {
+		t.Errorf("expected 3 published entries, got %d", pub.getMessages())
+	}
+	if len(q.deleteCh) != 5 {
+		t.Errorf("expected 5 source messages deleted, got %d", len(q.deleteCh))
+	}
+}
+
+// go test -count 1 -run '^TestAggregatePublishError$' ./...
+func TestAggregatePublishError(t *testing.T) {
+	pub := &publisherErrMock{err: errors.New("boom")}
+	q, d := newAggregateTestQueue(aggregateNDJSON, 10, pub)
+	app := &application{}
+
+	for i := range 2 {
+		app.forward(q, newAggregateTestMessage(t, fmt.Sprintf("line%d", i)))
+		app.aggregate(q, d, <-d.publishCh)
+	}
+	app.flushAggregate(q, d)
+
+	batch := d.publishPool.getAvailable()
+	if len(batch) != 1 || aws.ToString(batch[0].snsBatchEntry.Message) != "line0\nline1" {
+		t.Fatalf("expected one ndjson aggregate, got %d", len(batch))
+	}
+
+	app.batchPublish(q, d, batch)
+
+	if len(q.deleteCh) != 0 {
+		t.Errorf("sources of a failed aggregate must not be deleted, got %d", len(q.deleteCh))
+	}
+	if got := q.stats.nackedMessages.Load(); got != 2 {
+		t.Errorf("nacked: expected=2 got=%d", got)
+	}
+}
+
+// go test -count 1 -run '^TestAggregateBudget$' ./...
+func TestAggregateBudget(t *testing.T) {
+	q, d := newAggregateTestQueue(aggregateNDJSON, maxAggregateMaxMessages, &publisherMock{})
+	app := &application{}
+
+	body := strings.Repeat("a", 100_000)
+	for range 3 {
+		app.forward(q, newAggregateTestMessage(t, body))
+		app.aggregate(q, d, <-d.publishCh)
+	}
+	app.flushAggregate(q, d)
+
+	batch := d.publishPool.getAvailable()
+	if len(batch) != 1 {
+		t.Fatalf("expected 1 entry per PublishBatch, got %d", len(batch))
+	}
+	for _, m := range append(batch, d.publishPool.getAvailable()...) {
+		if m.snsPayloadSize > maxSnsPublishPayload {
+			t.Errorf("aggregate exceeds SNS limit: %d", m.snsPayloadSize)
+		}
+	}
+}
+
+// go test -count 1 -run '^TestValidateAggregate$' ./...
+func TestValidateAggregate(t *testing.T) {
+	table := []struct {
+		name  string
+		cfg   queueConfig
+		valid bool
+	}{
+		{"disabled", queueConfig{TopicArn: "t"}, true},
+		{"json array", queueConfig{TopicArn: "t", Aggregate: aggregateConfig{Format: aggregateJSONArray}}, true},
+		{"bad format", queueConfig{TopicArn: "t", Aggregate: aggregateConfig{Format: "csv"}}, false},
+		{"max bytes", queueConfig{TopicArn: "t", Aggregate: aggregateConfig{Format: aggregateNDJSON, MaxBytes: 100}}, false},
+		{"fifo topic", queueConfig{TopicArn: "t.fifo", Aggregate: aggregateConfig{Format: aggregateNDJSON}}, false},
+		{"compress", queueConfig{TopicArn: "t", Compress: true, Aggregate: aggregateConfig{Format: aggregateNDJSON}}, false},
+	}
+	for _, data := range table {
+		if err := validateAggregate(queueDefaults(data.cfg)); (err == nil) != data.valid {
+			t.Errorf("%s: expected valid=%t, got error: %v", data.name, data.valid, err)
+		}
+	}
+}
@@ -12,7 +12,7 @@ import (
 )
 
 func newApp(cfg config,
-	clientGenerator func(queueCfg queueConfig) (receiver, publisher, deleter)) *application {
+	clientGenerator func(queueCfg queueConfig) queueClients) *application {
 
 	app := &application{
 		health: newHealthServer(cfg.healthAddr, cfg.healthPath),
@@ -21,26 +21,56 @@ func newApp(cfg config,
 
 	for _, queueCfg := range cfg.queues {
 
-		receive, publish, deleter := clientGenerator(queueCfg)
+		clients := clientGenerator(queueCfg)
+
+		options, errOpt := newMessageOptions(queueCfg)
+		if errOpt != nil {
+			fatalf("queue %s: %v", queueCfg.ID, errOpt)
+		}
 
 		q := &queue{
-			queueCfg:    queueCfg,
-			publishCh:   make(chan message, queueCfg.BufferSizePublish),
-			deleteCh:    make(chan message, queueCfg.BufferSizeDelete),
-			publishPool: newPoolV2(maxSnsPublishPayload, cfg.perMessagePadding), // Byte-size-limited
-			deletePool:  newPoolV1(),                                            // NOT byte-size-limited
+			queueCfg:   queueCfg,
+			options:    options,
+			deleteCh:   make(chan message, queueCfg.BufferSizeDelete),
+			deletePool: newPoolV1(), // NOT byte-size-limited
 
-			receive: receive,
-			publish: publish,
-			delete:  deleter,
+			receive:    clients.receive,
+			delete:     clients.delete,
+			visibility: clients.visibility,
+			quarantine: clients.quarantine,
+			claimCheck: clients.claimCheck,
 
 			logger: slog.With(
 				"queue_id", queueCfg.ID,
 				"queue_url", queueCfg.QueueURL,
-				"topic_arn", queueCfg.TopicArn,
 			),
 		}
 
+		topics := queueCfg.topics()
+		for i, target := range topics {
+			q.destinations = append(q.destinations, newDestination(i, target,
+				queueCfg, cfg.perMessagePadding, clients.newPublisher(target), q.logger))
+		}
+
+		q.router = newRouter(queueCfg)
+
+		if len(topics) > 1 && q.router == nil && !queueCfg.SplitJSONArray {
+			// Remember partial fan-outs to skip topics already published.
+			q.tracker = newFanoutTracker()
+		}
+
+		if queueCfg.VisibilityTimeout > 0 {
+			q.heartbeat = newVisibilityHeartbeat(queueCfg.VisibilityTimeout)
+		}
+
+		if q.claimCheck != nil {
+			q.claimCheckCh = make(chan message, queueCfg.BufferSizePublish)
+		}
+
+		if queueCfg.Archive.enabled() {
+			q.archiver = newArchiver(queueCfg.Archive, queueCfg.ID)
+		}
+
 		initStats(&q.stats)
 
 		app.queues = append(app.queues, q)
@@ -64,14 +94,27 @@ func (app *application) run() {
 			q.readers.Add(1)
 			app.startReader(q, root)
 		}()
-		go func() {
-			q.publishers.Add(1)
-			app.startPublisher(q, root)
-		}()
+		for _, d := range q.destinations {
+			go func() {
+				d.publishers.Add(1)
+				app.startPublisher(q, d, root)
+			}()
+		}
 		go func() {
 			q.janitors.Add(1)
 			app.startJanitor(q, root)
 		}()
+		if q.heartbeat != nil {
+			go app.startHeartbeat(q)
+		}
+		if q.archiver != nil {
+			go app.startArchiver(q)
+		}
+		if q.claimCheckCh != nil {
+			for range claimCheckUploaders {
+				go app.startClaimCheck(q)
+			}
+		}
 	}
 }
 
@@ -81,6 +124,12 @@ func (app *application) stopReaders() {
 	}
 }
 
+func (app *application) stopHeartbeats() {
+	for _, q := range app.queues {
+		q.heartbeat.stop()
+	}
+}
+
 func (app *application) startReader(q *queue, root bool) {
 	const me = "reader"
 
@@ -115,7 +164,64 @@ func (app *application) startReader(q *queue, root bool) {
 
 		emptyReceive := len(msg) == 0
 
+		// Start tracking before forwarding, since we might
+		// block on a full publishCh.
+		q.heartbeat.track(msg)
+
+		// Skip destinations that published a redelivered message before.
+		for i, m := range msg {
+			msg[i].skip = q.tracker.done(aws.ToString(m.sqsMessage.MessageId))
+		}
+
+		var poison, oversize []message
+
+		// Messages we could not convert get the failure action,
+		// unless the failure is transient. Poison messages are
+		// quarantined first, so max_receive_count bounds the
+		// redeliveries of messages that never convert.
+		var valid, invalid, retry []message
 		for _, m := range msg {
+			if m.overflow {
+				q.stats.attributeOverflows.add(q.queueCfg.Attributes.Overflow, 1)
+			}
+			if (m.invalid || m.retry) && q.isPoison(m) {
+				poison = append(poison, m)
+				continue
+			}
+			if m.retry {
+				retry = append(retry, m)
+				continue
+			}
+			if m.invalid {
+				invalid = append(invalid, m)
+				continue
+			}
+			valid = append(valid, m)
+		}
+
+		// Pick the destination topic of every message.
+		routed, unmatched := app.routeMessages(q, valid)
+
+		// Record the receive order of FIFO groups before publishers
+		// get a chance to reorder them.
+		q.trackGroups(routed)
+
+		for _, m := range routed {
+
+			if q.isPoison(m) {
+				poison = append(poison, m)
+				continue
+			}
+
+			if m.oversize {
+				oversize = append(oversize, m)
+				continue
+			}
+
+			if m.compressed {
+				q.stats.compressedMessages.Add(1)
+				q.stats.compressionRatio.record(compressionRatio(m))
+			}
 
 			// debug logs - what we received
 			if app.cfg.logMessageBody {
@@ -129,7 +235,27 @@ func (app *application) startReader(q *queue, root bool) {
 					"message_size", m.snsPayloadSize)
 			}
 
-			q.publishCh <- m
+			app.forward(q, m)
+		}
+
+		if len(poison) > 0 {
+			app.quarantine(q, poison, quarantineReasonMaxReceiveCount)
+		}
+
+		if len(oversize) > 0 {
+			app.handleOversize(q, oversize)
+		}
+
+		if len(unmatched) > 0 {
+			app.handleUnmatched(q, unmatched)
+		}
+
+		if len(invalid) > 0 {
+			app.transformFailed(q, invalid)
+		}
+
+		if len(retry) > 0 {
+			app.release(q, retry)
 		}
 
 		if mustStop {
@@ -184,11 +310,11 @@ func (app *application) startReader(q *queue, root bool) {
 	}
 }
 
-func (app *application) startPublisher(q *queue, root bool) {
+func (app *application) startPublisher(q *queue, d *destination, root bool) {
 
 	q.stats.goroutineSpawns.Add(1)      // Record the start
 	defer q.stats.goroutineExits.Add(1) // Record the exit
-	defer q.publishers.Add(-1)
+	defer d.publishers.Add(-1)
 
 	if root {
 		// Spawn global periodic flusher.
@@ -198,31 +324,44 @@ func (app *application) startPublisher(q *queue, root bool) {
 			ticker := time.NewTicker(app.cfg.flushIntervalPublish)
 			for range ticker.C {
 				// Only partial-flush if we haven't batch-published anything in the last interval.
-				last := q.lastPublishUnix.Load()
+				last := d.lastPublishUnix.Load()
 				if time.Since(time.Unix(0, last)) < app.cfg.flushIntervalPublish {
 					continue
 				}
 
-				m := q.publishPool.getAvailable()
+				if d.aggregator != nil {
+					app.flushAggregate(q, d)
+				}
+
+				m := d.publishPool.getAvailable()
 				if len(m) > 0 {
-					app.batchPublish(q, m)
+					app.publish(q, d, m)
 				}
 			}
 		}()
 	}
 
-	for msg := range q.publishCh {
-		q.publishPool.add(msg)
+	for msg := range d.publishCh {
+		if d.aggregator != nil {
+			app.aggregate(q, d, msg) // Full aggregates go to the pool
+		} else {
+			d.publishPool.add(msg)
+		}
+
+		// FIFO: hand back messages queued behind a released message.
+		if evicted := d.fifo.takeEvicted(); len(evicted) > 0 {
+			app.undelivered(q, d, evicted, true)
+		}
 
 		// drain full batches.
 		for {
 			// attempt to get full 10-message batch
-			m, found := q.publishPool.getFullBatch()
+			m, found := d.publishPool.getFullBatch()
 			if !found {
 				break // no full batch
 			}
 			// got a full batch
-			app.batchPublish(q, m)
+			app.publish(q, d, m)
 		}
 
 		//
@@ -230,7 +369,7 @@ func (app *application) startPublisher(q *queue, root bool) {
 		// root: might scale up by spawning sibling.
 		// non-root: might scale down by exiting.
 		//
-		load := channelLoad(q.publishCh)
+		load := channelLoad(d.publishCh)
 
 		q.stats.publishChLoad.record(uint64(load * 100))
 
@@ -241,12 +380,12 @@ func (app *application) startPublisher(q *queue, root bool) {
 				//
 				// we are the unique (root) goroutine spawning siblings,
 				// so it is enough to check we are under the limit.
-				if q.publishers.Load() < q.queueCfg.LimitPublishers {
-					q.publishers.Add(1)
+				if d.publishers.Load() < q.queueCfg.LimitPublishers {
+					d.publishers.Add(1)
 
 					go func() {
 						const siblingIsRoot = false // spawned sibling is never root
-						app.startPublisher(q, siblingIsRoot)
+						app.startPublisher(q, d, siblingIsRoot)
 					}()
 				}
 			}
@@ -280,18 +419,29 @@ func GetBatchSizing(msg []message) string {
 	return fmt.Sprintf("items=%d grand_total=%d: ", len(msg), sum) + strings.Join(items, " ")
 }
 
-func (app *application) batchPublish(q *queue, msg []message) {
+func (app *application) batchPublish(q *queue, d *destination, msg []message) {
 
 	const me = "batchPublish"
 
 	// Record activity to keep flusher from flushing
 	// partial batches without real need.
-	q.lastPublishUnix.Store(time.Now().UnixNano())
+	d.lastPublishUnix.Store(time.Now().UnixNano())
 
-	pub, errPub := q.publish.publish(q, msg)
+	pub, failures, errPub := d.publish.publish(q, msg)
 	if errPub != nil {
 		q.stats.publishErrors.Add(1) // Track the failure
-		q.logger.Error(me,
+
+		if nackEnabled(q.queueCfg.NackPolicy) {
+			d.logger.Error(me,
+				"error", errPub,
+				"batch_size", GetBatchSizing(msg),
+				"nack_policy", q.queueCfg.NackPolicy)
+			app.undelivered(q, d, msg, true) // Release them to SQS without sleeping
+			return
+		}
+
+		app.undelivered(q, d, msg, true) // Let SQS redeliver them, after cooldown
+		d.logger.Error(me,
 			"error", errPub,
 			"batch_size", GetBatchSizing(msg),
 			"sleeping", q.queueCfg.PublishErrorCooldown)
@@ -304,6 +454,7 @@ func (app *application) batchPublish(q *queue, msg []message) {
 	q.stats.publishedMessages.Add(uint64(len(pub)))
 	if len(pub) < len(msg) {
 		q.stats.partialPublishes.Add(1)
+		app.handlePublishFailures(q, d, failures) // Retry or give up failed ones
 	}
 
 	for _, m := range pub {
@@ -313,20 +464,22 @@ func (app *application) batchPublish(q *queue, msg []message) {
 
 		// debug logs - what we published
 		if app.cfg.logMessageBody {
-			q.logger.Debug(me,
+			d.logger.Debug(me,
 				"latency_ms", latencyMs,
 				"message_id", aws.ToString(m.sqsMessage.MessageId),
 				"message_size", m.snsPayloadSize,
 				"message_body", aws.ToString(m.sqsMessage.Body))
 		} else {
-			q.logger.Debug(me,
+			d.logger.Debug(me,
 				"latency_ms", latencyMs,
 				"message_id", aws.ToString(m.sqsMessage.MessageId),
 				"message_size", m.snsPayloadSize)
 		}
-
-		q.deleteCh <- m
 	}
+
+	app.archive(q, d, pub) // Record what went out, without blocking
+
+	app.delivered(q, d, pub) // Delete once every destination published them
 }
 
 func (app *application) batchDelete(q *queue, msg []message) {
@@ -338,6 +491,14 @@ func (app *application) batchDelete(q *queue, msg []message) {
 	q.lastDeleteUnix.Store(time.Now().UnixNano())
 
 	del, errDel := q.delete.delete(q, msg)
+
+	// Either deleted or given up. Failed deletes will be
+	// redelivered by SQS, then we stop extending them.
+	q.heartbeat.untrack(msg)
+
+	// Deleted messages will not be redelivered, forget their fan-out.
+	q.tracker.forget(del)
+
 	if errDel != nil {
 		q.stats.deleteErrors.Add(1) // Track the failure
 		q.logger.Error(me,
@@ -447,6 +608,57 @@ func (app *application) startJanitor(q *queue, root bool) {
 	} // for
 }
 
+// startHeartbeat periodically extends the visibility timeout of
+// in-flight messages before SQS would redeliver them.
+func (app *application) startHeartbeat(q *queue) {
+	const me = "heartbeat"
+
+	ticker := time.NewTicker(q.heartbeat.interval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-q.heartbeat.stopCh:
+			return
+		case <-ticker.C:
+		}
+
+		due := q.heartbeat.due(time.Now())
+
+		for len(due) > 0 {
+			size := min(len(due), maxBatchItems)
+			batch := due[:size]
+			due = due[size:]
+
+			changes := make([]visibilityChange, len(batch))
+			for i, m := range batch {
+				changes[i] = visibilityChange{msg: m, timeout: q.heartbeat.timeout}
+			}
+
+			now := time.Now()
+
+			ext, errExt := q.visibility.changeVisibility(q, changes)
+			if errExt != nil {
+				q.stats.visibilityErrors.Add(1)
+				q.logger.Error(me,
+					"error", errExt,
+					"messages", len(batch),
+					"inflight", q.heartbeat.size())
+				continue
+			}
+
+			q.heartbeat.extended(now, ext)
+			q.stats.visibilityExtensions.Add(uint64(len(ext)))
+
+			q.logger.Debug(me,
+				"extended", len(ext),
+				"messages", len(batch),
+				"visibility_timeout", q.heartbeat.timeout,
+				"inflight", q.heartbeat.size())
+		}
+	}
+}
+
 type application struct {
 	health *health
 	cfg    config
@@ -459,28 +671,59 @@ type receiver interface {
 }
 
 type publisher interface {
-	publish(q *queue, messages []message) ([]message, error)
+	publish(q *queue, messages []message) ([]message, []publishFailure, error)
 }
 
 type deleter interface {
 	delete(q *queue, messages []message) ([]message, error)
 }
 
+// visibilityChange requests a new visibility timeout for a message.
+type visibilityChange struct {
+	msg     message
+	timeout time.Duration
+}
+
+type visibilityChanger interface {
+	changeVisibility(q *queue, changes []visibilityChange) ([]message, error)
+}
+
+type quarantiner interface {
+	quarantine(q *queue, messages []message, reason string) ([]message, error)
+}
+
+// queueClients holds the clients a queue uses to receive, publish and delete.
+type queueClients struct {
+	receive      receiver
+	newPublisher func(target string) publisher // called for every destination
+	delete       deleter
+	visibility   visibilityChanger
+	quarantine   quarantiner     // nil if quarantine is not configured
+	claimCheck   claimCheckStore // nil if claim check is not configured
+}
+
 type queue struct {
-	queueCfg        queueConfig
-	publishCh       chan message
-	deleteCh        chan message
-	readers         atomic.Int64
-	publishers      atomic.Int64
-	janitors        atomic.Int64
-	publishPool     pool
-	deletePool      pool
-	lastPublishUnix atomic.Int64
-	lastDeleteUnix  atomic.Int64
-
-	receive receiver
-	publish publisher
-	delete  deleter
+	queueCfg       queueConfig
+	options        messageOptions // converts SQS messages, built once from queueCfg
+	destinations   []*destination
+	deleteCh       chan message
+	readers        atomic.Int64
+	janitors       atomic.Int64
+	deletePool     pool
+	lastDeleteUnix atomic.Int64
+
+	receive    receiver
+	delete     deleter
+	visibility visibilityChanger
+	quarantine quarantiner
+	claimCheck claimCheckStore
+
+	claimCheckCh chan message // nil if claim check is not configured
+
+	heartbeat *visibilityHeartbeat // nil if disabled
+	tracker   *fanoutTracker       // nil if a single destination, or routed
+	router    *router              // nil if no routes
+	archiver  *archiver            // nil if archive is disabled
 
 	logger *slog.Logger
 
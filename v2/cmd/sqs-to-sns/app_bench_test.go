@@ -44,8 +44,8 @@ func BenchmarkEngine(b *testing.B) {
 		wg.Done()
 	}}
 
-	app := newApp(cfg, func(_ queueConfig) (receiver, publisher, deleter) {
-		return benchReader, benchPub, benchDel
+	app := newApp(cfg, func(_ queueConfig) queueClients {
+		return queueClients{receive: benchReader, newPublisher: func(string) publisher { return benchPub }, delete: benchDel}
 	})
 
 	b.ResetTimer()
@@ -87,8 +87,8 @@ func TestGracefulShutdown(t *testing.T) {
 	}}
 	benchReader := &benchReceiver{total: numMessages}
 
-	app := newApp(cfg, func(_ queueConfig) (receiver, publisher, deleter) {
-		return benchReader, benchPub, benchDel
+	app := newApp(cfg, func(_ queueConfig) queueClients {
+		return queueClients{receive: benchReader, newPublisher: func(string) publisher { return benchPub }, delete: benchDel}
 	})
 
 	app.run()
@@ -150,11 +150,11 @@ type benchPublisher struct {
 	delay time.Duration
 }
 
-func (p *benchPublisher) publish(_ *queue, m []message) ([]message, error) {
+func (p *benchPublisher) publish(_ *queue, m []message) ([]message, []publishFailure, error) {
 	if p.delay > 0 {
 		time.Sleep(p.delay) // Simulate AWS network lag
 	}
-	return m, nil
+	return m, nil, nil
 }
 
 type benchDeleter struct {
@@ -38,8 +38,12 @@ func TestApp(t *testing.T) {
 	del := &deleterMock{}
 
 	app := newApp(cfg,
-		func(_ queueConfig) (receiver, publisher, deleter) {
-			return &receiverMock{latency: 10 * time.Millisecond, amount: 10}, pub, del
+		func(_ queueConfig) queueClients {
+			return queueClients{
+				receive:      &receiverMock{latency: 10 * time.Millisecond, amount: 10},
+				newPublisher: func(string) publisher { return pub },
+				delete:       del,
+			}
 		},
 	)
 
@@ -92,12 +96,12 @@ func (p *publisherMock) getMessages() int {
 	return p.messages
 }
 
-func (p *publisherMock) publish(_ *queue, msg []message) ([]message, error) {
+func (p *publisherMock) publish(_ *queue, msg []message) ([]message, []publishFailure, error) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	p.publishes++
 	p.messages += len(msg)
-	return msg, nil
+	return msg, nil, nil
 }
 
 type receiverMock struct {
@@ -192,7 +196,6 @@ func TestStartReaderReceiveErrorStats(t *testing.T) {
 			queueCfg: queueConfig{
 				ReceiveErrorCooldown: 1 * time.Millisecond,
 			},
-			publishCh: make(chan message, 1),
 			receive: &receiverErrMock{
 				err:      context.Canceled,
 				mustStop: true,
@@ -201,6 +204,7 @@ func TestStartReaderReceiveErrorStats(t *testing.T) {
 		}
 
 		initStats(&q.stats)
+		newTestDestination(q, &publisherMock{})
 		q.readers.Add(1)
 
 		app := &application{}
@@ -221,12 +225,12 @@ func TestStartReaderReceiveErrorStats(t *testing.T) {
 			queueCfg: queueConfig{
 				ReceiveErrorCooldown: 1 * time.Millisecond,
 			},
-			publishCh: make(chan message, 1),
-			receive:   recv,
-			logger:    slog.Default(),
+			receive: recv,
+			logger:  slog.Default(),
 		}
 
 		initStats(&q.stats)
+		newTestDestination(q, &publisherMock{})
 		q.readers.Add(1)
 
 		done := make(chan struct{})
@@ -258,3 +262,20 @@ func TestStartReaderReceiveErrorStats(t *testing.T) {
 		}
 	})
 }
+
+// newTestDestination gives q a single destination publishing through pub.
+func newTestDestination(q *queue, pub publisher) *destination {
+	d := newDestination(0, "topic1", q.queueCfg, 0, pub, slog.Default())
+	q.destinations = []*destination{d}
+	return d
+}
+
+// newTestMessageOptions builds the message options for cfg.
+func newTestMessageOptions(t *testing.T, cfg queueConfig) messageOptions {
+	t.Helper()
+	opt, err := newMessageOptions(cfg)
+	if err != nil {
+		t.Fatalf("message options: %v", err)
+	}
+	return opt
+}
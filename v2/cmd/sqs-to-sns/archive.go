@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/udhos/sqs-to-sns/v2/snsutils"
+)
+
+const (
+	defaultArchiveMaxBytes   = 100 * 1024 * 1024
+	defaultArchiveMaxAge     = time.Hour
+	defaultArchiveBufferSize = 10000
+)
+
+// archiveFlushInterval bounds how long archived lines wait in the gzip
+// buffer, and how late a file is rotated by max_age.
+const archiveFlushInterval = 5 * time.Second
+
+// archiveConfig records every published message to gzip-compressed JSON
+// lines files, as a forensic trail of what went out to each destination.
+type archiveConfig struct {
+	Path       string        `yaml:"path"`        // file prefix, empty disables
+	MaxBytes   int64         `yaml:"max_bytes"`   // uncompressed bytes per file (default 100 MiB)
+	MaxAge     time.Duration `yaml:"max_age"`     // per file (default 1h)
+	BufferSize int           `yaml:"buffer_size"` // messages waiting for the archive (default 10000)
+}
+
+func (a archiveConfig) enabled() bool {
+	return a.Path != ""
+}
+
+func validateArchive(q queueConfig) error {
+	a := q.Archive
+	if !a.enabled() {
+		return nil
+	}
+	if a.MaxAge < time.Second {
+		return fmt.Errorf("archive max_age=%v must be at least 1s", a.MaxAge)
+	}
+	if info, err := os.Stat(filepath.Dir(a.Path)); err != nil || !info.IsDir() {
+		return fmt.Errorf("archive path=%s: missing directory", a.Path)
+	}
+	return nil
+}
+
+// archiveRecord is a line of the archive: the published entry as a
+// snsutils.Record, readable by publish-batch -input, plus where and when
+// it was published.
+type archiveRecord struct {
+	snsutils.Record
+	Destination        string    `json:"Destination"`
+	PublishedMessageID string    `json:"PublishedMessageId,omitempty"`
+	PublishedAt        time.Time `json:"PublishedAt"`
+}
+
+// archiveEntry is a published message waiting for the archive.
+type archiveEntry struct {
+	msg         message
+	destination string
+	publishedAt time.Time
+}
+
+// archiver writes the archive of a queue from its own bounded channel,
+// so a slow disk drops archive lines instead of holding publishers.
+type archiver struct {
+	cfg     archiveConfig
+	queueID string
+	ch      chan archiveEntry
+	stop    chan struct{}
+	done    chan struct{}
+
+	file    *os.File
+	gz      *gzip.Writer
+	buf     *bufio.Writer
+	size    int64 // uncompressed bytes in the current file
+	created time.Time
+}
+
+func newArchiver(cfg archiveConfig, queueID string) *archiver {
+	return &archiver{
+		cfg:     cfg,
+		queueID: queueID,
+		ch:      make(chan archiveEntry, cfg.BufferSize),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// archive hands published messages to the archiver, without blocking.
+// An aggregate is archived as its source messages, so the archive is
+// searchable by SQS MessageId. They carry the aggregate PublishedMessageId.
+func (app *application) archive(q *queue, d *destination, msg []message) {
+	if q.archiver == nil {
+		return
+	}
+	now := time.Now()
+	send := func(m message) {
+		select {
+		case q.archiver.ch <- archiveEntry{msg: m, destination: d.target, publishedAt: now}:
+		default:
+			q.stats.archiveDrops.Add(1) // Archive is behind
+		}
+	}
+	for _, m := range msg {
+		parts := m.parts
+		if parts == nil {
+			send(m)
+			continue
+		}
+		for _, part := range parts {
+			part.publishedID = m.publishedID
+			send(part)
+		}
+	}
+}
+
+// startArchiver writes archive entries until stopArchivers.
+func (app *application) startArchiver(q *queue) {
+	const me = "archiver"
+
+	a := q.archiver
+	defer close(a.done)
+
+	ticker := time.NewTicker(archiveFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case e := <-a.ch:
+			if err := a.write(e); err != nil {
+				q.stats.archiveErrors.Add(1)
+				q.logger.Error(me, "error", err)
+				continue
+			}
+			q.stats.archivedMessages.Add(1)
+		case <-ticker.C:
+			if err := a.flush(); err != nil {
+				q.stats.archiveErrors.Add(1)
+				q.logger.Error(me, "error", err)
+			}
+		case <-a.stop:
+		drain:
+			for {
+				select {
+				case e := <-a.ch:
+					if err := a.write(e); err == nil {
+						q.stats.archivedMessages.Add(1)
+					}
+				default:
+					break drain
+				}
+			}
+			if err := a.close(); err != nil {
+				q.logger.Error(me, "error", err)
+			}
+			return
+		}
+	}
+}
+
+// stopArchivers writes the pending archive entries, and closes the files.
+func (app *application) stopArchivers() {
+	for _, q := range app.queues {
+		if q.archiver != nil {
+			close(q.archiver.stop)
+			<-q.archiver.done
+		}
+	}
+}
+
+func (a *archiver) write(e archiveEntry) error {
+	r := archiveRecord{
+		Record: snsutils.NewRecord(aws.ToString(e.msg.sqsMessage.MessageId), *e.msg.snsBatchEntry,
+			e.msg.sqsMessage.Attributes, e.msg.receivedAt, a.queueID),
+		Destination:        e.destination,
+		PublishedMessageID: e.msg.publishedID,
+		PublishedAt:        e.publishedAt.UTC(),
+	}
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	if a.file != nil && (a.size+int64(len(line)) > a.cfg.MaxBytes || time.Since(a.created) >= a.cfg.MaxAge) {
+		if err := a.close(); err != nil {
+			return err
+		}
+	}
+	if a.file == nil {
+		if err := a.open(); err != nil {
+			return err
+		}
+	}
+
+	n, err := a.buf.Write(line)
+	a.size += int64(n)
+	return err
+}
+
+// open starts a new file, named by the path prefix and the UTC time.
+func (a *archiver) open() error {
+	now := time.Now()
+	name := a.cfg.Path + "." + now.UTC().Format(rotateSuffixFormat) + ".jsonl.gz"
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o640)
+	if err != nil {
+		return err
+	}
+	a.file = f
+	a.gz = gzip.NewWriter(f)
+	a.buf = bufio.NewWriter(a.gz)
+	a.size = 0
+	a.created = now
+	return nil
+}
+
+// flush pushes buffered lines through gzip to the file, so they can be
+// read before the file completes, and rotates a file older than max_age.
+func (a *archiver) flush() error {
+	if a.file == nil {
+		return nil
+	}
+	if time.Since(a.created) >= a.cfg.MaxAge {
+		return a.close()
+	}
+	if err := a.buf.Flush(); err != nil {
+		return err
+	}
+	return a.gz.Flush()
+}
+
+// close completes the current file, if any.
+func (a *archiver) close() error {
+	if a.file == nil {
+		return nil
+	}
+	errBuf := a.buf.Flush()
+	errGz := a.gz.Close()
+	errFile := a.file.Close()
+	a.file = nil
+	return errors.Join(errBuf, errGz, errFile)
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+func newArchiveTestQueue(t *testing.T, archive archiveConfig) *queue {
+	q := &queue{
+		queueCfg: queueDefaults(queueConfig{
+			ID:         "q1",
+			TopicArn:   "topic1",
+			NackPolicy: nackPolicyImmediate,
+			Archive:    archive,
+		}),
+		deleteCh:   make(chan message, 100),
+		visibility: &visibilityMock{},
+		logger:     slog.Default(),
+	}
+	if err := validateQueueConfig(q.queueCfg); err != nil {
+		t.Fatalf("config: %v", err)
+	}
+	initStats(&q.stats)
+	q.archiver = newArchiver(q.queueCfg.Archive, q.queueCfg.ID)
+	return q
+}
+
+func readArchive(t *testing.T, prefix string) ([]string, []archiveRecord) {
+	files, _ := filepath.Glob(prefix + ".*.jsonl.gz")
+	var records []archiveRecord
+	for _, name := range files {
+		f, err := os.Open(name)
+		if err != nil {
+			t.Fatalf("open: %v", err)
+		}
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatalf("gzip %s: %v", name, err)
+		}
+		scanner := bufio.NewScanner(gz)
+		for scanner.Scan() {
+			var r archiveRecord
+			if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+				t.Fatalf("line %q: %v", scanner.Text(), err)
+			}
+			records = append(records, r)
+		}
+		if err := scanner.Err(); err != nil {
+			t.Fatalf("read %s: %v", name, err)
+		}
+		f.Close()
+	}
+	return files, records
+}
+
+// go test -count 1 -run '^TestArchive$' ./...
+func TestArchive(t *testing.T) {
+	prefix := filepath.Join(t.TempDir(), "q1")
+	q := newArchiveTestQueue(t, archiveConfig{Path: prefix, MaxBytes: 1000})
+	d := newTestDestination(q, &publisherMock{})
+	app := &application{queues: []*queue{q}}
+
+	go app.startArchiver(q)
+
+	for i := range 6 {
+		m, err := newMessage(&sqstypes.Message{
+			MessageId:  aws.String(fmt.Sprintf("id%d", i)),
+			Body:       aws.String(fmt.Sprintf(`{"n":%d}`, i)),
+			Attributes: map[string]string{"SentTimestamp": "1700000000000"},
+		}, time.Now(), newTestMessageOptions(t, q.queueCfg), 0)
+		if err != nil {
+			t.Fatalf("new message: %v", err)
+		}
+		m.delivery = &delivery{}
+		m.delivery.pending.Store(1)
+		app.batchPublish(q, d, []message{m})
+	}
+
+	app.stopArchivers()
+
+	if deleted := len(q.deleteCh); deleted != 6 {
+		t.Errorf("expected 6 messages deleted, got %d", deleted)
+	}
+
+	files, records := readArchive(t, prefix)
+	if len(files) < 2 {
+		t.Errorf("expected rotation by max_bytes, got files %v", files)
+	}
+	if len(records) != 6 {
+		t.Fatalf("expected 6 archived messages, got %d", len(records))
+	}
+	for i, r := range records {
+		if r.MessageID != fmt.Sprintf("id%d", i) || r.Destination != "topic1" || r.QueueID != "q1" ||
+			r.Body != fmt.Sprintf(`{"n":%d}`, i) || r.PublishedAt.IsZero() ||
+			r.Attributes["SentTimestamp"] != "1700000000000" {
+			t.Errorf("unexpected record %d: %+v", i, r)
+		}
+	}
+
+	if archived := q.stats.archivedMessages.Load(); archived != 6 {
+		t.Errorf("expected archived_messages=6, got %d", archived)
+	}
+}
+
+// go test -count 1 -run '^TestArchiveDrops$' ./...
+func TestArchiveDrops(t *testing.T) {
+	q := newArchiveTestQueue(t, archiveConfig{Path: filepath.Join(t.TempDir(), "q1"), BufferSize: 2})
+	d := newTestDestination(q, &publisherMock{})
+	app := &application{}
+
+	msg := make([]message, 5)
+	for i := range msg {
+		msg[i] = message{
+			sqsMessage:  &sqstypes.Message{MessageId: aws.String(getRandomID())},
+			publishedID: getRandomID(),
+		}
+	}
+
+	// archiver not running: the channel fills up, publishing goes on
+	app.archive(q, d, msg)
+
+	if drops := q.stats.archiveDrops.Load(); drops != 3 {
+		t.Errorf("expected archive_drops=3, got %d", drops)
+	}
+	if e := <-q.archiver.ch; e.msg.publishedID != msg[0].publishedID || e.destination != "topic1" {
+		t.Errorf("unexpected archive entry: %+v", e)
+	}
+}
+
+// go test -count 1 -run '^TestArchiveAggregate$' ./...
+func TestArchiveAggregate(t *testing.T) {
+	q := newArchiveTestQueue(t, archiveConfig{Path: filepath.Join(t.TempDir(), "q1")})
+	d := newTestDestination(q, &publisherMock{})
+	app := &application{}
+
+	parts := make([]message, 3)
+	for i := range parts {
+		parts[i], _ = createTestMessage(10)
+	}
+	aggregate := newAggregate(aggregateJSONArray, parts)
+	aggregate.publishedID = getRandomID()
+
+	app.archive(q, d, []message{aggregate})
+
+	if len(q.archiver.ch) != len(parts) {
+		t.Fatalf("expected %d archive entries, got %d", len(parts), len(q.archiver.ch))
+	}
+	for i, part := range parts {
+		e := <-q.archiver.ch
+		if e.msg.sqsMessage != part.sqsMessage || e.msg.publishedID != aggregate.publishedID {
+			t.Errorf("entry %d: expected source message with aggregate published id, got %+v", i, e.msg)
+		}
+	}
+}
+
+// go test -count 1 -run '^TestValidateArchive$' ./...
+func TestValidateArchive(t *testing.T) {
+	dir := t.TempDir()
+
+	table := []struct {
+		name  string
+		cfg   archiveConfig
+		valid bool
+	}{
+		{"disabled", archiveConfig{}, true},
+		{"enabled", archiveConfig{Path: filepath.Join(dir, "q1")}, true},
+		{"missing directory", archiveConfig{Path: filepath.Join(dir, "missing", "q1")}, false},
+		{"short max_age", archiveConfig{Path: filepath.Join(dir, "q1"), MaxAge: time.Millisecond}, false},
+	}
+	for _, data := range table {
+		q := queueDefaults(queueConfig{TopicArn: "t", Archive: data.cfg})
+		if err := validateArchive(q); (err == nil) != data.valid {
+			t.Errorf("%s: expected valid=%t, got error: %v", data.name, data.valid, err)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"text/template"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	snstypes "github.com/aws/aws-sdk-go-v2/service/sns/types"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// attributesConfig shapes the message attributes copied from SQS to SNS.
+//
+// Copied attributes are first filtered by name: an attribute is kept if
+// it matches some include glob (or include is empty) and no exclude glob.
+// Kept attributes are then renamed. Then, add sets String attributes
+// from text/template values, replacing attributes with the same name.
+// Finally, entries over max attributes get the overflow strategy.
+type attributesConfig struct {
+	Include  []string          `yaml:"include"`  // globs, like "app_*"
+	Exclude  []string          `yaml:"exclude"`  // globs
+	Rename   map[string]string `yaml:"rename"`   // old name: new name
+	Add      map[string]string `yaml:"add"`      // name: template, like "{{.QueueID}}"
+	Max      int               `yaml:"max"`      // 1..10 (default 10)
+	Overflow string            `yaml:"overflow"` // fail, drop, pack
+	Priority []string          `yaml:"priority"` // globs, highest priority first
+}
+
+// attributeTemplateData is available to attributes.add templates.
+type attributeTemplateData struct {
+	QueueID   string
+	QueueURL  string
+	Hostname  string
+	MessageID string
+}
+
+var hostname, _ = os.Hostname()
+
+func (a attributesConfig) validate() error {
+	for _, glob := range append(a.Include, a.Exclude...) {
+		if !validGlob(glob) {
+			return fmt.Errorf("attributes glob %q is invalid", glob)
+		}
+	}
+	for from, to := range a.Rename {
+		if to == "" {
+			return fmt.Errorf("attributes rename %s to empty name", from)
+		}
+	}
+	if _, err := parseAttributeTemplates(a.Add); err != nil {
+		return err
+	}
+	return validateAttributeLimit(a)
+}
+
+func validGlob(glob string) bool {
+	_, err := path.Match(glob, "")
+	return err == nil
+}
+
+// keep reports whether an attribute passes the include and exclude globs.
+func (a attributesConfig) keep(name string) bool {
+	if len(a.Include) > 0 && !matchAny(a.Include, name) {
+		return false
+	}
+	return !matchAny(a.Exclude, name)
+}
+
+func matchAny(globs []string, name string) bool {
+	for _, glob := range globs {
+		if found, _ := path.Match(glob, name); found {
+			return true
+		}
+	}
+	return false
+}
+
+// copyAttributes converts the SQS attributes kept by the filters,
+// applying renames.
+func (a attributesConfig) copyAttributes(src map[string]sqstypes.MessageAttributeValue) map[string]snstypes.MessageAttributeValue {
+	attr := map[string]snstypes.MessageAttributeValue{}
+	for k, v := range src {
+		if !a.keep(k) {
+			continue
+		}
+		if to, found := a.Rename[k]; found {
+			k = to
+		}
+		attr[k] = snstypes.MessageAttributeValue{
+			DataType:    v.DataType,
+			BinaryValue: v.BinaryValue,
+			StringValue: v.StringValue,
+		}
+	}
+	return attr
+}
+
+// parseAttributeTemplates parses attributes.add templates by attribute name.
+func parseAttributeTemplates(add map[string]string) (map[string]*template.Template, error) {
+	templates := map[string]*template.Template{}
+	for name, text := range add {
+		if name == "" {
+			return nil, errors.New("attributes add requires name")
+		}
+		tmpl, err := template.New("attribute").Option("missingkey=error").Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("attributes add %s: %w", name, err)
+		}
+		templates[name] = tmpl
+	}
+	return templates, nil
+}
+
+// addAttributes renders attributes.add templates into attr.
+func addAttributes(attr map[string]snstypes.MessageAttributeValue,
+	templates map[string]*template.Template, data attributeTemplateData) error {
+
+	for name, tmpl := range templates {
+		var sb strings.Builder
+		if err := tmpl.Execute(&sb, data); err != nil {
+			return fmt.Errorf("attributes add %s: %w", name, err)
+		}
+		attr[name] = snstypes.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(sb.String()),
+		}
+	}
+	return nil
+}
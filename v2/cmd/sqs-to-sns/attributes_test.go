@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/udhos/sqs-to-sns/v2/snsutils"
+)
+
+// go test -count 1 -run '^TestNewMessageAttributes$' ./...
+func TestNewMessageAttributes(t *testing.T) {
+	str := func(v string) sqstypes.MessageAttributeValue {
+		return sqstypes.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(v)}
+	}
+
+	sqsMsg := &sqstypes.Message{
+		MessageId: aws.String("id1"),
+		Body:      aws.String("body"),
+		MessageAttributes: map[string]sqstypes.MessageAttributeValue{
+			"app_tenant":    str("t1"),
+			"app_internal":  str("secret"),
+			"trace_id":      str("x"),
+			"app_event_ver": str("2"),
+		},
+	}
+
+	opt := messageOptions{
+		copyAttributes: true,
+		queueID:        "q1",
+		attributes: attributesConfig{
+			Include: []string{"app_*"},
+			Exclude: []string{"*_internal"},
+			Rename:  map[string]string{"app_tenant": "tenant"},
+			Add: map[string]string{
+				"source":  "{{.QueueID}}",
+				"relayed": "{{.MessageID}}@{{.Hostname}}",
+			},
+		},
+	}
+	if err := opt.attributes.validate(); err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+	addTemplates, err := parseAttributeTemplates(opt.attributes.Add)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	opt.addTemplates = addTemplates
+
+	m, err := newMessage(sqsMsg, time.Now(), opt, 0)
+	if err != nil {
+		t.Fatalf("new message: %v", err)
+	}
+
+	attr := m.snsBatchEntry.MessageAttributes
+
+	expected := map[string]string{
+		"tenant":        "t1",
+		"app_event_ver": "2",
+		"source":        "q1",
+		"relayed":       "id1@" + hostname,
+	}
+	if len(attr) != len(expected) {
+		t.Errorf("expected %d attributes, got %v", len(expected), attr)
+	}
+	for k, v := range expected {
+		if got := aws.ToString(attr[k].StringValue); got != v {
+			t.Errorf("attribute %s: expected=%q got=%q", k, v, got)
+		}
+	}
+
+	// sizing runs on the final attributes
+	const debug = false
+	_, _, total, _ := snsutils.GetSNSPayloadSize(*m.snsBatchEntry, debug)
+	if m.snsPayloadSize != total {
+		t.Errorf("expected size=%d got=%d", total, m.snsPayloadSize)
+	}
+
+	// render error is a transform error
+	opt.addTemplates, err = parseAttributeTemplates(map[string]string{"bad": "{{.Missing}}"})
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if _, err := newMessage(sqsMsg, time.Now(), opt, 0); !errors.Is(err, errTransform) {
+		t.Errorf("expected transform error, got %v", err)
+	}
+}
+
+// go test -count 1 -run '^TestAttributesValidate$' ./...
+func TestAttributesValidate(t *testing.T) {
+	table := []struct {
+		name  string
+		cfg   attributesConfig
+		valid bool
+	}{
+		{"empty", attributesConfig{}, true},
+		{"bad glob", attributesConfig{Include: []string{"a["}}, false},
+		{"empty rename", attributesConfig{Rename: map[string]string{"a": ""}}, false},
+		{"bad template", attributesConfig{Add: map[string]string{"a": "{{.QueueID"}}, false},
+	}
+	for _, data := range table {
+		if err := data.cfg.validate(); (err == nil) != data.valid {
+			t.Errorf("%s: expected valid=%t, got error: %v", data.name, data.valid, err)
+		}
+	}
+}
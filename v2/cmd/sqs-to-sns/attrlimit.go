@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	snstypes "github.com/aws/aws-sdk-go-v2/service/sns/types"
+)
+
+// Strategies for entries over the message attribute limit.
+const (
+	// attributeOverflowFail sends the message to the failure path,
+	// see errTransform.
+	attributeOverflowFail = "fail"
+
+	// attributeOverflowDrop drops the lowest-priority attributes.
+	attributeOverflowDrop = "drop"
+
+	// attributeOverflowPack keeps the highest-priority attributes and
+	// packs the others as JSON into the single attribute attrPacked.
+	attributeOverflowPack = "pack"
+)
+
+// attrPacked holds attributes packed by attributeOverflowPack, as a JSON
+// object in the SNS envelope format: {"name":{"Type":"String","Value":"v"}}.
+// Binary values are base64-encoded.
+const attrPacked = "sqs_to_sns_attributes"
+
+var errTooManyAttributes = errors.New("too many message attributes")
+
+func validateAttributeLimit(a attributesConfig) error {
+	if a.Max < 0 || a.Max > maxMessageAttributes {
+		return fmt.Errorf("attributes max=%d must be between 1 and %d", a.Max, maxMessageAttributes)
+	}
+	switch a.Overflow {
+	case "", attributeOverflowFail, attributeOverflowDrop, attributeOverflowPack:
+	default:
+		return fmt.Errorf("attributes overflow=%q must be one of: %s, %s, %s",
+			a.Overflow, attributeOverflowFail, attributeOverflowDrop, attributeOverflowPack)
+	}
+	for _, glob := range a.Priority {
+		if !validGlob(glob) {
+			return fmt.Errorf("attributes priority glob %q is invalid", glob)
+		}
+	}
+	return nil
+}
+
+// attributeLimit returns the effective attribute limit.
+func (a attributesConfig) attributeLimit() int {
+	if a.Max < 1 {
+		return maxMessageAttributes
+	}
+	return a.Max
+}
+
+// limitAttributes enforces the attribute limit on an entry. It reports
+// whether the entry was over the limit.
+func (a attributesConfig) limitAttributes(snsEntry *snstypes.PublishBatchRequestEntry) (bool, error) {
+	limit := a.attributeLimit()
+	attr := snsEntry.MessageAttributes
+
+	if len(attr) <= limit {
+		return false, nil
+	}
+
+	switch a.Overflow {
+	case attributeOverflowFail:
+		return true, fmt.Errorf("%w: %w: %d > %d", errTransform, errTooManyAttributes, len(attr), limit)
+	case attributeOverflowDrop:
+		for _, name := range a.byPriority(attr)[limit:] {
+			delete(attr, name)
+		}
+	default:
+		packed := map[string]struct {
+			Type  string `json:"Type"`
+			Value string `json:"Value"`
+		}{}
+		for _, name := range a.byPriority(attr)[limit-1:] {
+			v := attr[name]
+			value := aws.ToString(v.StringValue)
+			if v.BinaryValue != nil {
+				value = base64.StdEncoding.EncodeToString(v.BinaryValue)
+			}
+			packed[name] = struct {
+				Type  string `json:"Type"`
+				Value string `json:"Value"`
+			}{aws.ToString(v.DataType), value}
+			delete(attr, name)
+		}
+		data, err := json.Marshal(packed)
+		if err != nil {
+			return true, fmt.Errorf("%w: pack attributes: %w", errTransform, err)
+		}
+		attr[attrPacked] = snstypes.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(string(data)),
+		}
+	}
+
+	return true, nil
+}
+
+// byPriority sorts attribute names, highest priority first. The rank of
+// a name is the first priority glob it matches, names matching no glob
+// come last. Ties are sorted by name.
+func (a attributesConfig) byPriority(attr map[string]snstypes.MessageAttributeValue) []string {
+	rank := func(name string) int {
+		for i, glob := range a.Priority {
+			if matchAny([]string{glob}, name) {
+				return i
+			}
+		}
+		return len(a.Priority)
+	}
+
+	names := make([]string, 0, len(attr))
+	for name := range attr {
+		names = append(names, name)
+	}
+
+	slices.SortFunc(names, func(x, y string) int {
+		if rx, ry := rank(x), rank(y); rx != ry {
+			return rx - ry
+		}
+		return strings.Compare(x, y)
+	})
+
+	return names
+}
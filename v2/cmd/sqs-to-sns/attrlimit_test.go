@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/udhos/sqs-to-sns/v2/snsutils"
+)
+
+func newAttrLimitTestMessage(count int) *sqstypes.Message {
+	sqsMsg := &sqstypes.Message{
+		MessageId:         aws.String("id1"),
+		Body:              aws.String("body"),
+		MessageAttributes: map[string]sqstypes.MessageAttributeValue{},
+	}
+	for i := range count {
+		sqsMsg.MessageAttributes[fmt.Sprintf("a%02d", i)] = sqstypes.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(fmt.Sprintf("v%d", i)),
+		}
+	}
+	return sqsMsg
+}
+
+// go test -count 1 -run '^TestAttributeOverflow$' ./...
+func TestAttributeOverflow(t *testing.T) {
+	sqsMsg := newAttrLimitTestMessage(12)
+
+	opt := messageOptions{copyAttributes: true}
+
+	// pack (default) keeps every attribute
+	m, errDefault := newMessage(sqsMsg, time.Now(), opt, 0)
+	if errDefault != nil {
+		t.Fatalf("default: %v", errDefault)
+	}
+	if got := len(m.snsBatchEntry.MessageAttributes); got != maxMessageAttributes || !m.overflow {
+		t.Errorf("default: expected %d attributes and overflow, got %d overflow=%t",
+			maxMessageAttributes, got, m.overflow)
+	}
+	if _, found := m.snsBatchEntry.MessageAttributes[attrPacked]; !found {
+		t.Errorf("default: expected packed attributes")
+	}
+
+	// a message at the SNS limit is left as is
+	m, _ = newMessage(newAttrLimitTestMessage(maxMessageAttributes), time.Now(), opt, 0)
+	if m.overflow || len(m.snsBatchEntry.MessageAttributes) != maxMessageAttributes {
+		t.Errorf("expected no overflow at the SNS limit")
+	}
+
+	// fail
+	opt.attributes = attributesConfig{Overflow: attributeOverflowFail}
+	_, err := newMessage(sqsMsg, time.Now(), opt, 0)
+	if !errors.Is(err, errTransform) || !errors.Is(err, errTooManyAttributes) {
+		t.Errorf("expected too many attributes transform error, got %v", err)
+	}
+
+	// drop keeps the highest priority
+	opt.attributes = attributesConfig{Max: 9, Overflow: attributeOverflowDrop, Priority: []string{"a11", "a1*"}}
+	m, errDrop := newMessage(sqsMsg, time.Now(), opt, 0)
+	if errDrop != nil {
+		t.Fatalf("drop: %v", errDrop)
+	}
+	attr := m.snsBatchEntry.MessageAttributes
+	if len(attr) != 9 || !m.overflow {
+		t.Errorf("drop: expected 9 attributes and overflow, got %d overflow=%t", len(attr), m.overflow)
+	}
+	for _, name := range []string{"a11", "a10", "a00", "a06"} {
+		if _, found := attr[name]; !found {
+			t.Errorf("drop: expected attribute %s kept", name)
+		}
+	}
+	for _, name := range []string{"a07", "a08", "a09"} {
+		if _, found := attr[name]; found {
+			t.Errorf("drop: expected attribute %s dropped", name)
+		}
+	}
+
+	// pack keeps max-1 and packs the rest
+	opt.attributes.Overflow = attributeOverflowPack
+	m, errPack := newMessage(sqsMsg, time.Now(), opt, 0)
+	if errPack != nil {
+		t.Fatalf("pack: %v", errPack)
+	}
+	attr = m.snsBatchEntry.MessageAttributes
+	if len(attr) != 9 {
+		t.Errorf("pack: expected 9 attributes, got %d", len(attr))
+	}
+	var packed map[string]snsutils.EnvelopeAttribute
+	if err := json.Unmarshal([]byte(aws.ToString(attr[attrPacked].StringValue)), &packed); err != nil {
+		t.Fatalf("pack: decode: %v", err)
+	}
+	if len(packed) != 4 || packed["a09"].Value != "v9" || packed["a06"].Type != "String" {
+		t.Errorf("pack: unexpected packed attributes: %v", packed)
+	}
+
+	// sizing runs on the final attributes
+	const debug = false
+	_, _, total, _ := snsutils.GetSNSPayloadSize(*m.snsBatchEntry, debug)
+	if m.snsPayloadSize != total {
+		t.Errorf("expected size=%d got=%d", total, m.snsPayloadSize)
+	}
+
+	// under the limit
+	m, _ = newMessage(newAttrLimitTestMessage(9), time.Now(), opt, 0)
+	if m.overflow || len(m.snsBatchEntry.MessageAttributes) != 9 {
+		t.Errorf("expected no overflow under the limit")
+	}
+}
+
+// go test -count 1 -run '^TestAttributeLimitValidate$' ./...
+func TestAttributeLimitValidate(t *testing.T) {
+	table := []struct {
+		name  string
+		cfg   attributesConfig
+		valid bool
+	}{
+		{"default", attributesConfig{}, true},
+		{"max", attributesConfig{Max: 9, Overflow: attributeOverflowPack}, true},
+		{"max too big", attributesConfig{Max: 11}, false},
+		{"max at the SNS limit", attributesConfig{Max: maxMessageAttributes}, true},
+		{"bad overflow", attributesConfig{Overflow: "truncate"}, false},
+		{"bad priority", attributesConfig{Priority: []string{"a["}}, false},
+	}
+	for _, data := range table {
+		if err := data.cfg.validate(); (err == nil) != data.valid {
+			t.Errorf("%s: expected valid=%t, got error: %v", data.name, data.valid, err)
+		}
+	}
+}
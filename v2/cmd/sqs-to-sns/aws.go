@@ -2,12 +2,18 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	ebtypes "github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/sns"
 	snstypes "github.com/aws/aws-sdk-go-v2/service/sns/types"
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
@@ -78,21 +84,87 @@ func (d *deleterReal) delete(q *queue, msg []message) ([]message, error) {
 	// We return the list of messages we successfully deleted from SQS.
 	// The caller uses this information for debug logging.
 
-	// Create a map of successful IDs for fast lookup
-	successIDs := make(map[string]struct{}, len(resp.Successful))
-	for _, s := range resp.Successful {
-		successIDs[aws.ToString(s.Id)] = struct{}{}
+	successIDs := make([]*string, len(resp.Successful))
+	for i, s := range resp.Successful {
+		successIDs[i] = s.Id
 	}
 
-	successMessages := make([]message, 0, len(resp.Successful))
-	for i, m := range msg {
-		entryID := getBatchEntryID(aws.ToString(m.sqsMessage.MessageId), i)
-		if _, ok := successIDs[entryID]; ok {
-			successMessages = append(successMessages, m)
+	return successfulMessages(msg, successIDs), nil
+}
+
+//
+// visibility changer
+//
+
+type visibilityReal struct {
+	awsAPITimeout time.Duration
+	sqsClient     *sqs.Client
+}
+
+func (v *visibilityReal) changeVisibility(q *queue, changes []visibilityChange) ([]message, error) {
+	const me = "visibilityReal.changeVisibility"
+
+	if len(changes) == 0 {
+		return nil, errors.New("visibilityReal.changeVisibility: unexpected empty message list")
+	}
+
+	entries := make([]sqstypes.ChangeMessageVisibilityBatchRequestEntry, len(changes))
+	for i, c := range changes {
+
+		// Combine messageId with index to get traceability and stronger uniqueness.
+		entryID := getBatchEntryID(aws.ToString(c.msg.sqsMessage.MessageId), i)
+
+		entries[i] = sqstypes.ChangeMessageVisibilityBatchRequestEntry{
+			Id:                aws.String(entryID),
+			ReceiptHandle:     c.msg.sqsMessage.ReceiptHandle,
+			VisibilityTimeout: int32(c.timeout.Seconds()),
 		}
 	}
 
-	return successMessages, nil
+	input := &sqs.ChangeMessageVisibilityBatchInput{
+		QueueUrl: aws.String(q.queueCfg.QueueURL),
+		Entries:  entries,
+	}
+
+	// Need a new context for the 30s timeout.
+	// This timeout sole purpose is to guard against forever blocked api call.
+	ctx, cancel := context.WithTimeout(context.Background(), v.awsAPITimeout)
+	defer cancel()
+
+	resp, err := v.sqsClient.ChangeMessageVisibilityBatch(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := make([]message, len(changes))
+	for i, c := range changes {
+		msg[i] = c.msg
+	}
+
+	// Optimization: If everything succeeded, return early
+	if len(resp.Failed) == 0 {
+		return msg, nil
+	}
+
+	// Log partial failures.
+	for _, fail := range resp.Failed {
+		q.logger.Error(me,
+			"error", "partial change visibility failure",
+			"error_code", aws.ToString(fail.Code),
+			"batch_entry_id", aws.ToString(fail.Id),
+			"explanation", aws.ToString(fail.Message),
+			"sender_fault", fail.SenderFault,
+			"failures", len(resp.Failed),
+			"total_batch_size", len(msg),
+		)
+	}
+
+	successIDs := make([]*string, len(resp.Successful))
+	for i, s := range resp.Successful {
+		successIDs[i] = s.Id
+	}
+
+	return successfulMessages(msg, successIDs), nil
 }
 
 //
@@ -102,6 +174,7 @@ func (d *deleterReal) delete(q *queue, msg []message) ([]message, error) {
 type publisherReal struct {
 	awsAPITimeout time.Duration
 	snsClient     *sns.Client
+	topicArn      string
 }
 
 func buildEntriesFromMessages(msg []message) []snstypes.PublishBatchRequestEntry {
@@ -119,18 +192,25 @@ func buildEntriesFromMessages(msg []message) []snstypes.PublishBatchRequestEntry
 	return entries
 }
 
-func (p *publisherReal) publish(q *queue, msg []message) ([]message, error) {
+func (p *publisherReal) publish(q *queue, msg []message) ([]message, []publishFailure, error) {
 
 	const me = "publisherReal.publish"
 
 	if len(msg) == 0 {
-		return nil, errors.New("publisherReal.publish: unexpected empty message list")
+		return nil, nil, errors.New("publisherReal.publish: unexpected empty message list")
 	}
 
 	entries := buildEntriesFromMessages(msg)
 
+	if !isFifo(p.topicArn) {
+		// Fan-out might mix FIFO and standard topics.
+		for i := range entries {
+			entries[i].MessageDeduplicationId = nil
+		}
+	}
+
 	input := &sns.PublishBatchInput{
-		TopicArn:                   aws.String(q.queueCfg.TopicArn),
+		TopicArn:                   aws.String(p.topicArn),
 		PublishBatchRequestEntries: entries,
 	}
 
@@ -141,15 +221,17 @@ func (p *publisherReal) publish(q *queue, msg []message) ([]message, error) {
 
 	resp, err := p.snsClient.PublishBatch(ctx, input)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	// Optimization: If everything succeeded, return early
-	if len(resp.Failed) == 0 {
-		return msg, nil
+	// Map entry IDs back to messages.
+	byEntryID := make(map[string]message, len(msg))
+	for i, m := range msg {
+		byEntryID[aws.ToString(entries[i].Id)] = m
 	}
 
 	// Log partial failures.
+	failures := make([]publishFailure, 0, len(resp.Failed))
 	for _, fail := range resp.Failed {
 		q.logger.Error(me,
 			"error", "partial publish failure",
@@ -160,27 +242,508 @@ func (p *publisherReal) publish(q *queue, msg []message) ([]message, error) {
 			"failures", len(resp.Failed),
 			"total_batch_size", len(msg),
 		)
+		if m, found := byEntryID[aws.ToString(fail.Id)]; found {
+			failures = append(failures, publishFailure{
+				msg:         m,
+				code:        aws.ToString(fail.Code),
+				explanation: aws.ToString(fail.Message),
+				senderFault: fail.SenderFault,
+			})
+		}
 	}
 
 	// SNS might partially fail (some messages sent, some failed).
 	// We only want to return the messages that SUCCESSFULLY made it to SNS
 	// so the janitor can delete them from SQS.
+	// Failed messages are returned with their error codes, so the caller
+	// can decide whether to retry them.
 
-	// Create a map of successful IDs for fast lookup
-	successIDs := make(map[string]struct{}, len(resp.Successful))
+	successMessages := make([]message, 0, len(resp.Successful))
 	for _, s := range resp.Successful {
-		successIDs[aws.ToString(s.Id)] = struct{}{}
+		if m, found := byEntryID[aws.ToString(s.Id)]; found {
+			m.publishedID = aws.ToString(s.MessageId)
+			successMessages = append(successMessages, m)
+		}
+	}
+
+	return successMessages, failures, nil
+}
+
+// publisherSQSReal sends messages to an SQS queue, see destination type sqs.
+type publisherSQSReal struct {
+	awsAPITimeout time.Duration
+	sqsClient     *sqs.Client
+	queueURL      string
+}
+
+// buildSendEntriesFromMessages builds SendMessageBatch entries from the
+// SNS entries of messages.
+func buildSendEntriesFromMessages(msg []message, fifo bool) []sqstypes.SendMessageBatchRequestEntry {
+	entries := make([]sqstypes.SendMessageBatchRequestEntry, len(msg))
+	for i, m := range msg {
+
+		snsEntry := m.snsBatchEntry
+
+		// Combine messageId with index to get traceability and stronger uniqueness.
+		entryID := getBatchEntryID(aws.ToString(m.sqsMessage.MessageId), i)
+
+		entry := sqstypes.SendMessageBatchRequestEntry{
+			Id:          aws.String(entryID),
+			MessageBody: snsEntry.Message,
+		}
+
+		if len(snsEntry.MessageAttributes) > 0 {
+			entry.MessageAttributes = make(map[string]sqstypes.MessageAttributeValue, len(snsEntry.MessageAttributes))
+			for k, v := range snsEntry.MessageAttributes {
+				entry.MessageAttributes[k] = sqstypes.MessageAttributeValue{
+					DataType:    v.DataType,
+					BinaryValue: v.BinaryValue,
+					StringValue: v.StringValue,
+				}
+			}
+		}
+
+		if fifo {
+			entry.MessageGroupId = snsEntry.MessageGroupId
+			entry.MessageDeduplicationId = snsEntry.MessageDeduplicationId
+		}
+
+		entries[i] = entry
+	}
+	return entries
+}
+
+func (p *publisherSQSReal) publish(q *queue, msg []message) ([]message, []publishFailure, error) {
+
+	const me = "publisherSQSReal.publish"
+
+	if len(msg) == 0 {
+		return nil, nil, errors.New("publisherSQSReal.publish: unexpected empty message list")
+	}
+
+	entries := buildSendEntriesFromMessages(msg, isFifo(p.queueURL))
+
+	input := &sqs.SendMessageBatchInput{
+		QueueUrl: aws.String(p.queueURL),
+		Entries:  entries,
+	}
+
+	// Need a new context for the 30s timeout.
+	// This timeout sole purpose is to guard against forever blocked api call.
+	ctx, cancel := context.WithTimeout(context.Background(), p.awsAPITimeout)
+	defer cancel()
+
+	resp, err := p.sqsClient.SendMessageBatch(ctx, input)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Map entry IDs back to messages.
+	byEntryID := make(map[string]message, len(msg))
+	for i, m := range msg {
+		byEntryID[aws.ToString(entries[i].Id)] = m
+	}
+
+	// Log partial failures.
+	failures := make([]publishFailure, 0, len(resp.Failed))
+	for _, fail := range resp.Failed {
+		q.logger.Error(me,
+			"error", "partial send failure",
+			"error_code", aws.ToString(fail.Code),
+			"batch_entry_id", aws.ToString(fail.Id),
+			"explanation", aws.ToString(fail.Message),
+			"sender_fault", fail.SenderFault,
+			"failures", len(resp.Failed),
+			"total_batch_size", len(msg),
+		)
+		if m, found := byEntryID[aws.ToString(fail.Id)]; found {
+			failures = append(failures, publishFailure{
+				msg:         m,
+				code:        aws.ToString(fail.Code),
+				explanation: aws.ToString(fail.Message),
+				senderFault: fail.SenderFault,
+			})
+		}
 	}
 
 	successMessages := make([]message, 0, len(resp.Successful))
+	for _, s := range resp.Successful {
+		if m, found := byEntryID[aws.ToString(s.Id)]; found {
+			m.publishedID = aws.ToString(s.MessageId)
+			successMessages = append(successMessages, m)
+		}
+	}
+
+	return successMessages, failures, nil
+}
+
+// publisherEventBridgeReal puts messages to an EventBridge bus, see
+// destination type eventbridge.
+type publisherEventBridgeReal struct {
+	awsAPITimeout       time.Duration
+	client              *eventbridge.Client
+	eventBus            string
+	source              string
+	detailType          string
+	detailTypeAttribute string
+}
+
+// detailTypeOf returns the detail type for an entry: the value of
+// attribute detailTypeAttribute, else the constant detailType.
+func (p *publisherEventBridgeReal) detailTypeOf(snsEntry *snstypes.PublishBatchRequestEntry) string {
+	if p.detailTypeAttribute != "" {
+		if v := aws.ToString(snsEntry.MessageAttributes[p.detailTypeAttribute].StringValue); v != "" {
+			return v
+		}
+	}
+	return p.detailType
+}
+
+// buildPutEventsEntries builds PutEvents entries from the SNS entries of
+// messages. Messages that cannot become an event, like a body that is
+// not a JSON object, are returned as failures.
+func (p *publisherEventBridgeReal) buildPutEventsEntries(msg []message) ([]ebtypes.PutEventsRequestEntry,
+	[]message, []publishFailure) {
+
+	entries := make([]ebtypes.PutEventsRequestEntry, 0, len(msg))
+	sent := make([]message, 0, len(msg))
+	var failures []publishFailure
+
+	for _, m := range msg {
+		body := aws.ToString(m.snsBatchEntry.Message)
+
+		var detail map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(body), &detail); err != nil || detail == nil {
+			failures = append(failures, publishFailure{
+				msg:         m,
+				code:        "MalformedDetail",
+				explanation: "body is not a JSON object",
+				senderFault: true,
+			})
+			continue
+		}
+
+		detailType := p.detailTypeOf(m.snsBatchEntry)
+		if detailType == "" {
+			failures = append(failures, publishFailure{
+				msg:         m,
+				code:        "MissingDetailType",
+				explanation: "missing attribute " + p.detailTypeAttribute,
+				senderFault: true,
+			})
+			continue
+		}
+
+		entry := ebtypes.PutEventsRequestEntry{
+			EventBusName: aws.String(p.eventBus),
+			Source:       aws.String(p.source),
+			DetailType:   aws.String(detailType),
+			Detail:       aws.String(body),
+		}
+		if ms, err := strconv.ParseInt(m.sqsMessage.Attributes["SentTimestamp"], 10, 64); err == nil {
+			entry.Time = aws.Time(time.UnixMilli(ms))
+		}
+
+		entries = append(entries, entry)
+		sent = append(sent, m)
+	}
+
+	return entries, sent, failures
+}
+
+func (p *publisherEventBridgeReal) publish(q *queue, msg []message) ([]message, []publishFailure, error) {
+
+	const me = "publisherEventBridgeReal.publish"
+
+	if len(msg) == 0 {
+		return nil, nil, errors.New("publisherEventBridgeReal.publish: unexpected empty message list")
+	}
+
+	entries, sent, failures := p.buildPutEventsEntries(msg)
+
+	for _, f := range failures {
+		q.logger.Error(me,
+			"error", "invalid event",
+			"error_code", f.code,
+			"message_id", aws.ToString(f.msg.sqsMessage.MessageId),
+			"explanation", f.explanation,
+		)
+	}
+
+	if len(entries) == 0 {
+		return nil, failures, nil
+	}
+
+	input := &eventbridge.PutEventsInput{
+		Entries: entries,
+	}
+
+	// Need a new context for the 30s timeout.
+	// This timeout sole purpose is to guard against forever blocked api call.
+	ctx, cancel := context.WithTimeout(context.Background(), p.awsAPITimeout)
+	defer cancel()
+
+	resp, err := p.client.PutEvents(ctx, input)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Response entries are in the order of the request entries.
+	successMessages := make([]message, 0, len(sent))
+	for i, m := range sent {
+		var result ebtypes.PutEventsResultEntry
+		if i < len(resp.Entries) {
+			result = resp.Entries[i]
+		}
+		if result.ErrorCode == nil && result.EventId != nil {
+			m.publishedID = aws.ToString(result.EventId)
+			successMessages = append(successMessages, m)
+			continue
+		}
+		code := aws.ToString(result.ErrorCode)
+		if code == "" {
+			code = "MissingResultEntry"
+		}
+		q.logger.Error(me,
+			"error", "partial put failure",
+			"error_code", code,
+			"message_id", aws.ToString(m.sqsMessage.MessageId),
+			"explanation", aws.ToString(result.ErrorMessage),
+			"failures", resp.FailedEntryCount,
+			"total_batch_size", len(msg),
+		)
+		failures = append(failures, publishFailure{
+			msg:         m,
+			code:        code,
+			explanation: aws.ToString(result.ErrorMessage),
+			senderFault: result.ErrorCode != nil, // throttling stays retryable
+		})
+	}
+
+	return successMessages, failures, nil
+}
+
+//
+// quarantiners
+//
+
+// quarantineQueueReal sends poison messages to an SQS queue.
+type quarantineQueueReal struct {
+	awsAPITimeout time.Duration
+	sqsClient     *sqs.Client
+	queueURL      string
+}
+
+func (d *quarantineQueueReal) quarantine(q *queue, msg []message, reason string) ([]message, error) {
+	const me = "quarantineQueueReal.quarantine"
+
+	if len(msg) == 0 {
+		return nil, errors.New("quarantineQueueReal.quarantine: unexpected empty message list")
+	}
+
+	fifo := isFifo(d.queueURL)
+
+	entries := make([]sqstypes.SendMessageBatchRequestEntry, len(msg))
 	for i, m := range msg {
+
+		// Combine messageId with index to get traceability and stronger uniqueness.
 		entryID := getBatchEntryID(aws.ToString(m.sqsMessage.MessageId), i)
-		if _, ok := successIDs[entryID]; ok {
+
+		entry := sqstypes.SendMessageBatchRequestEntry{
+			Id:                aws.String(entryID),
+			MessageBody:       m.sqsMessage.Body,
+			MessageAttributes: quarantineAttributes(q, m, reason),
+		}
+
+		if fifo {
+			// FIFO queues require group id. Deduplicate by source message id.
+			groupID := m.sqsMessage.Attributes["MessageGroupId"]
+			if groupID == "" {
+				groupID = q.queueCfg.ID
+			}
+			entry.MessageGroupId = aws.String(groupID)
+			entry.MessageDeduplicationId = m.sqsMessage.MessageId
+		}
+
+		entries[i] = entry
+	}
+
+	input := &sqs.SendMessageBatchInput{
+		QueueUrl: aws.String(d.queueURL),
+		Entries:  entries,
+	}
+
+	// Need a new context for the 30s timeout.
+	// This timeout sole purpose is to guard against forever blocked api call.
+	ctx, cancel := context.WithTimeout(context.Background(), d.awsAPITimeout)
+	defer cancel()
+
+	resp, err := d.sqsClient.SendMessageBatch(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+
+	// Optimization: If everything succeeded, return early
+	if len(resp.Failed) == 0 {
+		return msg, nil
+	}
+
+	// Log partial failures.
+	for _, fail := range resp.Failed {
+		q.logger.Error(me,
+			"error", "partial quarantine failure",
+			"error_code", aws.ToString(fail.Code),
+			"batch_entry_id", aws.ToString(fail.Id),
+			"explanation", aws.ToString(fail.Message),
+			"sender_fault", fail.SenderFault,
+			"failures", len(resp.Failed),
+			"total_batch_size", len(msg),
+		)
+	}
+
+	successIDs := make([]*string, len(resp.Successful))
+	for i, s := range resp.Successful {
+		successIDs[i] = s.Id
+	}
+
+	return successfulMessages(msg, successIDs), nil
+}
+
+// quarantineTopicReal publishes poison messages to an SNS topic.
+type quarantineTopicReal struct {
+	awsAPITimeout time.Duration
+	snsClient     *sns.Client
+	topicArn      string
+}
+
+func (d *quarantineTopicReal) quarantine(q *queue, msg []message, reason string) ([]message, error) {
+	const me = "quarantineTopicReal.quarantine"
+
+	if len(msg) == 0 {
+		return nil, errors.New("quarantineTopicReal.quarantine: unexpected empty message list")
+	}
+
+	fifo := isFifo(d.topicArn)
+
+	entries := make([]snstypes.PublishBatchRequestEntry, len(msg))
+	for i, m := range msg {
+
+		// Combine messageId with index to get traceability and stronger uniqueness.
+		entryID := getBatchEntryID(aws.ToString(m.sqsMessage.MessageId), i)
+
+		attr := map[string]snstypes.MessageAttributeValue{}
+		for k, v := range quarantineAttributes(q, m, reason) {
+			attr[k] = snstypes.MessageAttributeValue{
+				DataType:    v.DataType,
+				BinaryValue: v.BinaryValue,
+				StringValue: v.StringValue,
+			}
+		}
+
+		entry := snstypes.PublishBatchRequestEntry{
+			Id:                aws.String(entryID),
+			Message:           m.sqsMessage.Body,
+			MessageAttributes: attr,
+		}
+
+		if fifo {
+			// FIFO topics require group id. Deduplicate by source message id.
+			groupID := m.sqsMessage.Attributes["MessageGroupId"]
+			if groupID == "" {
+				groupID = q.queueCfg.ID
+			}
+			entry.MessageGroupId = aws.String(groupID)
+			entry.MessageDeduplicationId = m.sqsMessage.MessageId
+		}
+
+		entries[i] = entry
+	}
+
+	input := &sns.PublishBatchInput{
+		TopicArn:                   aws.String(d.topicArn),
+		PublishBatchRequestEntries: entries,
+	}
+
+	// Need a new context for the 30s timeout.
+	// This timeout sole purpose is to guard against forever blocked api call.
+	ctx, cancel := context.WithTimeout(context.Background(), d.awsAPITimeout)
+	defer cancel()
+
+	resp, err := d.snsClient.PublishBatch(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+
+	// Optimization: If everything succeeded, return early
+	if len(resp.Failed) == 0 {
+		return msg, nil
+	}
+
+	// Log partial failures.
+	for _, fail := range resp.Failed {
+		q.logger.Error(me,
+			"error", "partial quarantine failure",
+			"error_code", aws.ToString(fail.Code),
+			"batch_entry_id", aws.ToString(fail.Id),
+			"explanation", aws.ToString(fail.Message),
+			"sender_fault", fail.SenderFault,
+			"failures", len(resp.Failed),
+			"total_batch_size", len(msg),
+		)
+	}
+
+	successIDs := make([]*string, len(resp.Successful))
+	for i, s := range resp.Successful {
+		successIDs[i] = s.Id
+	}
+
+	return successfulMessages(msg, successIDs), nil
+}
+
+//
+// claim check store
+//
+
+// claimCheckS3Real stores bodies in an S3-compatible bucket.
+type claimCheckS3Real struct {
+	awsAPITimeout time.Duration
+	s3Client      *s3.Client
+	bucket        string
+}
+
+func (c *claimCheckS3Real) put(_ *queue, key, body string) error {
+	input := &s3.PutObjectInput{
+		Bucket: aws.String(c.bucket),
+		Key:    aws.String(key),
+		Body:   strings.NewReader(body),
+	}
+
+	// Need a new context for the 30s timeout.
+	// This timeout sole purpose is to guard against forever blocked api call.
+	ctx, cancel := context.WithTimeout(context.Background(), c.awsAPITimeout)
+	defer cancel()
+
+	_, err := c.s3Client.PutObject(ctx, input)
+	return err
+}
+
+// successfulMessages picks the messages whose batch entry IDs are listed in successIDs.
+func successfulMessages(msg []message, successIDs []*string) []message {
+	// Create a map of successful IDs for fast lookup
+	found := make(map[string]struct{}, len(successIDs))
+	for _, id := range successIDs {
+		found[aws.ToString(id)] = struct{}{}
+	}
+
+	successMessages := make([]message, 0, len(successIDs))
+	for i, m := range msg {
+		entryID := getBatchEntryID(aws.ToString(m.sqsMessage.MessageId), i)
+		if _, ok := found[entryID]; ok {
 			successMessages = append(successMessages, m)
 		}
 	}
 
-	return successMessages, nil
+	return successMessages
 }
 
 func getBatchEntryID(messageID string, entryIndex int) string {
@@ -228,6 +791,9 @@ func (r *receiverReal) receive(q *queue) ([]message, bool, error) {
 		QueueUrl: aws.String(q.queueCfg.QueueURL),
 		AttributeNames: []sqstypes.QueueAttributeName{
 			"SentTimestamp",
+			"ApproximateReceiveCount",
+			"MessageGroupId",
+			"MessageDeduplicationId",
 		},
 		MaxNumberOfMessages: q.queueCfg.MaxNumberOfMessages, // 1..10 (default 10)
 		MessageAttributeNames: []string{
@@ -236,6 +802,12 @@ func (r *receiverReal) receive(q *queue) ([]message, bool, error) {
 		WaitTimeSeconds: aws.ToInt32(q.queueCfg.WaitTimeSeconds), // 0..20 (default 20)
 	}
 
+	if q.queueCfg.VisibilityTimeout > 0 {
+		// The heartbeat needs to know the visibility deadline of
+		// received messages, so we set it explicitly.
+		input.VisibilityTimeout = int32(q.queueCfg.VisibilityTimeout.Seconds())
+	}
+
 	// Need a new context for the 30s timeout.
 	// This timeout sole purpose is to guard against forever blocked api call.
 	ctx, cancel := context.WithTimeout(r.ctx, r.awsAPITimeout)
@@ -259,14 +831,42 @@ func (r *receiverReal) receive(q *queue) ([]message, bool, error) {
 
 	for _, respMsg := range resp.Messages {
 
-		m, errMsg := newMessage(&respMsg, now,
-			aws.ToBool(q.queueCfg.CopyAttributes),
-			aws.ToBool(q.queueCfg.CopyMesssageGroupID),
-			r.perMessagePadding)
+		convert := newMessage
+		if q.queueCfg.SplitJSONArray {
+			convert = newSplitMessage
+		}
+
+		m, errMsg := convert(&respMsg, now,
+			q.options, r.perMessagePadding)
 		if errMsg != nil {
 			q.logger.Error(me,
 				"message_id", aws.ToString(respMsg.MessageId),
 				"new_message_error", errMsg)
+			if errors.Is(errMsg, errInvalidPayloadSize) {
+				// The reader applies the oversize policy.
+				m.oversize = true
+				msg = append(msg, m)
+				continue
+			}
+			if errors.Is(errMsg, errRetryLater) {
+				// The reader releases it.
+				msg = append(msg, message{
+					sqsMessage: &respMsg,
+					receivedAt: now,
+					retry:      true,
+				})
+				continue
+			}
+			if errors.Is(errMsg, errTransform) {
+				// The reader applies the publish failure action.
+				msg = append(msg, message{
+					sqsMessage: &respMsg,
+					receivedAt: now,
+					invalid:    true,
+					overflow:   errors.Is(errMsg, errTooManyAttributes),
+				})
+				continue
+			}
 			q.stats.droppedMessages.Add(1)
 			continue
 		}
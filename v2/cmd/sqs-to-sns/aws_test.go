@@ -54,12 +54,12 @@ func TestSNSBatchBoundary(t *testing.T) {
 			msgs[9] = m
 		}
 
-		pub := &publisherReal{snsClient: snsClient, awsAPITimeout: 30 * time.Second}
+		pub := &publisherReal{snsClient: snsClient, awsAPITimeout: 30 * time.Second, topicArn: topicArn}
 		q := &queue{queueCfg: queueConfig{TopicArn: topicArn}}
 
 		sizing := GetBatchSizing(msgs)
 
-		success, err := pub.publish(q, msgs)
+		success, _, err := pub.publish(q, msgs)
 		if err != nil {
 			t.Errorf("Batch sizing: %s", sizing)
 			t.Fatalf("Test 1 Failed: Expected success at exactly 256KB, got error: %v", err)
@@ -85,14 +85,14 @@ func TestSNSBatchBoundary(t *testing.T) {
 				Body:      aws.String(payload),
 			}
 
-			const (
-				copyAttributes     = true
-				copyMessageGroupID = true
-			)
+			opt := messageOptions{
+				copyAttributes:     true,
+				copyMessageGroupID: true,
+			}
 
 			now := time.Now()
 
-			m, _, _ := newMessageUnsafe(sqsMessage, now, copyAttributes, copyMessageGroupID)
+			m, _, _, _ := newMessageUnsafe(sqsMessage, now, opt)
 
 			const debug = true
 
@@ -108,10 +108,10 @@ func TestSNSBatchBoundary(t *testing.T) {
 
 		sizing := GetBatchSizing(msgs)
 
-		pub := &publisherReal{snsClient: snsClient, awsAPITimeout: 30 * time.Second}
+		pub := &publisherReal{snsClient: snsClient, awsAPITimeout: 30 * time.Second, topicArn: topicArn}
 		q := &queue{queueCfg: queueConfig{TopicArn: topicArn}}
 
-		_, err := pub.publish(q, msgs)
+		_, _, err := pub.publish(q, msgs)
 		if err == nil {
 			t.Errorf("Batch sizing: %s", sizing)
 			t.Fatal("Test 2 Failed: Expected an error for 256KB + 1 byte, but call succeeded")
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// bodyTemplateData is available to body_template.
+type bodyTemplateData struct {
+	Body             string            // SQS body, unwrapped from the SNS envelope if enabled
+	JSON             any               // Body parsed as JSON, nil if it is not JSON
+	Attributes       map[string]string // SQS message attributes, Binary values base64-encoded
+	SystemAttributes map[string]string // like SentTimestamp, MessageGroupId
+	MessageID        string
+	QueueID          string
+	QueueURL         string
+	Hostname         string
+}
+
+// bodyTemplateFuncs are the functions available to body_template.
+var bodyTemplateFuncs = template.FuncMap{
+	// json encodes a value as JSON, like: "payload":{{json .JSON}}
+	"json": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+// parseBodyTemplate parses body_template.
+func parseBodyTemplate(text string) (*template.Template, error) {
+	return template.New("body").Funcs(bodyTemplateFuncs).Option("missingkey=error").Parse(text)
+}
+
+// renderBody renders body_template for an SQS message.
+func renderBody(tmpl *template.Template, sqsMessage *sqstypes.Message, opt messageOptions) (string, error) {
+	body := aws.ToString(sqsMessage.Body)
+
+	data := bodyTemplateData{
+		Body:             body,
+		Attributes:       map[string]string{},
+		SystemAttributes: sqsMessage.Attributes,
+		MessageID:        aws.ToString(sqsMessage.MessageId),
+		QueueID:          opt.queueID,
+		QueueURL:         opt.queueURL,
+		Hostname:         hostname,
+	}
+	if doc, err := jsonDecode(body); err == nil {
+		data.JSON = doc
+	}
+	for k, v := range sqsMessage.MessageAttributes {
+		if v.BinaryValue != nil {
+			data.Attributes[k] = base64.StdEncoding.EncodeToString(v.BinaryValue)
+			continue
+		}
+		data.Attributes[k] = aws.ToString(v.StringValue)
+	}
+
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, data); err != nil {
+		return "", fmt.Errorf("body_template: %w", err)
+	}
+	return sb.String(), nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/udhos/sqs-to-sns/v2/snsutils"
+)
+
+// go test -count 1 -run '^TestNewMessageBodyTemplate$' ./...
+func TestNewMessageBodyTemplate(t *testing.T) {
+	sqsMsg := &sqstypes.Message{
+		MessageId: aws.String("id1"),
+		Body:      aws.String(`{"order":7,"tags":["a"]}`),
+		Attributes: map[string]string{
+			"SentTimestamp": "1700000000000",
+		},
+		MessageAttributes: map[string]sqstypes.MessageAttributeValue{
+			"tenant": {DataType: aws.String("String"), StringValue: aws.String("t1")},
+		},
+	}
+
+	opt := newTestMessageOptions(t, queueConfig{
+		ID: "q1",
+		BodyTemplate: `{"source":{{json .QueueID}},"tenant":{{json .Attributes.tenant}},` +
+			`"sent":{{.SystemAttributes.SentTimestamp}},"order":{{.JSON.order}},"payload":{{.Body}}}`,
+	})
+
+	m, err := newMessage(sqsMsg, time.Now(), opt, 0)
+	if err != nil {
+		t.Fatalf("new message: %v", err)
+	}
+
+	expected := `{"source":"q1","tenant":"t1","sent":1700000000000,"order":7,"payload":{"order":7,"tags":["a"]}}`
+	if got := aws.ToString(m.snsBatchEntry.Message); got != expected {
+		t.Errorf("expected body:\n%s\ngot:\n%s", expected, got)
+	}
+
+	// sizing runs on the rendered body
+	const debug = false
+	_, _, total, _ := snsutils.GetSNSPayloadSize(*m.snsBatchEntry, debug)
+	if m.snsPayloadSize != total || total != len(expected) {
+		t.Errorf("expected size=%d got=%d", len(expected), m.snsPayloadSize)
+	}
+
+	// render error is a transform error
+	opt = newTestMessageOptions(t, queueConfig{BodyTemplate: `{{.Attributes.missing}}`})
+	if _, err := newMessage(sqsMsg, time.Now(), opt, 0); !errors.Is(err, errTransform) {
+		t.Errorf("expected transform error, got %v", err)
+	}
+}
+
+// go test -count 1 -run '^TestTruncateBodyTemplate$' ./...
+func TestTruncateBodyTemplate(t *testing.T) {
+	sqsMsg := &sqstypes.Message{
+		MessageId: aws.String("id1"),
+		Body:      aws.String(string(make([]byte, maxSnsPublishPayload))),
+	}
+	m := message{sqsMessage: sqsMsg, receivedAt: time.Now()}
+
+	opt := newTestMessageOptions(t, queueConfig{BodyTemplate: `prefix:{{.Body}}`})
+
+	tr, err := truncateMessage(m, opt, 0)
+	if err != nil {
+		t.Fatalf("truncate: %v", err)
+	}
+	if body := aws.ToString(tr.snsBatchEntry.Message); body[:7] != "prefix:" {
+		t.Errorf("expected rendered body truncated, got prefix %q", body[:7])
+	}
+	if tr.snsPayloadSize > maxSnsPublishPayload {
+		t.Errorf("expected size <= %d, got %d", maxSnsPublishPayload, tr.snsPayloadSize)
+	}
+}
+
+// go test -count 1 -run '^TestValidateBodyTemplate$' ./...
+func TestValidateBodyTemplate(t *testing.T) {
+	cfg := queueDefaults(queueConfig{TopicArn: "t1", BodyTemplate: `{{.Body}}`})
+	if err := validateQueueConfig(cfg); err != nil {
+		t.Errorf("expected valid body_template: %v", err)
+	}
+	cfg.BodyTemplate = `{{.Body`
+	if err := validateQueueConfig(cfg); err == nil {
+		t.Errorf("expected invalid body_template")
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	snstypes "github.com/aws/aws-sdk-go-v2/service/sns/types"
+	"github.com/udhos/sqs-to-sns/v2/snsutils"
+)
+
+// oversizePolicyClaimCheck offloads the body to an S3-compatible bucket
+// and publishes a pointer to it instead.
+const oversizePolicyClaimCheck = "claim_check"
+
+// claimCheckConfig defines the S3-compatible bucket where oversized
+// bodies are offloaded to.
+type claimCheckConfig struct {
+	Bucket      string `yaml:"bucket"`
+	Prefix      string `yaml:"prefix"`
+	Region      string `yaml:"region"`
+	RoleArn     string `yaml:"role_arn"`
+	EndpointURL string `yaml:"endpoint_url"` // defaults to ENDPOINT_URL
+}
+
+// claimCheckUploaders is the number of goroutines uploading bodies
+// for a queue, so slow uploads do not stall the readers.
+const claimCheckUploaders = 4
+
+type claimCheckStore interface {
+	put(q *queue, key, body string) error
+}
+
+// startClaimCheck uploads the oversized messages handed over by the
+// readers, see handleOversize.
+func (app *application) startClaimCheck(q *queue) {
+	for m := range q.claimCheckCh {
+		app.claimCheck(q, []message{m})
+	}
+}
+
+// claimCheck uploads the bodies of oversized messages and forwards
+// pointer messages to the publisher. Failed uploads are handed back to SQS.
+func (app *application) claimCheck(q *queue, msg []message) {
+	const me = "claimCheck"
+
+	cfg := q.queueCfg.ClaimCheck
+
+	for _, m := range msg {
+		key := claimCheckKey(cfg.Prefix, q.queueCfg.ID, aws.ToString(m.sqsMessage.MessageId))
+
+		c, body, errMsg := claimCheckMessage(m, cfg.Bucket, key,
+			q.options, app.cfg.perMessagePadding)
+		if errMsg != nil {
+			q.stats.claimCheckErrors.Add(1)
+			q.logger.Error(me,
+				"message_id", aws.ToString(m.sqsMessage.MessageId),
+				"error", errMsg)
+			app.release(q, []message{m})
+			continue
+		}
+
+		if errPut := q.claimCheck.put(q, key, body); errPut != nil {
+			q.stats.claimCheckErrors.Add(1)
+			q.logger.Error(me,
+				"message_id", aws.ToString(m.sqsMessage.MessageId),
+				"bucket", cfg.Bucket,
+				"key", key,
+				"error", errPut)
+			app.release(q, []message{m})
+			continue
+		}
+
+		q.logger.Debug(me,
+			"message_id", aws.ToString(m.sqsMessage.MessageId),
+			"bucket", cfg.Bucket,
+			"key", key,
+			"body_size", len(body),
+			"message_size", c.snsPayloadSize)
+
+		app.forward(q, c)
+	}
+}
+
+// claimCheckKey returns the object key of a message under prefix/queueID.
+// The key only depends on the SQS MessageId, so uploads retried after a
+// release or a redelivery overwrite the same object.
+func claimCheckKey(prefix, queueID, messageID string) string {
+	return path.Join(prefix, queueID, messageID)
+}
+
+// claimCheckMessage builds an SNS entry whose body is a pointer to the
+// S3 object holding the original body, compatible with the AWS extended
+// client libraries. The size accounting uses the pointer size. It also
+// returns the body to upload, rendered by body_template. A slot is
+// reserved for the size attribute, so the attribute overflow strategy
+// makes room for it.
+func claimCheckMessage(m message, bucket, key string,
+	opt messageOptions, perMessagePadding int) (message, string, error) {
+
+	limit := opt.attributes.attributeLimit()
+	if limit < 2 {
+		return message{}, "", fmt.Errorf("%w: no room for attribute %s",
+			errTooManyAttributes, snsutils.ExtendedPayloadSizeAttribute)
+	}
+
+	opt.attributes.Max = limit - 1
+	opt.compress = false       // the body is replaced by the pointer
+	opt.unwrapEnvelope = false // already unwrapped by the receiver
+
+	c, _, _, errConv := newMessageUnsafe(m.sqsMessage, m.receivedAt, opt)
+	if errConv != nil {
+		return message{}, "", errConv
+	}
+	c.skip = m.skip
+
+	body := aws.ToString(c.snsBatchEntry.Message)
+
+	if c.snsBatchEntry.MessageAttributes == nil {
+		c.snsBatchEntry.MessageAttributes = map[string]snstypes.MessageAttributeValue{}
+	}
+	c.snsBatchEntry.MessageAttributes[snsutils.ExtendedPayloadSizeAttribute] = snstypes.MessageAttributeValue{
+		DataType:    aws.String("Number"),
+		StringValue: aws.String(strconv.Itoa(len(body))),
+	}
+	c.snsBatchEntry.Message = aws.String(snsutils.NewExtendedPayloadPointer(bucket, key))
+
+	const debug = false
+	_, _, c.snsPayloadSize, _ = snsutils.GetSNSPayloadSize(*c.snsBatchEntry, debug)
+
+	if limit := opt.maxPayload(); c.snsPayloadSize+perMessagePadding > limit {
+		return message{}, "", fmt.Errorf("%w for destination even with claim check: total=%d > limit=%d",
+			errInvalidPayloadSize, c.snsPayloadSize+perMessagePadding, limit)
+	}
+
+	return c, body, nil
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/udhos/sqs-to-sns/v2/internal/s3client"
+	"github.com/udhos/sqs-to-sns/v2/snsutils"
+)
+
+// go test -count 1 -run '^TestClaimCheckMessage$' ./...
+func TestClaimCheckMessage(t *testing.T) {
+	body := strings.Repeat("a", 2*maxSnsPublishPayload)
+
+	m := message{
+		sqsMessage: &sqstypes.Message{
+			MessageId: aws.String(getRandomID()),
+			Body:      aws.String(body),
+		},
+		receivedAt: time.Now(),
+	}
+
+	c, _, err := claimCheckMessage(m, "bucket1", "key1",
+		messageOptions{copyAttributes: true, copyMessageGroupID: true}, 500)
+	if err != nil {
+		t.Fatalf("claim check message: %v", err)
+	}
+
+	pointer := snsutils.NewExtendedPayloadPointer("bucket1", "key1")
+
+	if got := aws.ToString(c.snsBatchEntry.Message); got != pointer {
+		t.Errorf("expected pointer body=%s got=%s", pointer, got)
+	}
+	if got := aws.ToString(c.snsBatchEntry.MessageAttributes[snsutils.ExtendedPayloadSizeAttribute].StringValue); got != "524288" {
+		t.Errorf("expected original size attribute, got %s", got)
+	}
+	if c.snsPayloadSize >= len(body) {
+		t.Errorf("size accounting must use the pointer size, got %d", c.snsPayloadSize)
+	}
+}
+
+// go test -count 1 -run '^TestClaimCheckMessageAttributeLimit$' ./...
+func TestClaimCheckMessageAttributeLimit(t *testing.T) {
+	attr := map[string]sqstypes.MessageAttributeValue{}
+	for i := range maxMessageAttributes {
+		attr[fmt.Sprintf("a%d", i)] = sqstypes.MessageAttributeValue{
+			DataType: aws.String("String"), StringValue: aws.String("v")}
+	}
+
+	m := message{
+		sqsMessage: &sqstypes.Message{
+			MessageId:         aws.String(getRandomID()),
+			Body:              aws.String(strings.Repeat("a", maxSnsPublishPayload+1)),
+			MessageAttributes: attr,
+		},
+		receivedAt: time.Now(),
+	}
+
+	opt := messageOptions{copyAttributes: true}
+
+	c, _, err := claimCheckMessage(m, "bucket1", "key1", opt, 0)
+	if err != nil {
+		t.Fatalf("claim check message: %v", err)
+	}
+	got := c.snsBatchEntry.MessageAttributes
+	if len(got) != maxMessageAttributes {
+		t.Errorf("expected %d attributes, got %d", maxMessageAttributes, len(got))
+	}
+	if _, found := got[snsutils.ExtendedPayloadSizeAttribute]; !found {
+		t.Errorf("expected size attribute")
+	}
+	if _, found := got[attrPacked]; !found {
+		t.Errorf("expected overflow packed")
+	}
+
+	opt.attributes.Overflow = attributeOverflowFail
+	if _, _, err := claimCheckMessage(m, "bucket1", "key1", opt, 0); !errors.Is(err, errTooManyAttributes) {
+		t.Errorf("expected too many attributes, got %v", err)
+	}
+
+	opt.attributes.Max = 1
+	if _, _, err := claimCheckMessage(m, "bucket1", "key1", opt, 0); !errors.Is(err, errTooManyAttributes) {
+		t.Errorf("expected no room for the size attribute, got %v", err)
+	}
+}
+
+// go test -count 1 -run '^TestClaimCheckLocalS3$' ./...
+func TestClaimCheckLocalS3(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+
+	// Local S3 stand-in: records PutObject requests.
+	var mu sync.Mutex
+	objects := map[string]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			http.Error(w, "unexpected method", http.StatusMethodNotAllowed)
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		objects[r.URL.Path] = string(data)
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	store := &claimCheckS3Real{
+		s3Client:      s3client.NewClient("test", "us-east-1", "", server.URL),
+		awsAPITimeout: 5 * time.Second,
+		bucket:        "bucket1",
+	}
+
+	body := strings.Repeat("b", maxSnsPublishPayload+1)
+
+	q := &queue{
+		queueCfg: queueDefaults(queueConfig{
+			ID:             "q1",
+			OversizePolicy: oversizePolicyClaimCheck,
+			ClaimCheck:     claimCheckConfig{Bucket: "bucket1", Prefix: "offload"},
+		}),
+		claimCheck:   store,
+		claimCheckCh: make(chan message, 10),
+		logger:       slog.Default(),
+	}
+	initStats(&q.stats)
+	d := newTestDestination(q, &publisherMock{})
+
+	app := &application{}
+	go app.startClaimCheck(q)
+
+	m := message{
+		sqsMessage: &sqstypes.Message{
+			MessageId: aws.String(getRandomID()),
+			Body:      aws.String(body),
+		},
+		receivedAt: time.Now(),
+		oversize:   true,
+	}
+
+	// the second upload, as after a redelivery, overwrites the first one
+	var c message
+	for range 2 {
+		app.handleOversize(q, []message{m})
+		select {
+		case c = <-d.publishCh:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected pointer message forwarded to publisher (errors=%d)",
+				q.stats.claimCheckErrors.Load())
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(objects) != 1 {
+		t.Fatalf("expected 1 uploaded object, got %d", len(objects))
+	}
+	for objectPath, data := range objects {
+		if objectPath != "/bucket1/offload/q1/"+aws.ToString(m.sqsMessage.MessageId) {
+			t.Errorf("unexpected object path: %s", objectPath)
+		}
+		key := strings.TrimPrefix(objectPath, "/bucket1/")
+		if got := aws.ToString(c.snsBatchEntry.Message); got != snsutils.NewExtendedPayloadPointer("bucket1", key) {
+			t.Errorf("pointer does not match uploaded object: %s", got)
+		}
+		if data != body {
+			t.Errorf("uploaded body mismatch: size=%d", len(data))
+		}
+	}
+}
+
+// go test -count 1 -run '^TestClaimCheckPutError$' ./...
+func TestClaimCheckPutError(t *testing.T) {
+	m := message{
+		sqsMessage: &sqstypes.Message{
+			MessageId: aws.String(getRandomID()),
+			Body:      aws.String(strings.Repeat("c", maxSnsPublishPayload+1)),
+		},
+		receivedAt: time.Now(),
+		oversize:   true,
+	}
+
+	q := &queue{
+		queueCfg: queueDefaults(queueConfig{
+			OversizePolicy: oversizePolicyClaimCheck,
+			ClaimCheck:     claimCheckConfig{Bucket: "bucket1"},
+		}),
+		claimCheck:   &claimCheckMock{err: errors.New("boom")},
+		claimCheckCh: make(chan message, 10),
+		logger:       slog.Default(),
+	}
+	initStats(&q.stats)
+	d := newTestDestination(q, &publisherMock{})
+
+	app := &application{}
+	go app.startClaimCheck(q)
+	app.handleOversize(q, []message{m})
+
+	deadline := time.After(5 * time.Second)
+	for q.stats.claimCheckErrors.Load() == 0 {
+		select {
+		case <-deadline:
+			t.Fatalf("timeout waiting for the upload error")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	if len(d.publishCh) != 0 {
+		t.Errorf("failed upload must not publish, got %d", len(d.publishCh))
+	}
+	if got := q.stats.claimCheckErrors.Load(); got != 1 {
+		t.Errorf("claim check errors: expected=1 got=%d", got)
+	}
+	if got := q.stats.droppedMessages.Load(); got != 0 {
+		t.Errorf("claim check must not count dropped messages, got %d", got)
+	}
+}
+
+type claimCheckMock struct {
+	err error
+}
+
+func (c *claimCheckMock) put(_ *queue, _, _ string) error {
+	return c.err
+}
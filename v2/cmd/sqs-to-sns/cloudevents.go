@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	snstypes "github.com/aws/aws-sdk-go-v2/service/sns/types"
+)
+
+// CloudEvents modes.
+const (
+	// cloudEventsStructured wraps the body in a CloudEvents JSON envelope.
+	cloudEventsStructured = "structured"
+
+	// cloudEventsBinary keeps the body and sets ce_* attributes.
+	cloudEventsBinary = "binary"
+
+	// cloudEventsPassthrough requires messages to already be CloudEvents,
+	// either structured or binary, and forwards them unchanged.
+	cloudEventsPassthrough = "passthrough"
+)
+
+const cloudEventsSpecVersion = "1.0"
+
+// cloudEventsAttrPrefix prefixes the attributes of binary mode CloudEvents.
+const cloudEventsAttrPrefix = "ce_"
+
+// cloudEventsConfig maps published messages to CloudEvents. The event id
+// is the SQS MessageId and the time is the SQS SentTimestamp. Messages that
+// already are CloudEvents are forwarded unchanged in every mode.
+type cloudEventsConfig struct {
+	Mode   string `yaml:"mode"`   // structured, binary, passthrough, empty disables
+	Source string `yaml:"source"` // defaults to the queue URL
+	Type   string `yaml:"type"`   // required for structured and binary
+}
+
+func (c cloudEventsConfig) validate() error {
+	switch c.Mode {
+	case "", cloudEventsPassthrough:
+	case cloudEventsStructured, cloudEventsBinary:
+		if c.Type == "" {
+			return fmt.Errorf("cloudevents mode=%s requires type", c.Mode)
+		}
+	default:
+		return fmt.Errorf("cloudevents mode=%q must be one of: %s, %s, %s",
+			c.Mode, cloudEventsStructured, cloudEventsBinary, cloudEventsPassthrough)
+	}
+	return nil
+}
+
+// cloudEvent is a structured mode CloudEvent.
+type cloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Time            string          `json:"time,omitempty"`
+	DataContentType string          `json:"datacontenttype,omitempty"`
+	Data            json.RawMessage `json:"data,omitempty"`
+}
+
+var errNotCloudEvent = errors.New("not a CloudEvent")
+
+// isStructuredCloudEvent reports whether body is a structured CloudEvent.
+func isStructuredCloudEvent(body string) bool {
+	var e cloudEvent
+	if err := json.Unmarshal([]byte(body), &e); err != nil {
+		return false
+	}
+	return e.SpecVersion != "" && e.ID != "" && e.Source != "" && e.Type != ""
+}
+
+// isBinaryCloudEvent reports whether attributes carry a binary CloudEvent.
+func isBinaryCloudEvent(attr map[string]snstypes.MessageAttributeValue) bool {
+	for _, name := range []string{"specversion", "id", "source", "type"} {
+		if _, found := attr[cloudEventsAttrPrefix+name]; !found {
+			return false
+		}
+	}
+	return true
+}
+
+// cloudEventTime formats the SQS SentTimestamp (epoch milliseconds),
+// falling back to the receive time.
+func cloudEventTime(sentTimestamp string, receivedAt time.Time) string {
+	t := receivedAt
+	if ms, err := strconv.ParseInt(sentTimestamp, 10, 64); err == nil {
+		t = time.UnixMilli(ms)
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// applyCloudEvents maps the entry to a CloudEvent.
+func (c cloudEventsConfig) applyCloudEvents(snsEntry *snstypes.PublishBatchRequestEntry,
+	id, sentTimestamp string, receivedAt time.Time, queueURL string) error {
+
+	body := aws.ToString(snsEntry.Message)
+
+	if isStructuredCloudEvent(body) || isBinaryCloudEvent(snsEntry.MessageAttributes) {
+		return nil // already a CloudEvent
+	}
+
+	source := c.Source
+	if source == "" {
+		source = queueURL
+	}
+
+	event := cloudEvent{
+		SpecVersion: cloudEventsSpecVersion,
+		ID:          id,
+		Source:      source,
+		Type:        c.Type,
+		Time:        cloudEventTime(sentTimestamp, receivedAt),
+	}
+
+	switch c.Mode {
+	case cloudEventsStructured:
+		if json.Valid([]byte(body)) {
+			event.DataContentType = "application/json"
+			event.Data = json.RawMessage(body)
+		} else {
+			data, _ := json.Marshal(body)
+			event.DataContentType = "text/plain"
+			event.Data = data
+		}
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(event); err != nil {
+			return err
+		}
+		snsEntry.Message = aws.String(string(bytes.TrimSuffix(buf.Bytes(), []byte("\n"))))
+	case cloudEventsBinary:
+		if snsEntry.MessageAttributes == nil {
+			snsEntry.MessageAttributes = map[string]snstypes.MessageAttributeValue{}
+		}
+		set := func(name, value string) {
+			snsEntry.MessageAttributes[cloudEventsAttrPrefix+name] = snstypes.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(value),
+			}
+		}
+		set("specversion", event.SpecVersion)
+		set("id", event.ID)
+		set("source", event.Source)
+		set("type", event.Type)
+		set("time", event.Time)
+	default:
+		return errNotCloudEvent
+	}
+
+	return nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+func newCloudEventsTestMessage(body string) *sqstypes.Message {
+	return &sqstypes.Message{
+		MessageId:  aws.String("id1"),
+		Body:       aws.String(body),
+		Attributes: map[string]string{"SentTimestamp": "1700000000000"},
+	}
+}
+
+// go test -count 1 -run '^TestCloudEventsStructured$' ./...
+func TestCloudEventsStructured(t *testing.T) {
+	opt := messageOptions{
+		queueURL:    "https://sqs/q1",
+		cloudEvents: cloudEventsConfig{Mode: cloudEventsStructured, Type: "order.created"},
+	}
+
+	m, err := newMessage(newCloudEventsTestMessage(`{"order":7}`), time.Now(), opt, 0)
+	if err != nil {
+		t.Fatalf("new message: %v", err)
+	}
+
+	expected := `{"specversion":"1.0","id":"id1","source":"https://sqs/q1","type":"order.created",` +
+		`"time":"2023-11-14T22:13:20Z","datacontenttype":"application/json","data":{"order":7}}`
+	body := aws.ToString(m.snsBatchEntry.Message)
+	if body != expected {
+		t.Errorf("expected body:\n%s\ngot:\n%s", expected, body)
+	}
+	if m.snsPayloadSize != len(expected) {
+		t.Errorf("expected size=%d got=%d", len(expected), m.snsPayloadSize)
+	}
+
+	// plain body is a JSON string
+	m, _ = newMessage(newCloudEventsTestMessage("hello"), time.Now(), opt, 0)
+	var e cloudEvent
+	if err := json.Unmarshal([]byte(aws.ToString(m.snsBatchEntry.Message)), &e); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if e.DataContentType != "text/plain" || string(e.Data) != `"hello"` {
+		t.Errorf("unexpected plain event: %+v", e)
+	}
+
+	// existing CloudEvent is not wrapped again
+	m, _ = newMessage(newCloudEventsTestMessage(expected), time.Now(), opt, 0)
+	if got := aws.ToString(m.snsBatchEntry.Message); got != expected {
+		t.Errorf("expected CloudEvent passed through, got %s", got)
+	}
+}
+
+// go test -count 1 -run '^TestCloudEventsBinary$' ./...
+func TestCloudEventsBinary(t *testing.T) {
+	opt := messageOptions{
+		cloudEvents: cloudEventsConfig{Mode: cloudEventsBinary, Source: "orders", Type: "order.created"},
+	}
+
+	m, err := newMessage(newCloudEventsTestMessage(`{"order":7}`), time.Now(), opt, 0)
+	if err != nil {
+		t.Fatalf("new message: %v", err)
+	}
+	if got := aws.ToString(m.snsBatchEntry.Message); got != `{"order":7}` {
+		t.Errorf("expected body unchanged, got %s", got)
+	}
+
+	attr := m.snsBatchEntry.MessageAttributes
+	expected := map[string]string{
+		"ce_specversion": "1.0",
+		"ce_id":          "id1",
+		"ce_source":      "orders",
+		"ce_type":        "order.created",
+		"ce_time":        "2023-11-14T22:13:20Z",
+	}
+	if len(attr) != len(expected) {
+		t.Errorf("expected %d attributes, got %v", len(expected), attr)
+	}
+	for k, v := range expected {
+		if got := aws.ToString(attr[k].StringValue); got != v {
+			t.Errorf("attribute %s: expected=%q got=%q", k, v, got)
+		}
+	}
+}
+
+// go test -count 1 -run '^TestCloudEventsPassthrough$' ./...
+func TestCloudEventsPassthrough(t *testing.T) {
+	opt := messageOptions{
+		copyAttributes: true,
+		cloudEvents:    cloudEventsConfig{Mode: cloudEventsPassthrough},
+	}
+
+	const structured = `{"specversion":"1.0","id":"e1","source":"s","type":"t","data":1}`
+	if _, err := newMessage(newCloudEventsTestMessage(structured), time.Now(), opt, 0); err != nil {
+		t.Errorf("structured: %v", err)
+	}
+
+	binary := newCloudEventsTestMessage("data")
+	binary.MessageAttributes = map[string]sqstypes.MessageAttributeValue{}
+	for _, name := range []string{"ce_specversion", "ce_id", "ce_source", "ce_type"} {
+		binary.MessageAttributes[name] = sqstypes.MessageAttributeValue{
+			DataType: aws.String("String"), StringValue: aws.String("x"),
+		}
+	}
+	if _, err := newMessage(binary, time.Now(), opt, 0); err != nil {
+		t.Errorf("binary: %v", err)
+	}
+
+	_, err := newMessage(newCloudEventsTestMessage(`{"order":7}`), time.Now(), opt, 0)
+	if !errors.Is(err, errTransform) || !errors.Is(err, errNotCloudEvent) {
+		t.Errorf("expected not a CloudEvent transform error, got %v", err)
+	}
+}
+
+// go test -count 1 -run '^TestCloudEventsValidate$' ./...
+func TestCloudEventsValidate(t *testing.T) {
+	table := []struct {
+		name  string
+		cfg   cloudEventsConfig
+		valid bool
+	}{
+		{"disabled", cloudEventsConfig{}, true},
+		{"passthrough", cloudEventsConfig{Mode: cloudEventsPassthrough}, true},
+		{"structured", cloudEventsConfig{Mode: cloudEventsStructured, Type: "t"}, true},
+		{"binary without type", cloudEventsConfig{Mode: cloudEventsBinary}, false},
+		{"bad mode", cloudEventsConfig{Mode: "batch"}, false},
+	}
+	for _, data := range table {
+		if err := data.cfg.validate(); (err == nil) != data.valid {
+			t.Errorf("%s: expected valid=%t, got error: %v", data.name, data.valid, err)
+		}
+	}
+}
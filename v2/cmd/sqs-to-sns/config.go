@@ -2,9 +2,13 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
+	"slices"
 	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/udhos/boilerplate/envconfig"
 	"gopkg.in/yaml.v3"
 )
@@ -40,24 +44,52 @@ type config struct {
 }
 
 type queueConfig struct {
-	ID                   string        `yaml:"id"`
-	QueueURL             string        `yaml:"queue_url"`
-	QueueRoleArn         string        `yaml:"queue_role_arn"`
-	TopicArn             string        `yaml:"topic_arn"`
-	TopicRoleArn         string        `yaml:"topic_role_arn"`
-	BufferSizePublish    int           `yaml:"buffer_size_publish"`
-	BufferSizeDelete     int           `yaml:"buffer_size_delete"`
-	LimitReaders         int64         `yaml:"limit_readers"`
-	LimitPublishers      int64         `yaml:"limit_publishers"`
-	LimitDeleters        int64         `yaml:"limit_deleters"`
-	MaxNumberOfMessages  int32         `yaml:"max_number_of_messages"` // 1..10 (default 10)
-	WaitTimeSeconds      *int32        `yaml:"wait_time_seconds"`      // 0..20 (default 20)
-	CopyAttributes       *bool         `yaml:"copy_attributes"`
-	CopyMesssageGroupID  *bool         `yaml:"copy_message_group_id"`
-	EmptyReceiveCooldown time.Duration `yaml:"empty_receive_cooldown"`
-	ReceiveErrorCooldown time.Duration `yaml:"receive_error_cooldown"`
-	PublishErrorCooldown time.Duration `yaml:"publish_error_cooldown"`
-	DeleteErrorCooldown  time.Duration `yaml:"delete_error_cooldown"`
+	ID                         string             `yaml:"id"`
+	QueueURL                   string             `yaml:"queue_url"`
+	QueueRoleArn               string             `yaml:"queue_role_arn"`
+	TopicArn                   string             `yaml:"topic_arn"`
+	TopicArns                  []string           `yaml:"topic_arns"` // fan-out, instead of topic_arn
+	Destination                destinationConfig  `yaml:"destination"`
+	Routes                     []routeConfig      `yaml:"routes"`
+	UnmatchedAction            string             `yaml:"unmatched_action"` // default, leave, delete
+	TopicRoleArn               string             `yaml:"topic_role_arn"`
+	BufferSizePublish          int                `yaml:"buffer_size_publish"`
+	BufferSizeDelete           int                `yaml:"buffer_size_delete"`
+	LimitReaders               int64              `yaml:"limit_readers"`
+	LimitPublishers            int64              `yaml:"limit_publishers"`
+	LimitDeleters              int64              `yaml:"limit_deleters"`
+	MaxNumberOfMessages        int32              `yaml:"max_number_of_messages"` // 1..10 (default 10)
+	WaitTimeSeconds            *int32             `yaml:"wait_time_seconds"`      // 0..20 (default 20)
+	CopyAttributes             *bool              `yaml:"copy_attributes"`
+	Attributes                 attributesConfig   `yaml:"attributes"`
+	ExtractAttributes          []extractAttribute `yaml:"extract_attributes"`
+	CopyMesssageGroupID        *bool              `yaml:"copy_message_group_id"`
+	CopyMessageDeduplicationID *bool              `yaml:"copy_message_deduplication_id"` // only for FIFO topics
+	DeduplicationIDSource      string             `yaml:"deduplication_id_source"`       // sqs, message_id, body_hash
+	MessageGroupIDFrom         messageGroupIDFrom `yaml:"message_group_id_from"`
+	EmptyReceiveCooldown       time.Duration      `yaml:"empty_receive_cooldown"`
+	ReceiveErrorCooldown       time.Duration      `yaml:"receive_error_cooldown"`
+	PublishErrorCooldown       time.Duration      `yaml:"publish_error_cooldown"`
+	DeleteErrorCooldown        time.Duration      `yaml:"delete_error_cooldown"`
+	VisibilityTimeout          time.Duration      `yaml:"visibility_timeout"` // 0 disables heartbeat
+	NackPolicy                 string             `yaml:"nack_policy"`        // cooldown, immediate, backoff
+	NackBackoffMin             time.Duration      `yaml:"nack_backoff_min"`
+	NackBackoffMax             time.Duration      `yaml:"nack_backoff_max"`
+	MaxPublishAttempts         int                `yaml:"max_publish_attempts"`
+	PublishFailureAction       string             `yaml:"publish_failure_action"` // release, delete, quarantine
+	MaxReceiveCount            int                `yaml:"max_receive_count"`      // 0 disables quarantine by receive count
+	Quarantine                 quarantineConfig   `yaml:"quarantine"`
+	OversizePolicy             string             `yaml:"oversize_policy"` // leave, delete, dead_letter, truncate, claim_check
+	ClaimCheck                 claimCheckConfig   `yaml:"claim_check"`
+	Compress                   bool               `yaml:"compress"`
+	CompressThreshold          int                `yaml:"compress_threshold"` // bytes (default 16384)
+	UnwrapSNSEnvelope          bool               `yaml:"unwrap_sns_envelope"`
+	VerifySNSEnvelope          bool               `yaml:"verify_sns_envelope"` // requires unwrap_sns_envelope
+	BodyTemplate               string             `yaml:"body_template"`       // text/template
+	CloudEvents                cloudEventsConfig  `yaml:"cloudevents"`
+	Aggregate                  aggregateConfig    `yaml:"aggregate"`
+	Archive                    archiveConfig      `yaml:"archive"`
+	SplitJSONArray             bool               `yaml:"split_json_array"`
 }
 
 func newConfig(env *envconfig.Env) config {
@@ -103,6 +135,14 @@ func loadQueueConf(cfg config) []queueConfig {
 			me, queuesFile, errYaml)
 	}
 	queues = applyQueuesDefaults(queues)
+	for _, q := range queues {
+		if err := validateQueueConfig(q); err != nil {
+			fatalf("%s: queue %s: %v", me, q.ID, err)
+		}
+	}
+	if err := validateSinkFiles(queues); err != nil {
+		fatalf("%s: %v", me, err)
+	}
 	return queues
 }
 
@@ -114,7 +154,9 @@ func toJSON(v any) string {
 func applyQueuesDefaults(queues []queueConfig) []queueConfig {
 	for i, q := range queues {
 		queues[i] = queueDefaults(q)
-		infof("queue %s: %s", q.ID, toJSON(queues[i]))
+		logged := queues[i]
+		logged.Destination = logged.Destination.redacted()
+		infof("queue %s: %s", q.ID, toJSON(logged))
 	}
 	return queues
 }
@@ -128,13 +170,228 @@ const (
 	defaultWaitTimeSeconds            int32 = 20
 	defaultCopyAttributes                   = true
 	defaultCopyMesssageGroupID              = true
+	defaultCopyMessageDeduplicationID       = true
+	defaultDeduplicationIDSource            = deduplicationIDSourceSqs
 	defaultEmptyReceiveCooldown             = 1 * time.Second
 	defaultReceiveErrorCooldown             = 1 * time.Second
 	defaultPublishErrorCooldown             = 1 * time.Second
 	defaultDeleteErrorCooldown              = 1 * time.Second
+	defaultNackPolicy                       = nackPolicyCooldown
+	defaultNackBackoffMin                   = 1 * time.Second
+	defaultNackBackoffMax                   = 5 * time.Minute
+	defaultMaxPublishAttempts               = 3
+	defaultPublishFailureAction             = failureActionRelease
+	defaultOversizePolicy                   = oversizePolicyLeave
+	defaultCompressThreshold                = 16384
+	defaultUnmatchedAction                  = unmatchedActionDefault
+	defaultAttributeOverflow                = attributeOverflowPack
 )
 
+const maxVisibilityTimeout = 12 * time.Hour
+
+// validateQueueConfig checks settings that have no sensible default.
+func validateQueueConfig(q queueConfig) error {
+	if err := validateDestination(q); err != nil {
+		return err
+	}
+	if err := validateTopics(q); err != nil {
+		return err
+	}
+	if err := validateRoutes(q); err != nil {
+		return err
+	}
+	if err := validateFifo(q); err != nil {
+		return err
+	}
+	if q.VisibilityTimeout != 0 && (q.VisibilityTimeout < time.Second || q.VisibilityTimeout > maxVisibilityTimeout) {
+		return fmt.Errorf("visibility_timeout=%v must be between 1s and %v",
+			q.VisibilityTimeout, maxVisibilityTimeout)
+	}
+	switch q.NackPolicy {
+	case nackPolicyCooldown, nackPolicyImmediate, nackPolicyBackoff:
+	default:
+		return fmt.Errorf("nack_policy=%q must be one of: %s, %s, %s",
+			q.NackPolicy, nackPolicyCooldown, nackPolicyImmediate, nackPolicyBackoff)
+	}
+	if q.NackBackoffMax > maxVisibilityTimeout {
+		return fmt.Errorf("nack_backoff_max=%v must not exceed %v",
+			q.NackBackoffMax, maxVisibilityTimeout)
+	}
+	if q.NackBackoffMin > q.NackBackoffMax {
+		return fmt.Errorf("nack_backoff_min=%v must not exceed nack_backoff_max=%v",
+			q.NackBackoffMin, q.NackBackoffMax)
+	}
+	switch q.PublishFailureAction {
+	case failureActionRelease, failureActionDelete, failureActionQuarantine:
+	default:
+		return fmt.Errorf("publish_failure_action=%q must be one of: %s, %s, %s",
+			q.PublishFailureAction, failureActionRelease, failureActionDelete, failureActionQuarantine)
+	}
+	if q.Quarantine.QueueURL != "" && q.Quarantine.TopicArn != "" {
+		return errors.New("quarantine must set either queue_url or topic_arn, not both")
+	}
+	if q.MaxReceiveCount > 0 && !q.Quarantine.enabled() {
+		return fmt.Errorf("max_receive_count=%d requires quarantine queue_url or topic_arn",
+			q.MaxReceiveCount)
+	}
+	if q.PublishFailureAction == failureActionQuarantine && !q.Quarantine.enabled() {
+		return errors.New("publish_failure_action=quarantine requires quarantine queue_url or topic_arn")
+	}
+	if err := q.Attributes.validate(); err != nil {
+		return err
+	}
+	if err := validateExtractAttributes(q.ExtractAttributes); err != nil {
+		return err
+	}
+	if q.BodyTemplate != "" {
+		if _, err := parseBodyTemplate(q.BodyTemplate); err != nil {
+			return fmt.Errorf("body_template: %w", err)
+		}
+	}
+	if err := q.CloudEvents.validate(); err != nil {
+		return err
+	}
+	if err := validateAggregate(q); err != nil {
+		return err
+	}
+	if err := validateSplit(q); err != nil {
+		return err
+	}
+	if err := validateArchive(q); err != nil {
+		return err
+	}
+	if q.VerifySNSEnvelope && !q.UnwrapSNSEnvelope {
+		return errors.New("verify_sns_envelope requires unwrap_sns_envelope")
+	}
+	switch q.OversizePolicy {
+	case oversizePolicyLeave, oversizePolicyDelete, oversizePolicyTruncate:
+	case oversizePolicyDeadLetter:
+		if !q.Quarantine.enabled() {
+			return errors.New("oversize_policy=dead_letter requires quarantine queue_url or topic_arn")
+		}
+	case oversizePolicyClaimCheck:
+		if q.ClaimCheck.Bucket == "" {
+			return errors.New("oversize_policy=claim_check requires claim_check bucket")
+		}
+		if q.Attributes.attributeLimit() < 2 {
+			return errors.New("oversize_policy=claim_check requires attributes max of at least 2")
+		}
+	default:
+		return fmt.Errorf("oversize_policy=%q must be one of: %s, %s, %s, %s, %s",
+			q.OversizePolicy, oversizePolicyLeave, oversizePolicyDelete,
+			oversizePolicyDeadLetter, oversizePolicyTruncate, oversizePolicyClaimCheck)
+	}
+	return nil
+}
+
+// topics returns the topics a queue publishes to: the default topic_arn,
+// if any, followed by the distinct route topics. For the other destination
+// types, it returns the destination queue, bus, url or path.
+func (q queueConfig) topics() []string {
+	switch q.Destination.Type {
+	case destinationSQS:
+		return []string{q.Destination.QueueURL}
+	case destinationEventBridge:
+		return []string{q.Destination.EventBus}
+	case destinationWebhook:
+		return []string{q.Destination.URL}
+	case destinationFile:
+		return []string{q.Destination.Path}
+	}
+	if len(q.TopicArns) > 0 {
+		return q.TopicArns
+	}
+	if len(q.Routes) == 0 {
+		return []string{q.TopicArn}
+	}
+	var topics []string
+	if q.TopicArn != "" {
+		topics = append(topics, q.TopicArn)
+	}
+	for _, r := range q.Routes {
+		if !slices.Contains(topics, r.TopicArn) {
+			topics = append(topics, r.TopicArn)
+		}
+	}
+	return topics
+}
+
+// anyFifoTopic reports whether some topic of the queue is a FIFO topic.
+func (q queueConfig) anyFifoTopic() bool {
+	return slices.ContainsFunc(q.topics(), isFifo)
+}
+
+// validateTopics checks the fan-out topic list.
+func validateTopics(q queueConfig) error {
+	if q.TopicArn != "" && len(q.TopicArns) > 0 {
+		return errors.New("queue must set either topic_arn or topic_arns, not both")
+	}
+	if n := len(q.topics()); n > maxDestinations {
+		return fmt.Errorf("queue has %d topics, must not exceed %d",
+			n, maxDestinations)
+	}
+	seen := map[string]bool{}
+	for _, t := range q.TopicArns {
+		if t == "" {
+			return errors.New("topic_arns must not hold empty topics")
+		}
+		if seen[t] {
+			return fmt.Errorf("topic_arns lists topic %s twice", t)
+		}
+		seen[t] = true
+	}
+	return nil
+}
+
+// validateFifo checks that a FIFO topic gets the MessageGroupId and
+// MessageDeduplicationId it needs.
+func validateFifo(q queueConfig) error {
+	switch q.DeduplicationIDSource {
+	case deduplicationIDSourceSqs, deduplicationIDSourceMessageID, deduplicationIDSourceBodyHash:
+	default:
+		return fmt.Errorf("deduplication_id_source=%q must be one of: %s, %s, %s",
+			q.DeduplicationIDSource, deduplicationIDSourceSqs,
+			deduplicationIDSourceMessageID, deduplicationIDSourceBodyHash)
+	}
+	if err := q.MessageGroupIDFrom.validate(); err != nil {
+		return err
+	}
+	if !q.anyFifoTopic() {
+		return nil
+	}
+	if aws.ToBool(q.CopyMessageDeduplicationID) && q.DeduplicationIDSource == deduplicationIDSourceSqs && !isFifo(q.QueueURL) {
+		return errors.New("deduplication_id_source=sqs requires a FIFO queue, use message_id or body_hash")
+	}
+	if q.MessageGroupIDFrom.enabled() {
+		return nil
+	}
+	if !aws.ToBool(q.CopyMesssageGroupID) {
+		return errors.New("FIFO topic requires copy_message_group_id=true or message_group_id_from")
+	}
+	if !isFifo(q.QueueURL) {
+		return errors.New("FIFO topic requires message_group_id_from, since a standard queue has no MessageGroupId")
+	}
+	return nil
+}
+
 func queueDefaults(q queueConfig) queueConfig {
+	if q.Destination.Type == "" {
+		q.Destination.Type = destinationSNS
+	}
+	if q.Destination.Type == destinationEventBridge && q.Destination.EventBus == "" {
+		q.Destination.EventBus = defaultEventBus
+	}
+	if q.Destination.Type == destinationFile && q.Destination.RotateBytes == 0 {
+		q.Destination.RotateBytes = defaultRotateBytes
+	}
+	if q.Destination.Type == destinationWebhook {
+		if q.Destination.SignatureHeader == "" {
+			q.Destination.SignatureHeader = defaultWebhookSignatureHeader
+		}
+		if q.Destination.Timeout == 0 {
+			q.Destination.Timeout = defaultWebhookTimeout
+		}
+	}
 	if q.BufferSizePublish < 1 {
 		q.BufferSizePublish = defaultBufferSize
 	}
@@ -165,6 +422,13 @@ func queueDefaults(q queueConfig) queueConfig {
 		b := defaultCopyMesssageGroupID
 		q.CopyMesssageGroupID = &b
 	}
+	if q.CopyMessageDeduplicationID == nil {
+		b := defaultCopyMessageDeduplicationID
+		q.CopyMessageDeduplicationID = &b
+	}
+	if q.DeduplicationIDSource == "" {
+		q.DeduplicationIDSource = defaultDeduplicationIDSource
+	}
 	if q.EmptyReceiveCooldown < 1 {
 		q.EmptyReceiveCooldown = defaultEmptyReceiveCooldown
 	}
@@ -177,6 +441,51 @@ func queueDefaults(q queueConfig) queueConfig {
 	if q.DeleteErrorCooldown < 1 {
 		q.DeleteErrorCooldown = defaultDeleteErrorCooldown
 	}
+	if q.NackPolicy == "" {
+		q.NackPolicy = defaultNackPolicy
+	}
+	if q.NackBackoffMin < 1 {
+		q.NackBackoffMin = defaultNackBackoffMin
+	}
+	if q.NackBackoffMax < 1 {
+		q.NackBackoffMax = defaultNackBackoffMax
+	}
+	if q.MaxPublishAttempts < 1 {
+		q.MaxPublishAttempts = defaultMaxPublishAttempts
+	}
+	if q.PublishFailureAction == "" {
+		q.PublishFailureAction = defaultPublishFailureAction
+	}
+	if q.OversizePolicy == "" {
+		q.OversizePolicy = defaultOversizePolicy
+	}
+	if q.CompressThreshold < 1 {
+		q.CompressThreshold = defaultCompressThreshold
+	}
+	if q.Aggregate.MaxMessages < 1 {
+		q.Aggregate.MaxMessages = defaultAggregateMaxMessages
+	}
+	if q.Aggregate.MaxBytes < 1 {
+		q.Aggregate.MaxBytes = q.Destination.limits().messageLimit()
+	}
+	if q.Archive.MaxBytes < 1 {
+		q.Archive.MaxBytes = defaultArchiveMaxBytes
+	}
+	if q.Archive.MaxAge < 1 {
+		q.Archive.MaxAge = defaultArchiveMaxAge
+	}
+	if q.Archive.BufferSize < 1 {
+		q.Archive.BufferSize = defaultArchiveBufferSize
+	}
+	if q.UnmatchedAction == "" {
+		q.UnmatchedAction = defaultUnmatchedAction
+	}
+	if q.Attributes.Max < 1 {
+		q.Attributes.Max = maxMessageAttributes
+	}
+	if q.Attributes.Overflow == "" {
+		q.Attributes.Overflow = defaultAttributeOverflow
+	}
 
 	return q
 }
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// Sources for the MessageDeduplicationId of entries published to FIFO topics.
+const (
+	// deduplicationIDSourceSqs copies the SQS MessageDeduplicationId.
+	// Requires a FIFO queue.
+	deduplicationIDSourceSqs = "sqs"
+
+	// deduplicationIDSourceMessageID uses the SQS MessageId.
+	deduplicationIDSourceMessageID = "message_id"
+
+	// deduplicationIDSourceBodyHash uses the hex SHA-256 of the body.
+	deduplicationIDSourceBodyHash = "body_hash"
+)
+
+// deduplicationID returns the MessageDeduplicationId for an SQS message,
+// or empty string if the source has none.
+func deduplicationID(sqsMessage *sqstypes.Message, source string) string {
+	switch source {
+	case deduplicationIDSourceMessageID:
+		return aws.ToString(sqsMessage.MessageId)
+	case deduplicationIDSourceBodyHash:
+		sum := sha256.Sum256([]byte(aws.ToString(sqsMessage.Body)))
+		return hex.EncodeToString(sum[:])
+	default:
+		return sqsMessage.Attributes["MessageDeduplicationId"]
+	}
+}
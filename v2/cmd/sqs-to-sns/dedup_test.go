@@ -0,0 +1,109 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// go test -count 1 -run '^TestDeduplicationID$' ./...
+func TestDeduplicationID(t *testing.T) {
+	sqsMsg := &sqstypes.Message{
+		MessageId:  aws.String("id1"),
+		Body:       aws.String("hello"),
+		Attributes: map[string]string{"MessageDeduplicationId": "dedup1"},
+	}
+
+	table := []struct {
+		source   string
+		expected string
+	}{
+		{deduplicationIDSourceSqs, "dedup1"},
+		{deduplicationIDSourceMessageID, "id1"},
+		{deduplicationIDSourceBodyHash, "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"},
+	}
+
+	for _, data := range table {
+		if got := deduplicationID(sqsMsg, data.source); got != data.expected {
+			t.Errorf("source=%s: expected=%s got=%s", data.source, data.expected, got)
+		}
+	}
+}
+
+// go test -count 1 -run '^TestNewMessageDeduplicationID$' ./...
+func TestNewMessageDeduplicationID(t *testing.T) {
+	sqsMsg := &sqstypes.Message{
+		MessageId: aws.String("id1"),
+		Body:      aws.String("hello"),
+		Attributes: map[string]string{
+			"MessageGroupId":         "group1",
+			"MessageDeduplicationId": "dedup1",
+		},
+	}
+
+	fifoTopic := newTestMessageOptions(t, queueDefaults(queueConfig{
+		QueueURL: "https://sqs.us-east-1.amazonaws.com/123456789012/q1.fifo",
+		TopicArn: "arn:aws:sns:us-east-1:123456789012:t1.fifo",
+	}))
+
+	m, err := newMessage(sqsMsg, time.Now(), fifoTopic, 0)
+	if err != nil {
+		t.Fatalf("message: %v", err)
+	}
+	if got := aws.ToString(m.snsBatchEntry.MessageDeduplicationId); got != "dedup1" {
+		t.Errorf("FIFO topic: expected dedup id=dedup1 got=%s", got)
+	}
+	if got := aws.ToString(m.snsBatchEntry.MessageGroupId); got != "group1" {
+		t.Errorf("FIFO topic: expected group id=group1 got=%s", got)
+	}
+
+	standardTopic := newTestMessageOptions(t, queueDefaults(queueConfig{
+		QueueURL: "https://sqs.us-east-1.amazonaws.com/123456789012/q1.fifo",
+		TopicArn: "arn:aws:sns:us-east-1:123456789012:t1",
+	}))
+
+	m, err = newMessage(sqsMsg, time.Now(), standardTopic, 0)
+	if err != nil {
+		t.Fatalf("message: %v", err)
+	}
+	if m.snsBatchEntry.MessageDeduplicationId != nil {
+		t.Errorf("standard topic must not get dedup id, got %s",
+			aws.ToString(m.snsBatchEntry.MessageDeduplicationId))
+	}
+}
+
+// go test -count 1 -run '^TestValidateFifo$' ./...
+func TestValidateFifo(t *testing.T) {
+	const (
+		fifoQueue     = "https://sqs.us-east-1.amazonaws.com/123456789012/q1.fifo"
+		standardQueue = "https://sqs.us-east-1.amazonaws.com/123456789012/q1"
+		fifoTopic     = "arn:aws:sns:us-east-1:123456789012:t1.fifo"
+		standardTopic = "arn:aws:sns:us-east-1:123456789012:t1"
+	)
+
+	table := []struct {
+		name  string
+		q     queueConfig
+		valid bool
+	}{
+		{"standard to standard", queueConfig{QueueURL: standardQueue, TopicArn: standardTopic}, true},
+		{"fifo to fifo", queueConfig{QueueURL: fifoQueue, TopicArn: fifoTopic}, true},
+		{"fifo to fifo by body hash", queueConfig{QueueURL: fifoQueue, TopicArn: fifoTopic, DeduplicationIDSource: deduplicationIDSourceBodyHash}, true},
+		{"fifo to fifo content-based", queueConfig{QueueURL: fifoQueue, TopicArn: fifoTopic, CopyMessageDeduplicationID: aws.Bool(false)}, true},
+		{"fifo without group", queueConfig{QueueURL: fifoQueue, TopicArn: fifoTopic, CopyMesssageGroupID: aws.Bool(false)}, false},
+		{"standard to fifo", queueConfig{QueueURL: standardQueue, TopicArn: fifoTopic, DeduplicationIDSource: deduplicationIDSourceMessageID}, false},
+		{"standard to fifo sqs dedup", queueConfig{QueueURL: standardQueue, TopicArn: fifoTopic}, false},
+		{"standard to fifo derived group", queueConfig{QueueURL: standardQueue, TopicArn: fifoTopic, DeduplicationIDSource: deduplicationIDSourceMessageID, MessageGroupIDFrom: messageGroupIDFrom{HashBuckets: 8}}, true},
+		{"ambiguous group source", queueConfig{QueueURL: fifoQueue, TopicArn: fifoTopic, MessageGroupIDFrom: messageGroupIDFrom{Attribute: "a", JSONPath: "b"}}, false},
+		{"bad source", queueConfig{QueueURL: fifoQueue, TopicArn: fifoTopic, DeduplicationIDSource: "bad"}, false},
+	}
+
+	for _, data := range table {
+		err := validateQueueConfig(queueDefaults(data.q))
+		if (err == nil) != data.valid {
+			t.Errorf("%s: expected valid=%t, got error: %v", data.name, data.valid, err)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Destination types.
+const (
+	// destinationSNS publishes to topic_arn, topic_arns or routes
+	// with SNS PublishBatch.
+	destinationSNS = "sns"
+
+	// destinationSQS sends to destination.queue_url with SQS
+	// SendMessageBatch, possibly in another account or region.
+	destinationSQS = "sqs"
+
+	// destinationEventBridge puts events to destination.event_bus
+	// with EventBridge PutEvents.
+	destinationEventBridge = "eventbridge"
+
+	// destinationWebhook POSTs to destination.url, one request per
+	// message or one JSON array per batch.
+	destinationWebhook = "webhook"
+
+	// destinationFile writes JSON lines to destination.path, or to
+	// standard output.
+	destinationFile = "file"
+)
+
+// maxSqsSendPayload is the SQS limit for a message, and for a SendMessageBatch.
+const maxSqsSendPayload = 1048576
+
+// maxPutEventsPayload is the EventBridge limit for a PutEvents request.
+const maxPutEventsPayload = 262144
+
+// eventBridgeEntryReserve is room kept in every PutEvents entry for the
+// detail type taken from an attribute, and the event time.
+const eventBridgeEntryReserve = 256
+
+const defaultEventBus = "default"
+
+// maxWebhookPayload is our limit for a webhook request.
+const maxWebhookPayload = 1048576
+
+// maxFilePayload is our limit for a batch written to a file destination.
+const maxFilePayload = 1048576
+
+const defaultRotateBytes = 100 * 1024 * 1024
+
+const (
+	defaultWebhookSignatureHeader = "X-Signature-256"
+	defaultWebhookTimeout         = 10 * time.Second
+)
+
+// destinationConfig selects where a queue forwards its messages.
+// Messages are always converted to SNS entries first, then every
+// destination builds its own request entries from them.
+type destinationConfig struct {
+	Type     string `yaml:"type"`      // sns, sqs, eventbridge, webhook, file (default sns)
+	QueueURL string `yaml:"queue_url"` // for sqs
+	RoleArn  string `yaml:"role_arn"`  // for sns, sqs and eventbridge, defaults to queue_role_arn
+
+	EventBus            string `yaml:"event_bus"`             // for eventbridge, name or ARN (default "default")
+	Region              string `yaml:"region"`                // for eventbridge, defaults to the bus or queue region
+	Source              string `yaml:"source"`                // for eventbridge
+	DetailType          string `yaml:"detail_type"`           // for eventbridge
+	DetailTypeAttribute string `yaml:"detail_type_attribute"` // for eventbridge, overrides detail_type
+
+	URL             string            `yaml:"url"`              // for webhook
+	Headers         map[string]string `yaml:"headers"`          // for webhook
+	SecretEnv       string            `yaml:"secret_env"`       // for webhook, env var holding the HMAC-SHA256 key
+	SignatureHeader string            `yaml:"signature_header"` // for webhook (default X-Signature-256)
+	Timeout         time.Duration     `yaml:"timeout"`          // for webhook, per request or batch (default 10s)
+	Batch           bool              `yaml:"batch"`            // for webhook, POST a JSON array per batch
+
+	Path        string `yaml:"path"`         // for file, "-" is standard output
+	RotateBytes int64  `yaml:"rotate_bytes"` // for file (default 100 MiB), -1 disables rotation
+}
+
+// destinationLimits are the batch limits a destination accepts.
+type destinationLimits struct {
+	maxItems      int // entries per batch
+	maxBytes      int // payload per batch, and per message
+	entryOverhead int // bytes the destination adds to every entry
+}
+
+// messageLimit is the room for a converted message in an entry.
+func (l destinationLimits) messageLimit() int {
+	return l.maxBytes - l.entryOverhead
+}
+
+func (c destinationConfig) limits() destinationLimits {
+	switch c.Type {
+	case destinationSQS:
+		return destinationLimits{maxItems: maxBatchItems, maxBytes: maxSqsSendPayload}
+	case destinationEventBridge:
+		return destinationLimits{maxItems: maxBatchItems, maxBytes: maxPutEventsPayload,
+			entryOverhead: len(c.Source) + max(len(c.DetailType), eventBridgeEntryReserve)}
+	case destinationWebhook:
+		return destinationLimits{maxItems: maxBatchItems, maxBytes: maxWebhookPayload}
+	case destinationFile:
+		return destinationLimits{maxItems: maxBatchItems, maxBytes: maxFilePayload}
+	}
+	return destinationLimits{maxItems: maxBatchItems, maxBytes: maxSnsPublishPayload}
+}
+
+// redacted returns a copy safe to log. Header values are hidden, since
+// they may hold credentials, like Authorization tokens.
+func (c destinationConfig) redacted() destinationConfig {
+	if len(c.Headers) == 0 {
+		return c
+	}
+	headers := make(map[string]string, len(c.Headers))
+	for name := range c.Headers {
+		headers[name] = "REDACTED"
+	}
+	c.Headers = headers
+	return c
+}
+
+// eventBusRegion returns the region of an eventbridge destination: the
+// configured region, else the region of the bus ARN, else the region of
+// the source queue.
+func (c destinationConfig) eventBusRegion(queueURL string) string {
+	if c.Region != "" {
+		return c.Region
+	}
+	// arn:aws:events:us-east-1:123456789012:event-bus/name
+	if fields := strings.SplitN(c.EventBus, ":", 5); len(fields) == 5 && fields[0] == "arn" {
+		return fields[3]
+	}
+	// https://sqs.us-east-1.amazonaws.com/123456789012/name
+	if fields := strings.SplitN(queueURL, ".", 3); len(fields) == 3 {
+		return fields[1]
+	}
+	return ""
+}
+
+func validateDestination(q queueConfig) error {
+	d := q.Destination
+	switch d.Type {
+	case destinationSNS:
+		if d.QueueURL != "" {
+			return errors.New("destination queue_url requires destination type=sqs")
+		}
+	case destinationEventBridge:
+		if d.QueueURL != "" {
+			return errors.New("destination queue_url requires destination type=sqs")
+		}
+		if d.Source == "" {
+			return errors.New("destination type=eventbridge requires source")
+		}
+		if d.DetailType == "" && d.DetailTypeAttribute == "" {
+			return errors.New("destination type=eventbridge requires detail_type or detail_type_attribute")
+		}
+		if d.eventBusRegion(q.QueueURL) == "" {
+			return errors.New("destination type=eventbridge requires region")
+		}
+		if q.TopicArn != "" || len(q.TopicArns) > 0 || len(q.Routes) > 0 {
+			return errors.New("destination type=eventbridge does not support topic_arn, topic_arns or routes")
+		}
+		if q.Compress || q.Aggregate.enabled() {
+			return errors.New("destination type=eventbridge does not support compress or aggregate, since detail must be a JSON object")
+		}
+	case destinationWebhook:
+		if d.QueueURL != "" {
+			return errors.New("destination queue_url requires destination type=sqs")
+		}
+		if u, err := url.Parse(d.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("destination type=webhook requires an http or https url, got %q", d.URL)
+		}
+		if d.SecretEnv != "" && os.Getenv(d.SecretEnv) == "" {
+			return fmt.Errorf("destination secret_env=%s: empty environment variable", d.SecretEnv)
+		}
+		if d.Timeout <= 0 {
+			return fmt.Errorf("destination timeout=%v must be positive", d.Timeout)
+		}
+		if q.TopicArn != "" || len(q.TopicArns) > 0 || len(q.Routes) > 0 {
+			return errors.New("destination type=webhook does not support topic_arn, topic_arns or routes")
+		}
+		if q.Compress {
+			return errors.New("destination type=webhook does not support compress")
+		}
+	case destinationFile:
+		if d.QueueURL != "" {
+			return errors.New("destination queue_url requires destination type=sqs")
+		}
+		if d.Path == "" {
+			return fmt.Errorf("destination type=file requires path, %q for standard output", sinkStdout)
+		}
+		if q.TopicArn != "" || len(q.TopicArns) > 0 || len(q.Routes) > 0 {
+			return errors.New("destination type=file does not support topic_arn, topic_arns or routes")
+		}
+	case destinationSQS:
+		if d.QueueURL == "" {
+			return errors.New("destination type=sqs requires queue_url")
+		}
+		if d.QueueURL == q.QueueURL {
+			return errors.New("destination queue_url must not be the source queue")
+		}
+		if q.TopicArn != "" || len(q.TopicArns) > 0 || len(q.Routes) > 0 {
+			return errors.New("destination type=sqs does not support topic_arn, topic_arns or routes")
+		}
+	default:
+		return fmt.Errorf("destination type=%q must be one of: %s, %s, %s, %s, %s",
+			d.Type, destinationSNS, destinationSQS, destinationEventBridge, destinationWebhook, destinationFile)
+	}
+	return nil
+}
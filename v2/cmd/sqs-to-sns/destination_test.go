@@ -0,0 +1,313 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	snstypes "github.com/aws/aws-sdk-go-v2/service/sns/types"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/udhos/sqs-to-sns/v2/internal/eventbridgeclient"
+)
+
+// go test -count 1 -run '^TestDestinationPayloadLimit$' ./...
+func TestDestinationPayloadLimit(t *testing.T) {
+	sqsMsg := &sqstypes.Message{
+		MessageId: aws.String("id1"),
+		Body:      aws.String(strings.Repeat("a", 400_000)),
+	}
+
+	cfg := queueDefaults(queueConfig{TopicArn: "topic"})
+	if _, err := newMessage(sqsMsg, time.Now(), newTestMessageOptions(t, cfg), 0); !errors.Is(err, errInvalidPayloadSize) {
+		t.Errorf("sns: expected oversize, got %v", err)
+	}
+
+	cfg = queueDefaults(queueConfig{
+		QueueURL:    "https://sqs.us-east-1.amazonaws.com/111111111111/source",
+		Destination: destinationConfig{Type: destinationSQS, QueueURL: "https://sqs.eu-west-1.amazonaws.com/222222222222/target"},
+	})
+	if err := validateQueueConfig(cfg); err != nil {
+		t.Fatalf("config: %v", err)
+	}
+
+	m, err := newMessage(sqsMsg, time.Now(), newTestMessageOptions(t, cfg), 0)
+	if err != nil {
+		t.Fatalf("sqs: expected message to fit, got %v", err)
+	}
+
+	// the pool packs batches up to the SQS limit
+	d := newDestination(0, cfg.topics()[0], cfg, 0, &publisherMock{}, slog.Default())
+	if d.target != cfg.Destination.QueueURL {
+		t.Errorf("expected destination target %s, got %s", cfg.Destination.QueueURL, d.target)
+	}
+	for range 3 {
+		d.publishPool.add(m)
+	}
+	if batch := d.publishPool.getAvailable(); len(batch) != 2 {
+		t.Errorf("expected 2 messages per SendMessageBatch, got %d", len(batch))
+	}
+}
+
+// go test -count 1 -run '^TestBuildSendEntries$' ./...
+func TestBuildSendEntries(t *testing.T) {
+	sqsMsg := &sqstypes.Message{
+		MessageId: aws.String("id1"),
+		Body:      aws.String("body"),
+		Attributes: map[string]string{
+			"MessageGroupId":         "g1",
+			"MessageDeduplicationId": "d1",
+		},
+		MessageAttributes: map[string]sqstypes.MessageAttributeValue{
+			"tenant": {DataType: aws.String("String"), StringValue: aws.String("t1")},
+			"blob":   {DataType: aws.String("Binary"), BinaryValue: []byte{1, 2}},
+		},
+	}
+	opt := messageOptions{copyAttributes: true, copyMessageGroupID: true, copyDeduplicationID: true}
+	m, err := newMessage(sqsMsg, time.Now(), opt, 0)
+	if err != nil {
+		t.Fatalf("new message: %v", err)
+	}
+
+	entries := buildSendEntriesFromMessages([]message{m}, true)
+	e := entries[0]
+	if aws.ToString(e.MessageBody) != "body" || aws.ToString(e.Id) == "" {
+		t.Errorf("unexpected entry: %+v", e)
+	}
+	if aws.ToString(e.MessageAttributes["tenant"].StringValue) != "t1" || len(e.MessageAttributes["blob"].BinaryValue) != 2 {
+		t.Errorf("expected attributes copied, got %v", e.MessageAttributes)
+	}
+	if aws.ToString(e.MessageGroupId) != "g1" || aws.ToString(e.MessageDeduplicationId) != "d1" {
+		t.Errorf("fifo: expected group and deduplication ids, got %v %v", e.MessageGroupId, e.MessageDeduplicationId)
+	}
+
+	e = buildSendEntriesFromMessages([]message{m}, false)[0]
+	if e.MessageGroupId != nil || e.MessageDeduplicationId != nil {
+		t.Errorf("standard: unexpected group or deduplication id")
+	}
+}
+
+// go test -count 1 -run '^TestValidateDestination$' ./...
+func TestValidateDestination(t *testing.T) {
+	const source = "https://sqs.us-east-1.amazonaws.com/111111111111/source"
+	const target = "https://sqs.us-east-1.amazonaws.com/111111111111/target"
+
+	table := []struct {
+		name  string
+		cfg   queueConfig
+		valid bool
+	}{
+		{"sns", queueConfig{TopicArn: "t"}, true},
+		{"sqs", queueConfig{QueueURL: source, Destination: destinationConfig{Type: destinationSQS, QueueURL: target}}, true},
+		{"sqs without queue_url", queueConfig{Destination: destinationConfig{Type: destinationSQS}}, false},
+		{"sqs loop", queueConfig{QueueURL: source, Destination: destinationConfig{Type: destinationSQS, QueueURL: source}}, false},
+		{"sqs with topic", queueConfig{TopicArn: "t", Destination: destinationConfig{Type: destinationSQS, QueueURL: target}}, false},
+		{"sns with queue_url", queueConfig{TopicArn: "t", Destination: destinationConfig{QueueURL: target}}, false},
+		{"bad type", queueConfig{Destination: destinationConfig{Type: "kafka"}}, false},
+		{"eventbridge", queueConfig{QueueURL: source, Destination: destinationConfig{Type: destinationEventBridge, Source: "s", DetailType: "d"}}, true},
+		{"eventbridge from attribute", queueConfig{QueueURL: source, Destination: destinationConfig{Type: destinationEventBridge, Source: "s", DetailTypeAttribute: "kind"}}, true},
+		{"eventbridge without source", queueConfig{QueueURL: source, Destination: destinationConfig{Type: destinationEventBridge, DetailType: "d"}}, false},
+		{"eventbridge without detail_type", queueConfig{QueueURL: source, Destination: destinationConfig{Type: destinationEventBridge, Source: "s"}}, false},
+		{"eventbridge without region", queueConfig{Destination: destinationConfig{Type: destinationEventBridge, Source: "s", DetailType: "d"}}, false},
+		{"eventbridge with topic", queueConfig{QueueURL: source, TopicArn: "t", Destination: destinationConfig{Type: destinationEventBridge, Source: "s", DetailType: "d"}}, false},
+		{"webhook", queueConfig{Destination: destinationConfig{Type: destinationWebhook, URL: "https://example.com/hook"}}, true},
+		{"webhook without url", queueConfig{Destination: destinationConfig{Type: destinationWebhook}}, false},
+		{"webhook bad scheme", queueConfig{Destination: destinationConfig{Type: destinationWebhook, URL: "ftp://example.com"}}, false},
+		{"webhook missing secret", queueConfig{Destination: destinationConfig{Type: destinationWebhook, URL: "https://example.com/hook", SecretEnv: "SQS_TO_SNS_TEST_UNSET"}}, false},
+		{"webhook with topic", queueConfig{TopicArn: "t", Destination: destinationConfig{Type: destinationWebhook, URL: "https://example.com/hook"}}, false},
+		{"file", queueConfig{Destination: destinationConfig{Type: destinationFile, Path: sinkStdout}}, true},
+		{"file without path", queueConfig{Destination: destinationConfig{Type: destinationFile}}, false},
+		{"file with topic", queueConfig{TopicArn: "t", Destination: destinationConfig{Type: destinationFile, Path: "out.jsonl"}}, false},
+		{"eventbridge with compress", queueConfig{QueueURL: source, Compress: true, Destination: destinationConfig{Type: destinationEventBridge, Source: "s", DetailType: "d"}}, false},
+	}
+	for _, data := range table {
+		if err := validateDestination(queueDefaults(data.cfg)); (err == nil) != data.valid {
+			t.Errorf("%s: expected valid=%t, got error: %v", data.name, data.valid, err)
+		}
+	}
+}
+
+// go test -count 1 -run '^TestDestinationRedacted$' ./...
+func TestDestinationRedacted(t *testing.T) {
+	d := destinationConfig{
+		Type:    destinationWebhook,
+		URL:     "https://example.com/hook",
+		Headers: map[string]string{"Authorization": "Bearer token1"},
+	}
+
+	r := d.redacted()
+
+	if r.Headers["Authorization"] != "REDACTED" || r.URL != d.URL {
+		t.Errorf("unexpected redacted config: %+v", r)
+	}
+	if d.Headers["Authorization"] != "Bearer token1" {
+		t.Errorf("redacted must not change the original headers")
+	}
+}
+
+// go test -count 1 -run '^TestEventBusRegion$' ./...
+func TestEventBusRegion(t *testing.T) {
+	const source = "https://sqs.us-east-1.amazonaws.com/111111111111/source"
+
+	table := []struct {
+		cfg      destinationConfig
+		expected string
+	}{
+		{destinationConfig{EventBus: "default"}, "us-east-1"},
+		{destinationConfig{EventBus: "arn:aws:events:eu-west-1:222222222222:event-bus/bus1"}, "eu-west-1"},
+		{destinationConfig{EventBus: "arn:aws:events:eu-west-1:222222222222:event-bus/bus1", Region: "sa-east-1"}, "sa-east-1"},
+	}
+	for _, data := range table {
+		if region := data.cfg.eventBusRegion(source); region != data.expected {
+			t.Errorf("%s: expected region %s, got %s", data.cfg.EventBus, data.expected, region)
+		}
+	}
+}
+
+// go test -count 1 -run '^TestPublishEventBridgeLocal$' ./...
+func TestPublishEventBridgeLocal(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+
+	// Local EventBridge stand-in: fails the entry with detail-type "throttle".
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if target := r.Header.Get("X-Amz-Target"); target != "AWSEvents.PutEvents" {
+			http.Error(w, "unexpected target: "+target, http.StatusBadRequest)
+			return
+		}
+		requests++
+		data, _ := io.ReadAll(r.Body)
+		var input struct {
+			Entries []struct {
+				Source       string
+				DetailType   string
+				Detail       string
+				EventBusName string
+			}
+		}
+		if err := json.Unmarshal(data, &input); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		type result struct {
+			EventId      string `json:",omitempty"`
+			ErrorCode    string `json:",omitempty"`
+			ErrorMessage string `json:",omitempty"`
+		}
+		var out struct {
+			FailedEntryCount int
+			Entries          []result
+		}
+		for i, e := range input.Entries {
+			if e.Source != "app.orders" || e.EventBusName != "bus1" {
+				http.Error(w, "unexpected entry", http.StatusBadRequest)
+				return
+			}
+			if e.DetailType == "throttle" {
+				out.FailedEntryCount++
+				out.Entries = append(out.Entries, result{ErrorCode: "ThrottlingException", ErrorMessage: "slow down"})
+				continue
+			}
+			out.Entries = append(out.Entries, result{EventId: getBatchEntryID("event", i)})
+		}
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		json.NewEncoder(w).Encode(out)
+	}))
+	defer server.Close()
+
+	pub := &publisherEventBridgeReal{
+		client:              eventbridgeclient.NewClient("test", "us-east-1", "", server.URL),
+		awsAPITimeout:       5 * time.Second,
+		eventBus:            "bus1",
+		source:              "app.orders",
+		detailType:          "OrderEvent",
+		detailTypeAttribute: "kind",
+	}
+
+	newMsg := func(id, body, kind string) message {
+		entry := &snstypes.PublishBatchRequestEntry{Message: aws.String(body)}
+		if kind != "" {
+			entry.MessageAttributes = map[string]snstypes.MessageAttributeValue{
+				"kind": {DataType: aws.String("String"), StringValue: aws.String(kind)},
+			}
+		}
+		return message{
+			sqsMessage: &sqstypes.Message{
+				MessageId:  aws.String(id),
+				Attributes: map[string]string{"SentTimestamp": "1700000000000"},
+			},
+			snsBatchEntry: entry,
+		}
+	}
+
+	msg := []message{
+		newMsg("ok1", `{"a":1}`, ""),
+		newMsg("ok2", `{"a":2}`, "OrderCreated"),
+		newMsg("throttled", `{"a":3}`, "throttle"),
+		newMsg("text", `not json`, ""),
+		newMsg("array", `[1,2]`, ""),
+	}
+
+	q := &queue{logger: slog.Default()}
+
+	pubMsg, failures, err := pub.publish(q, msg)
+	if err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	if requests != 1 {
+		t.Errorf("expected 1 PutEvents request, got %d", requests)
+	}
+	if len(pubMsg) != 2 || aws.ToString(pubMsg[0].sqsMessage.MessageId) != "ok1" ||
+		aws.ToString(pubMsg[1].sqsMessage.MessageId) != "ok2" {
+		t.Errorf("expected ok1 and ok2 published, got %d", len(pubMsg))
+	}
+
+	retryable := map[string]bool{}
+	for _, f := range failures {
+		retryable[aws.ToString(f.msg.sqsMessage.MessageId)] = f.retryable()
+	}
+	expected := map[string]bool{"throttled": true, "text": false, "array": false}
+	if len(retryable) != len(expected) {
+		t.Fatalf("expected failures %v, got %v", expected, retryable)
+	}
+	for id, r := range expected {
+		if got, found := retryable[id]; !found || got != r {
+			t.Errorf("%s: expected failure retryable=%t, got found=%t retryable=%t", id, r, found, got)
+		}
+	}
+
+	// every entry invalid: no request
+	if _, failures, _ := pub.publish(q, msg[3:]); len(failures) != 2 || requests != 1 {
+		t.Errorf("expected 2 local failures and no request, got failures=%d requests=%d", len(failures), requests)
+	}
+}
+
+// go test -count 1 -run '^TestEventBridgeMessageLimit$' ./...
+func TestEventBridgeMessageLimit(t *testing.T) {
+	cfg := queueDefaults(queueConfig{
+		QueueURL:    "https://sqs.us-east-1.amazonaws.com/111111111111/source",
+		Destination: destinationConfig{Type: destinationEventBridge, Source: "app.orders", DetailType: "OrderEvent"},
+	})
+	if err := validateQueueConfig(cfg); err != nil {
+		t.Fatalf("config: %v", err)
+	}
+	if bus := cfg.topics(); len(bus) != 1 || bus[0] != defaultEventBus {
+		t.Errorf("expected default bus, got %v", bus)
+	}
+
+	opt := newTestMessageOptions(t, cfg)
+	sqsMsg := &sqstypes.Message{
+		MessageId: aws.String("id1"),
+		Body:      aws.String(`{"a":"` + strings.Repeat("a", maxPutEventsPayload-10) + `"}`),
+	}
+	if _, err := newMessage(sqsMsg, time.Now(), opt, 0); !errors.Is(err, errInvalidPayloadSize) {
+		t.Errorf("expected oversize below the PutEvents limit, got %v", err)
+	}
+}
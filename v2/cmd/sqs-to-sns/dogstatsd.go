@@ -2,6 +2,7 @@ package main
 
 import (
 	"math"
+	"slices"
 	"time"
 
 	"github.com/udhos/dogstatsdclient/dogstatsdclient"
@@ -27,11 +28,17 @@ func exportDogstatsd(namespace string, dogstatsdInterval time.Duration,
 
 				// Record current goroutine state before harvesting
 				q.stats.receiverGoroutines.record(uint64(q.readers.Load()))
-				q.stats.publisherGoroutines.record(uint64(q.publishers.Load()))
+				var publishers int64
+				for _, d := range q.destinations {
+					publishers += d.publishers.Load()
+				}
+				q.stats.publisherGoroutines.record(uint64(publishers))
 				q.stats.janitorGoroutines.record(uint64(q.janitors.Load()))
 
 				// Also record channel load even if idle
-				q.stats.publishChLoad.record(uint64(channelLoad(q.publishCh) * 100))
+				for _, d := range q.destinations {
+					q.stats.publishChLoad.record(uint64(channelLoad(d.publishCh) * 100))
+				}
 				q.stats.deleteChLoad.record(uint64(channelLoad(q.deleteCh) * 100))
 
 				tags := []string{"queue_id:" + q.queueCfg.ID}
@@ -50,9 +57,31 @@ func exportDogstatsd(namespace string, dogstatsdInterval time.Duration,
 				c.Count("deleted_messages", int64(snap.deletedMessages), tags, sampleRate)
 				c.Count("goroutine_spawns", int64(snap.goroutineSpawns), tags, sampleRate)
 				c.Count("goroutine_exits", int64(snap.goroutineExits), tags, sampleRate)
+				c.Count("visibility_extensions", int64(snap.visibilityExtensions), tags, sampleRate)
+				c.Count("visibility_errors", int64(snap.visibilityErrors), tags, sampleRate)
+				c.Count("nacked_messages", int64(snap.nackedMessages), tags, sampleRate)
+				c.Count("nack_errors", int64(snap.nackErrors), tags, sampleRate)
+				c.Count("retried_messages", int64(snap.retriedMessages), tags, sampleRate)
+				c.Count("failed_messages", int64(snap.failedMessages), tags, sampleRate)
+				dogstatsdCounterMap(c, "publish_failures", "error_code", snap.publishFailures, tags, sampleRate)
+				c.Count("quarantined_messages", int64(snap.quarantinedMessages), tags, sampleRate)
+				c.Count("quarantine_errors", int64(snap.quarantineErrors), tags, sampleRate)
+				dogstatsdCounterMap(c, "oversize_messages", "oversize_policy", snap.oversizeMessages, tags, sampleRate)
+				c.Count("claim_check_errors", int64(snap.claimCheckErrors), tags, sampleRate)
+				c.Count("compressed_messages", int64(snap.compressedMessages), tags, sampleRate)
+				c.Count("transform_errors", int64(snap.transformErrors), tags, sampleRate)
+				dogstatsdCounterMap(c, "attribute_overflows", "overflow", snap.attributeOverflows, tags, sampleRate)
+				c.Count("aggregates", int64(snap.aggregates), tags, sampleRate)
+				c.Count("aggregated_messages", int64(snap.aggregatedMessages), tags, sampleRate)
+				c.Count("archived_messages", int64(snap.archivedMessages), tags, sampleRate)
+				c.Count("archive_drops", int64(snap.archiveDrops), tags, sampleRate)
+				c.Count("archive_errors", int64(snap.archiveErrors), tags, sampleRate)
+				dogstatsdCounterMap(c, "routed_messages", "route", snap.routedMessages, tags, sampleRate)
+				dogstatsdCounterMap(c, "unmatched_messages", "unmatched_action", snap.unmatchedMessages, tags, sampleRate)
 				dogstatsdGauge(c, "publish_channel_load", snap.publishChLoad, tags, sampleRate)
 				dogstatsdGauge(c, "delete_channel_load", snap.deleteChLoad, tags, sampleRate)
 				dogstatsdGauge(c, "forward_latency", snap.forwardLatency, tags, sampleRate)
+				dogstatsdGauge(c, "compression_ratio", snap.compressionRatio, tags, sampleRate)
 				dogstatsdGauge(c, "receiver_goroutines", snap.receiverGoroutines, tags, sampleRate)
 				dogstatsdGauge(c, "publisher_goroutines", snap.publisherGoroutines, tags, sampleRate)
 				dogstatsdGauge(c, "janitor_goroutines", snap.janitorGoroutines, tags, sampleRate)
@@ -75,3 +104,13 @@ func dogstatsdGauge(c *dogstatsdclient.Client, name string, value gaugeSnapshot,
 	c.Gauge(name+"_avg", value.avg, tags, sampleRate)
 	c.Gauge(name+"_max", float64(value.max), tags, sampleRate)
 }
+
+func dogstatsdCounterMap(c *dogstatsdclient.Client, name, tagName string,
+	counters map[string]uint64, tags []string, sampleRate float64) {
+
+	for key, value := range counters {
+		// Clone to avoid clobbering the shared tags backing array.
+		keyTags := append(slices.Clone(tags), tagName+":"+key)
+		c.Count(name, int64(value), keyTags, sampleRate)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/udhos/sqs-to-sns/v2/snsutils"
+)
+
+// errTransform reports a message that could not be converted to an SNS
+// entry. Such messages get the publish_failure_action.
+var errTransform = errors.New("transform error")
+
+// errRetryLater reports a message that could not be converted for a
+// transient reason. Such messages are always released, whatever the
+// publish_failure_action.
+var errRetryLater = errors.New("retry later")
+
+// envelopeVerifier is shared by all queues, so signing certificates
+// are downloaded only once.
+var envelopeVerifier = snsutils.NewVerifier()
+
+// unwrapEnvelope returns a copy of an SQS message delivered by an SNS
+// subscription without raw message delivery. The copy body is the
+// envelope Message, and the envelope MessageAttributes are promoted to
+// message attributes. Receipt handle and system attributes are kept.
+func unwrapEnvelope(sqsMessage *sqstypes.Message, verify bool) (*sqstypes.Message, error) {
+	e, errParse := snsutils.ParseEnvelope(aws.ToString(sqsMessage.Body))
+	if errParse != nil {
+		return nil, fmt.Errorf("%w: %w", errTransform, errParse)
+	}
+
+	if verify {
+		if err := envelopeVerifier.Verify(e); err != nil {
+			if errors.Is(err, snsutils.ErrCertUnavailable) {
+				return nil, fmt.Errorf("%w: envelope signature: %w", errRetryLater, err)
+			}
+			return nil, fmt.Errorf("%w: envelope signature: %w", errTransform, err)
+		}
+	}
+
+	attr := make(map[string]sqstypes.MessageAttributeValue, len(e.MessageAttributes))
+	for name, a := range e.MessageAttributes {
+		v := sqstypes.MessageAttributeValue{DataType: aws.String(a.Type)}
+		if a.Type == "Binary" {
+			data, errDecode := base64.StdEncoding.DecodeString(a.Value)
+			if errDecode != nil {
+				return nil, fmt.Errorf("%w: envelope attribute %s: %w", errTransform, name, errDecode)
+			}
+			v.BinaryValue = data
+		} else {
+			v.StringValue = aws.String(a.Value)
+		}
+		attr[name] = v
+	}
+
+	unwrapped := *sqsMessage
+	unwrapped.Body = aws.String(e.Message)
+	unwrapped.MessageAttributes = attr
+	unwrapped.MD5OfBody = nil
+	unwrapped.MD5OfMessageAttributes = nil
+
+	return &unwrapped, nil
+}
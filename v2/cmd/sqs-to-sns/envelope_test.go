@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/udhos/sqs-to-sns/v2/snsutils"
+)
+
+// go test -count 1 -run '^TestNewMessageUnwrapEnvelope$' ./...
+func TestNewMessageUnwrapEnvelope(t *testing.T) {
+	const envelope = `{"Type":"Notification","MessageId":"sns1","TopicArn":"arn:aws:sns:us-east-1:111111111111:upstream",` +
+		`"Message":"{\"order\":7}","Timestamp":"2024-01-01T00:00:00.000Z",` +
+		`"MessageAttributes":{"tenant":{"Type":"String","Value":"t1"},"amount":{"Type":"Number","Value":"42"},` +
+		`"blob":{"Type":"Binary","Value":"AQI="}}}`
+
+	sqsMsg := &sqstypes.Message{
+		MessageId:     aws.String("sqs1"),
+		ReceiptHandle: aws.String("rh1"),
+		Body:          aws.String(envelope),
+	}
+
+	opt := messageOptions{copyAttributes: true, unwrapEnvelope: true}
+
+	m, err := newMessage(sqsMsg, time.Now(), opt, 0)
+	if err != nil {
+		t.Fatalf("new message: %v", err)
+	}
+
+	if got := aws.ToString(m.snsBatchEntry.Message); got != `{"order":7}` {
+		t.Errorf("expected unwrapped body, got %s", got)
+	}
+	attr := m.snsBatchEntry.MessageAttributes
+	if aws.ToString(attr["tenant"].StringValue) != "t1" || aws.ToString(attr["amount"].DataType) != "Number" {
+		t.Errorf("expected promoted attributes, got %v", attr)
+	}
+	if got := attr["blob"].BinaryValue; len(got) != 2 || got[0] != 1 || got[1] != 2 {
+		t.Errorf("expected decoded binary attribute, got %v", got)
+	}
+	if aws.ToString(m.sqsMessage.ReceiptHandle) != "rh1" {
+		t.Errorf("unwrapped message must keep the receipt handle")
+	}
+
+	// size accounting runs on the unwrapped entry
+	expected := len(`{"order":7}`) +
+		len("tenant") + len("String") + len("t1") +
+		len("amount") + len("Number") + len("42") +
+		len("blob") + len("Binary") + 2
+	if m.snsPayloadSize != expected {
+		t.Errorf("expected size=%d got=%d", expected, m.snsPayloadSize)
+	}
+
+	// raw body is a transform error
+	raw := &sqstypes.Message{MessageId: aws.String("sqs2"), Body: aws.String("plain")}
+	if _, err := newMessage(raw, time.Now(), opt, 0); !errors.Is(err, errTransform) {
+		t.Errorf("expected transform error, got %v", err)
+	}
+}
+
+// go test -count 1 -run '^TestReaderTransformFailure$' ./...
+func TestReaderTransformFailure(t *testing.T) {
+	good, _ := createTestMessage(10)
+	bad := message{
+		sqsMessage: &sqstypes.Message{MessageId: aws.String("bad"), Body: aws.String("plain")},
+		receivedAt: time.Now(),
+		invalid:    true,
+	}
+
+	q := &queue{
+		queueCfg: queueDefaults(queueConfig{
+			PublishFailureAction: failureActionDelete,
+		}),
+		deleteCh: make(chan message, 10),
+		receive:  &receiverListMock{msg: []message{good, bad}},
+		logger:   slog.Default(),
+	}
+	initStats(&q.stats)
+	d := newTestDestination(q, &publisherMock{})
+	q.readers.Add(1)
+
+	app := &application{}
+	app.startReader(q, false)
+
+	if len(d.publishCh) != 1 {
+		t.Errorf("expected 1 message forwarded to publisher, got %d", len(d.publishCh))
+	}
+	if len(q.deleteCh) != 1 {
+		t.Fatalf("expected invalid message deleted, got %d", len(q.deleteCh))
+	}
+	if m := <-q.deleteCh; m.sqsMessage != bad.sqsMessage {
+		t.Errorf("expected invalid message deleted")
+	}
+	if got := q.stats.transformErrors.Load(); got != 1 {
+		t.Errorf("transform errors: expected=1 got=%d", got)
+	}
+}
+
+// go test -count 1 -run '^TestReaderRetryLater$' ./...
+func TestReaderRetryLater(t *testing.T) {
+	later := message{
+		sqsMessage: &sqstypes.Message{MessageId: aws.String("later"), Body: aws.String("envelope")},
+		receivedAt: time.Now(),
+		retry:      true,
+	}
+
+	visibility := &visibilityMock{}
+	q := &queue{
+		queueCfg: queueDefaults(queueConfig{
+			PublishFailureAction: failureActionDelete,
+			NackPolicy:           nackPolicyImmediate,
+		}),
+		deleteCh:   make(chan message, 10),
+		receive:    &receiverListMock{msg: []message{later}},
+		visibility: visibility,
+		logger:     slog.Default(),
+	}
+	initStats(&q.stats)
+	newTestDestination(q, &publisherMock{})
+	q.readers.Add(1)
+
+	app := &application{}
+	app.startReader(q, false)
+
+	if len(q.deleteCh) != 0 {
+		t.Errorf("transient failure must not get the publish failure action")
+	}
+	if len(visibility.changes) != 1 || visibility.changes[0].msg.sqsMessage != later.sqsMessage {
+		t.Errorf("expected message released, got %v", visibility.changes)
+	}
+	if got := q.stats.transformErrors.Load(); got != 0 {
+		t.Errorf("transform errors: expected=0 got=%d", got)
+	}
+}
+
+// go test -count 1 -run '^TestUnwrapEnvelopeCertUnavailable$' ./...
+func TestUnwrapEnvelopeCertUnavailable(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	saved := envelopeVerifier
+	defer func() { envelopeVerifier = saved }()
+	envelopeVerifier = &snsutils.Verifier{Client: srv.Client(), CertHost: regexp.MustCompile(`^127\.0\.0\.1$`)}
+
+	body := `{"Type":"Notification","MessageId":"sns1","TopicArn":"arn:aws:sns:us-east-1:111111111111:upstream",` +
+		`"Message":"hello","Timestamp":"2024-01-01T00:00:00.000Z","SignatureVersion":"2","Signature":"AQI=",` +
+		`"SigningCertURL":"` + srv.URL + `/cert.pem"}`
+	sqsMsg := &sqstypes.Message{MessageId: aws.String("sqs1"), Body: aws.String(body)}
+
+	_, err := unwrapEnvelope(sqsMsg, true)
+	if !errors.Is(err, errRetryLater) || errors.Is(err, errTransform) {
+		t.Errorf("expected retry later, got %v", err)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	snstypes "github.com/aws/aws-sdk-go-v2/service/sns/types"
+)
+
+// Attribute types for extract_attributes.
+const (
+	extractTypeString      = "String"
+	extractTypeNumber      = "Number"
+	extractTypeStringArray = "String.Array"
+)
+
+// Rules for fields missing from the body.
+const (
+	extractMissingSkip    = "skip"    // do not set the attribute
+	extractMissingDefault = "default" // set the attribute to the default value
+	extractMissingFail    = "fail"    // the message is a transform error
+)
+
+// extractAttribute promotes a JSON body field to an SNS message attribute,
+// so that subscription filter policies can match it.
+//
+// A field that does not hold a value of the attribute type is handled as
+// missing.
+type extractAttribute struct {
+	JSONPath string `yaml:"json_path"` // dotted path into JSON body
+	Name     string `yaml:"name"`      // attribute name
+	Type     string `yaml:"type"`      // String, Number, String.Array (default String)
+	Missing  string `yaml:"missing"`   // skip, default, fail (default skip)
+	Default  string `yaml:"default"`   // value for missing: default
+}
+
+func validateExtractAttributes(rules []extractAttribute) error {
+	names := map[string]bool{}
+	for i, r := range rules {
+		if r.JSONPath == "" || r.Name == "" {
+			return fmt.Errorf("extract_attributes[%d]: requires json_path and name", i)
+		}
+		if names[r.Name] {
+			return fmt.Errorf("extract_attributes[%d]: duplicate name: %s", i, r.Name)
+		}
+		names[r.Name] = true
+		switch r.Type {
+		case "", extractTypeString, extractTypeNumber, extractTypeStringArray:
+		default:
+			return fmt.Errorf("extract_attributes %s: invalid type=%q", r.Name, r.Type)
+		}
+		switch r.Missing {
+		case "", extractMissingSkip, extractMissingFail:
+		case extractMissingDefault:
+			if _, ok := r.convert(r.Default); !ok {
+				return fmt.Errorf("extract_attributes %s: default %q is not a %s",
+					r.Name, r.Default, r.attributeType())
+			}
+		default:
+			return fmt.Errorf("extract_attributes %s: invalid missing=%q", r.Name, r.Missing)
+		}
+	}
+	return nil
+}
+
+func (r extractAttribute) attributeType() string {
+	if r.Type == "" {
+		return extractTypeString
+	}
+	return r.Type
+}
+
+// value converts a decoded JSON value to the attribute value.
+func (r extractAttribute) value(v any) (string, bool) {
+	switch r.attributeType() {
+	case extractTypeNumber:
+		if n, isNumber := v.(json.Number); isNumber {
+			return n.String(), true
+		}
+		if s, isString := v.(string); isString {
+			return r.convert(s)
+		}
+		return "", false
+	case extractTypeStringArray:
+		if _, isArray := v.([]any); !isArray {
+			return "", false
+		}
+		return jsonValueString(v)
+	}
+	switch v.(type) {
+	case map[string]any, []any:
+		return "", false
+	}
+	return jsonValueString(v)
+}
+
+// convert checks a string holds a value of the attribute type.
+func (r extractAttribute) convert(s string) (string, bool) {
+	switch r.attributeType() {
+	case extractTypeNumber:
+		if _, err := strconv.ParseFloat(s, 64); err != nil {
+			return "", false
+		}
+	case extractTypeStringArray:
+		var a []any
+		if err := json.Unmarshal([]byte(s), &a); err != nil {
+			return "", false
+		}
+	}
+	return s, true
+}
+
+var errExtractMissing = errors.New("extract_attributes: missing field")
+
+// extractAttributes sets the attributes extracted from the JSON body into
+// attr, replacing attributes with the same name.
+func extractAttributes(rules []extractAttribute, body string,
+	attr map[string]snstypes.MessageAttributeValue) error {
+
+	doc, errDecode := jsonDecode(body)
+	if errDecode != nil {
+		doc = nil // every field is missing
+	}
+
+	for _, r := range rules {
+		value, found := "", false
+		if v, exists := jsonPathFind(doc, r.JSONPath); exists && v != nil {
+			value, found = r.value(v)
+		}
+		if !found {
+			switch r.Missing {
+			case extractMissingDefault:
+				value = r.Default
+			case extractMissingFail:
+				return fmt.Errorf("%w: %s: json_path=%s", errExtractMissing, r.Name, r.JSONPath)
+			default:
+				continue
+			}
+		}
+		attr[r.Name] = snstypes.MessageAttributeValue{
+			DataType:    aws.String(r.attributeType()),
+			StringValue: aws.String(value),
+		}
+	}
+
+	return nil
+}
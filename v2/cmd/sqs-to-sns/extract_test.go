@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/udhos/sqs-to-sns/v2/snsutils"
+)
+
+// go test -count 1 -run '^TestNewMessageExtractAttributes$' ./...
+func TestNewMessageExtractAttributes(t *testing.T) {
+	sqsMsg := &sqstypes.Message{
+		MessageId: aws.String("id1"),
+		Body:      aws.String(`{"type":"order.created","order":{"total":150.5,"tags":["a","b"],"qty":"3"}}`),
+	}
+
+	opt := messageOptions{
+		extractAttributes: []extractAttribute{
+			{JSONPath: "type", Name: "event_type"},
+			{JSONPath: "order.total", Name: "total", Type: extractTypeNumber},
+			{JSONPath: "order.qty", Name: "qty", Type: extractTypeNumber},
+			{JSONPath: "order.tags", Name: "tags", Type: extractTypeStringArray},
+			{JSONPath: "order.region", Name: "region", Missing: extractMissingDefault, Default: "none"},
+			{JSONPath: "order.coupon", Name: "coupon"},
+			{JSONPath: "order", Name: "order"}, // object is not a String
+		},
+	}
+	if err := validateExtractAttributes(opt.extractAttributes); err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+
+	m, err := newMessage(sqsMsg, time.Now(), opt, 0)
+	if err != nil {
+		t.Fatalf("new message: %v", err)
+	}
+
+	attr := m.snsBatchEntry.MessageAttributes
+
+	expected := map[string][2]string{
+		"event_type": {"String", "order.created"},
+		"total":      {"Number", "150.5"},
+		"qty":        {"Number", "3"},
+		"tags":       {"String.Array", `["a","b"]`},
+		"region":     {"String", "none"},
+	}
+	if len(attr) != len(expected) {
+		t.Errorf("expected %d attributes, got %v", len(expected), attr)
+	}
+	for k, v := range expected {
+		if got := attr[k]; aws.ToString(got.DataType) != v[0] || aws.ToString(got.StringValue) != v[1] {
+			t.Errorf("attribute %s: expected=%v got=%s/%s", k, v,
+				aws.ToString(got.DataType), aws.ToString(got.StringValue))
+		}
+	}
+
+	// sizing runs on the extracted attributes
+	const debug = false
+	_, _, total, _ := snsutils.GetSNSPayloadSize(*m.snsBatchEntry, debug)
+	if m.snsPayloadSize != total {
+		t.Errorf("expected size=%d got=%d", total, m.snsPayloadSize)
+	}
+
+	// missing field with fail is a transform error
+	opt.extractAttributes = []extractAttribute{{JSONPath: "order.coupon", Name: "coupon", Missing: extractMissingFail}}
+	if _, err := newMessage(sqsMsg, time.Now(), opt, 0); !errors.Is(err, errTransform) {
+		t.Errorf("expected transform error, got %v", err)
+	}
+}
+
+// go test -count 1 -run '^TestValidateExtractAttributes$' ./...
+func TestValidateExtractAttributes(t *testing.T) {
+	table := []struct {
+		name  string
+		rules []extractAttribute
+		valid bool
+	}{
+		{"empty", nil, true},
+		{"no name", []extractAttribute{{JSONPath: "a"}}, false},
+		{"duplicate", []extractAttribute{{JSONPath: "a", Name: "a"}, {JSONPath: "b", Name: "a"}}, false},
+		{"bad type", []extractAttribute{{JSONPath: "a", Name: "a", Type: "Binary"}}, false},
+		{"bad missing", []extractAttribute{{JSONPath: "a", Name: "a", Missing: "ignore"}}, false},
+		{"bad number default", []extractAttribute{{JSONPath: "a", Name: "a", Type: extractTypeNumber,
+			Missing: extractMissingDefault, Default: "x"}}, false},
+		{"array default", []extractAttribute{{JSONPath: "a", Name: "a", Type: extractTypeStringArray,
+			Missing: extractMissingDefault, Default: "[]"}}, true},
+	}
+	for _, data := range table {
+		if err := validateExtractAttributes(data.rules); (err == nil) != data.valid {
+			t.Errorf("%s: expected valid=%t, got error: %v", data.name, data.valid, err)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// publishFailure describes a batch entry rejected by a partially
+// failed PublishBatch.
+type publishFailure struct {
+	msg         message
+	code        string
+	explanation string
+	senderFault bool
+}
+
+// retryablePublishCodes are error codes worth retrying even if
+// SNS blames the sender.
+var retryablePublishCodes = map[string]bool{
+	"Throttled":           true,
+	"ThrottlingException": true,
+	"KMSThrottling":       true,
+	"InternalError":       true,
+	"InternalFailure":     true,
+	"ServiceUnavailable":  true,
+	"RequestThrottled":    true, // SQS destination
+	"KmsThrottled":        true, // SQS destination
+	"InternalException":   true, // EventBridge destination
+}
+
+// retryable reports whether the failure is transient. Server side
+// failures and throttling are retryable. Sender faults, like invalid
+// parameters, would fail again.
+func (f publishFailure) retryable() bool {
+	return retryablePublishCodes[f.code] || !f.senderFault
+}
+
+// Actions applied to messages that failed to publish and will not be
+// retried in-process.
+const (
+	// failureActionRelease hands the message back to SQS, according
+	// to the nack policy, for later redelivery.
+	failureActionRelease = "release"
+
+	// failureActionDelete deletes the message from SQS, dropping it.
+	failureActionDelete = "delete"
+
+	// failureActionQuarantine forwards the message to the quarantine
+	// target, then deletes it from SQS.
+	failureActionQuarantine = "quarantine"
+)
+
+// handlePublishFailures retries retryable failures by putting them back
+// into the publish pool, up to MaxPublishAttempts. Other failures are
+// handed to the queue failure action.
+func (app *application) handlePublishFailures(q *queue, d *destination, failures []publishFailure) {
+	const me = "handlePublishFailures"
+
+	var failed []message
+
+	for _, f := range failures {
+		q.stats.publishFailures.add(f.code, 1)
+
+		m := f.msg
+		m.attempts++
+
+		if f.retryable() && m.attempts < q.queueCfg.MaxPublishAttempts {
+			q.stats.retriedMessages.Add(1)
+			d.logger.Debug(me,
+				"message_id", aws.ToString(m.sqsMessage.MessageId),
+				"error_code", f.code,
+				"attempts", m.attempts)
+			d.publishPool.add(m)
+			continue
+		}
+
+		d.logger.Error(me,
+			"message_id", aws.ToString(m.sqsMessage.MessageId),
+			"error_code", f.code,
+			"explanation", f.explanation,
+			"sender_fault", f.senderFault,
+			"retryable", f.retryable(),
+			"attempts", m.attempts,
+			"failure_action", q.queueCfg.PublishFailureAction)
+
+		failed = append(failed, m)
+	}
+
+	if len(failed) > 0 {
+		app.undelivered(q, d, failed, false)
+	}
+}
+
+// publishFailed applies the queue failure action to messages we gave up publishing.
+func (app *application) publishFailed(q *queue, msg []message) {
+	q.stats.failedMessages.Add(uint64(len(msg)))
+	app.failureAction(q, msg, quarantineReasonPublishFailure)
+}
+
+// transformFailed applies the queue failure action to messages
+// that could not be converted to SNS entries.
+func (app *application) transformFailed(q *queue, msg []message) {
+	q.stats.transformErrors.Add(uint64(len(msg)))
+	app.failureAction(q, msg, quarantineReasonTransformError)
+}
+
+func (app *application) failureAction(q *queue, msg []message, reason string) {
+	switch q.queueCfg.PublishFailureAction {
+	case failureActionDelete:
+		for _, m := range msg {
+			q.deleteCh <- m
+		}
+	case failureActionQuarantine:
+		app.quarantine(q, msg, reason)
+	default:
+		app.release(q, msg)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"testing"
+)
+
+// go test -count 1 -run '^TestPublishFailureRetryable$' ./...
+func TestPublishFailureRetryable(t *testing.T) {
+	table := []struct {
+		code        string
+		senderFault bool
+		expected    bool
+	}{
+		{"InternalError", false, true},
+		{"Throttled", true, true},
+		{"KMSThrottling", true, true},
+		{"InvalidParameter", true, false},
+		{"KMSDisabled", true, false},
+		{"Unknown", false, true},
+	}
+
+	for _, data := range table {
+		f := publishFailure{code: data.code, senderFault: data.senderFault}
+		if got := f.retryable(); got != data.expected {
+			t.Errorf("code=%s senderFault=%t: expected=%t got=%t",
+				data.code, data.senderFault, data.expected, got)
+		}
+	}
+}
+
+// go test -count 1 -run '^TestBatchPublishRetry$' ./...
+func TestBatchPublishRetry(t *testing.T) {
+	vis := &visibilityMock{}
+	q := newNackTestQueue(nackPolicyImmediate,
+		&publisherPartialMock{code: "Throttled", senderFault: true}, vis)
+	d := q.destinations[0]
+
+	m1, _ := createTestMessage(10)
+	m2, _ := createTestMessage(10)
+
+	app := &application{}
+	app.batchPublish(q, q.destinations[0], []message{m1, m2})
+
+	// m2 failed with retryable error, it must be back in the pool
+	if got := q.stats.retriedMessages.Load(); got != 1 {
+		t.Fatalf("retried: expected=1 got=%d", got)
+	}
+	retry := d.publishPool.getAvailable()
+	if len(retry) != 1 || retry[0].sqsMessage != m2.sqsMessage {
+		t.Fatalf("expected m2 back in the pool, got %d messages", len(retry))
+	}
+	if retry[0].attempts != 1 {
+		t.Errorf("expected attempts=1, got %d", retry[0].attempts)
+	}
+
+	// republish m2 alone behind a dummy message, until attempts are exhausted
+	for range q.queueCfg.MaxPublishAttempts - 1 {
+		dummy, _ := createTestMessage(10)
+		app.batchPublish(q, q.destinations[0], []message{dummy, retry[0]})
+		if r := d.publishPool.getAvailable(); len(r) == 1 {
+			retry = r
+		}
+	}
+
+	if got := q.stats.failedMessages.Load(); got != 1 {
+		t.Errorf("failed: expected=1 got=%d", got)
+	}
+	if got := q.stats.publishFailures.get("Throttled"); got != uint64(q.queueCfg.MaxPublishAttempts) {
+		t.Errorf("publish failures: expected=%d got=%d", q.queueCfg.MaxPublishAttempts, got)
+	}
+	if got := q.stats.nackedMessages.Load(); got != 1 {
+		t.Errorf("nacked: expected=1 got=%d", got)
+	}
+}
+
+// go test -count 1 -run '^TestPublishFailedDelete$' ./...
+func TestPublishFailedDelete(t *testing.T) {
+	vis := &visibilityMock{}
+	q := newNackTestQueue(nackPolicyImmediate,
+		&publisherPartialMock{code: "InvalidParameter", senderFault: true}, vis)
+	q.queueCfg.PublishFailureAction = failureActionDelete
+
+	m1, _ := createTestMessage(10)
+	m2, _ := createTestMessage(10)
+
+	app := &application{}
+	app.batchPublish(q, q.destinations[0], []message{m1, m2})
+
+	// m1 published, m2 failed for good: both go to janitor
+	if len(q.deleteCh) != 2 {
+		t.Errorf("expected 2 messages forwarded to janitor, got %d", len(q.deleteCh))
+	}
+	if got := q.stats.nackedMessages.Load(); got != 0 {
+		t.Errorf("nacked: expected=0 got=%d", got)
+	}
+	if got := q.stats.publishFailures.get("InvalidParameter"); got != 1 {
+		t.Errorf("publish failures: expected=1 got=%d", got)
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"log/slog"
+	"math/bits"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// maxDestinations is limited by the delivery bitmask.
+const maxDestinations = 64
+
+// destination is a topic a queue fans out to. Every destination has
+// its own publish channel, pool and publisher goroutines, so a slow
+// topic does not hold batches for the others.
+type destination struct {
+	index           int    // bit in message.skip and fanoutTracker
+	target          string // topic ARN, or queue, bus, url or path of other destination types
+	limits          destinationLimits
+	publishCh       chan message
+	publishPool     pool
+	fifo            *poolFIFO // nil if not a FIFO queue, otherwise same as publishPool
+	aggregator      *poolV2   // nil unless aggregate is enabled
+	publish         publisher
+	publishers      atomic.Int64
+	lastPublishUnix atomic.Int64
+	logger          *slog.Logger
+}
+
+func newDestination(index int, target string, queueCfg queueConfig,
+	perMessagePadding int, pub publisher, logger *slog.Logger) *destination {
+
+	d := &destination{
+		index:     index,
+		target:    target,
+		limits:    queueCfg.Destination.limits(),
+		publishCh: make(chan message, queueCfg.BufferSizePublish),
+		publish:   pub,
+		logger:    logger.With("destination", target),
+	}
+
+	if isFifo(queueCfg.QueueURL) {
+		// Keep per-group order, still byte-size-limited
+		d.fifo = newPoolFIFO(d.limits.maxBytes, perMessagePadding+d.limits.entryOverhead)
+		d.publishPool = d.fifo
+	} else {
+		// Byte-size-limited
+		d.publishPool = newPoolV2Items(d.limits.maxBytes, perMessagePadding+d.limits.entryOverhead, d.limits.maxItems)
+	}
+
+	if queueCfg.Aggregate.enabled() {
+		d.aggregator = newAggregatePool(queueCfg.Aggregate, d.limits, perMessagePadding)
+	}
+
+	return d
+}
+
+func (d *destination) bit() uint64 {
+	return 1 << d.index
+}
+
+// delivery tracks the outcome of one message across its destinations.
+// Every destination reports exactly once, either delivered or undelivered.
+// The message is only deleted when every destination delivered it.
+type delivery struct {
+	pending atomic.Int32
+	release atomic.Bool // some destination hands the message back to SQS
+	giveUp  atomic.Bool // some destination gave up, apply publish_failure_action
+}
+
+// forward hands a message to the destinations that did not publish it yet.
+func (app *application) forward(q *queue, m message) {
+	if m.children != nil {
+		app.forwardSplit(q, m)
+		return
+	}
+
+	targets := q.targets(m)
+
+	if targets == 0 {
+		// Published everywhere before, only the delete is missing.
+		app.settle(q, []message{m})
+		return
+	}
+
+	m.delivery = &delivery{}
+	m.delivery.pending.Store(int32(bits.OnesCount64(targets)))
+
+	for _, d := range q.destinations {
+		if targets&d.bit() != 0 {
+			d.publishCh <- m
+		}
+	}
+}
+
+// targets returns the destinations a message must be published to.
+func (q *queue) targets(m message) uint64 {
+	all := uint64(1)<<len(q.destinations) - 1
+	return all &^ m.skip
+}
+
+// delivered records that destination d published messages.
+func (app *application) delivered(q *queue, d *destination, msg []message) {
+	msg = expandAggregates(msg) // Deliver the source messages
+
+	d.fifo.ack(msg) // Unblock the next message in their groups
+
+	for _, m := range msg {
+		q.tracker.record(aws.ToString(m.sqsMessage.MessageId), d.index)
+	}
+
+	app.settle(q, msg)
+}
+
+// undelivered records that destination d gave up publishing messages.
+// With release, they are handed back to SQS for redelivery, otherwise
+// publish_failure_action is applied.
+func (app *application) undelivered(q *queue, d *destination, msg []message, release bool) {
+	msg = expandAggregates(msg) // Release or give up the source messages
+
+	if release {
+		// Messages queued behind them must not be published before them.
+		if evicted := d.fifo.evict(msg); len(evicted) > 0 {
+			app.undelivered(q, d, evicted, true)
+		}
+	} else {
+		d.fifo.ack(msg) // Dropped or quarantined, unblock their groups
+	}
+
+	for i, m := range msg {
+		m = withDelivery(m)
+		if release {
+			m.delivery.release.Store(true)
+		} else {
+			m.delivery.giveUp.Store(true)
+		}
+		msg[i] = m
+	}
+
+	app.settle(q, msg)
+}
+
+// settle completes the delivery of messages whose last destination reported.
+// Any destination asking for release wins over giving up, since the
+// message is then published again only to the destinations that failed.
+// A split message completes with its last child.
+func (app *application) settle(q *queue, msg []message) {
+	var release, failed []message
+
+	for _, m := range msg {
+		m = withDelivery(m)
+		if m.delivery.pending.Add(-1) > 0 {
+			continue // Other destinations still working
+		}
+		if m.split != nil {
+			if m.delivery.release.Load() {
+				m.split.release.Store(true)
+			}
+			if m.delivery.giveUp.Load() {
+				m.split.giveUp.Store(true)
+			}
+			if m.split.pending.Add(-1) > 0 {
+				continue // Other children still working
+			}
+			m.delivery = m.split
+		}
+		switch {
+		case m.delivery.release.Load():
+			release = append(release, m)
+		case m.delivery.giveUp.Load():
+			failed = append(failed, m)
+		default:
+			q.deleteCh <- m
+		}
+	}
+
+	if len(release) > 0 {
+		app.release(q, release)
+	}
+	if len(failed) > 0 {
+		app.publishFailed(q, failed)
+	}
+}
+
+// withDelivery gives a single-destination delivery to a message
+// that was not forwarded, for instance a message injected by tests.
+func withDelivery(m message) message {
+	if m.delivery == nil {
+		m.delivery = &delivery{}
+		m.delivery.pending.Store(1)
+	}
+	return m
+}
+
+// fanoutTrackerTTL bounds how long we remember partial deliveries of
+// messages that might never be redelivered.
+const fanoutTrackerTTL = 24 * time.Hour
+
+// fanoutTracker remembers, by SQS MessageId, the destinations that
+// already published a message. A redelivered message is only published
+// to the remaining destinations. Entries are dropped when the message
+// is deleted from SQS, or after fanoutTrackerTTL.
+type fanoutTracker struct {
+	entries   map[string]fanoutEntry
+	lastPrune time.Time
+	mu        sync.Mutex
+}
+
+type fanoutEntry struct {
+	done    uint64 // destination bits
+	updated time.Time
+}
+
+func newFanoutTracker() *fanoutTracker {
+	return &fanoutTracker{
+		entries:   map[string]fanoutEntry{},
+		lastPrune: time.Now(),
+	}
+}
+
+// done returns the destinations that already published a message. Nil-safe.
+func (t *fanoutTracker) done(messageID string) uint64 {
+	if t == nil {
+		return 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.entries[messageID].done
+}
+
+// record marks a message as published to a destination. Nil-safe.
+func (t *fanoutTracker) record(messageID string, index int) {
+	if t == nil {
+		return
+	}
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	e := t.entries[messageID]
+	e.done |= 1 << index
+	e.updated = now
+	t.entries[messageID] = e
+
+	if now.Sub(t.lastPrune) > fanoutTrackerTTL/24 {
+		t.lastPrune = now
+		for id, entry := range t.entries {
+			if now.Sub(entry.updated) > fanoutTrackerTTL {
+				delete(t.entries, id)
+			}
+		}
+	}
+}
+
+// forget drops deleted messages. Nil-safe.
+func (t *fanoutTracker) forget(msg []message) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, m := range msg {
+		delete(t.entries, aws.ToString(m.sqsMessage.MessageId))
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+func newFanoutTestQueue(pub ...publisher) *queue {
+	q := &queue{
+		queueCfg: queueDefaults(queueConfig{
+			NackPolicy: nackPolicyImmediate,
+		}),
+		deleteCh:   make(chan message, 10),
+		delete:     &deleterMock{},
+		visibility: &visibilityMock{},
+		tracker:    newFanoutTracker(),
+		logger:     slog.Default(),
+	}
+	initStats(&q.stats)
+	for i, p := range pub {
+		q.destinations = append(q.destinations,
+			newDestination(i, "topic", q.queueCfg, 0, p, q.logger))
+	}
+	return q
+}
+
+// go test -count 1 -run '^TestFanoutAllOrNothing$' ./...
+func TestFanoutAllOrNothing(t *testing.T) {
+	ok := &publisherMock{}
+	fail := &publisherErrMock{err: errors.New("boom")}
+
+	q := newFanoutTestQueue(ok, fail)
+	app := &application{}
+
+	m, _ := createTestMessage(10)
+	app.forward(q, m)
+
+	for _, d := range q.destinations {
+		app.batchPublish(q, d, []message{<-d.publishCh})
+	}
+
+	if len(q.deleteCh) != 0 {
+		t.Fatalf("partially published message must not be deleted, got %d", len(q.deleteCh))
+	}
+	if got := q.stats.nackedMessages.Load(); got != 1 {
+		t.Errorf("nacked: expected=1 got=%d", got)
+	}
+
+	// redelivery: only the failed destination publishes again
+	id := aws.ToString(m.sqsMessage.MessageId)
+	m.skip = q.tracker.done(id)
+	if m.skip != 1 {
+		t.Fatalf("expected first destination recorded, got skip=%b", m.skip)
+	}
+
+	q.destinations[1].publish = &publisherMock{}
+	app.forward(q, m)
+
+	if len(q.destinations[0].publishCh) != 0 {
+		t.Fatalf("redelivered message must not be published twice to the first destination")
+	}
+	app.batchPublish(q, q.destinations[1], []message{<-q.destinations[1].publishCh})
+
+	if ok.getMessages() != 1 {
+		t.Errorf("first destination: expected 1 message, got %d", ok.getMessages())
+	}
+	if len(q.deleteCh) != 1 {
+		t.Fatalf("expected message deleted after every destination published, got %d", len(q.deleteCh))
+	}
+
+	app.batchDelete(q, []message{<-q.deleteCh})
+	if got := q.tracker.done(id); got != 0 {
+		t.Errorf("deleted message must be forgotten, got %b", got)
+	}
+}
+
+// go test -count 1 -run '^TestFanoutGiveUp$' ./...
+func TestFanoutGiveUp(t *testing.T) {
+	q := newFanoutTestQueue(&publisherMock{},
+		&publisherPartialMock{code: "InvalidParameter", senderFault: true})
+	q.queueCfg.PublishFailureAction = failureActionDelete
+
+	app := &application{}
+
+	m1, _ := createTestMessage(10)
+	m2, _ := createTestMessage(10)
+	app.forward(q, m1)
+	app.forward(q, m2)
+
+	for _, d := range q.destinations {
+		app.batchPublish(q, d, []message{<-d.publishCh, <-d.publishCh})
+	}
+
+	// m1 published everywhere, m2 dropped by the second destination
+	if len(q.deleteCh) != 2 {
+		t.Errorf("expected 2 messages forwarded to janitor, got %d", len(q.deleteCh))
+	}
+	if got := q.stats.failedMessages.Load(); got != 1 {
+		t.Errorf("failed: expected=1 got=%d", got)
+	}
+}
+
+// go test -count 1 -run '^TestFanoutTracker$' ./...
+func TestFanoutTracker(t *testing.T) {
+	var none *fanoutTracker
+	none.record("m1", 0)
+	if none.done("m1") != 0 {
+		t.Errorf("nil tracker must record nothing")
+	}
+
+	tr := newFanoutTracker()
+	tr.record("m1", 0)
+	tr.record("m1", 2)
+	if got := tr.done("m1"); got != 0b101 {
+		t.Errorf("expected 101, got %b", got)
+	}
+
+	// stale entries are pruned
+	tr.entries["old"] = fanoutEntry{done: 1, updated: time.Now().Add(-2 * fanoutTrackerTTL)}
+	tr.lastPrune = time.Now().Add(-fanoutTrackerTTL)
+	tr.record("m2", 1)
+	if got := tr.done("old"); got != 0 {
+		t.Errorf("expected stale entry pruned, got %b", got)
+	}
+	if got := tr.done("m1"); got != 0b101 {
+		t.Errorf("expected fresh entry kept, got %b", got)
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"sync"
+
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// isFifo reports whether a queue URL or topic ARN names a FIFO queue or topic.
+func isFifo(name string) bool {
+	return strings.HasSuffix(name, ".fifo")
+}
+
+// poolFIFO is the publish pool for FIFO queues.
+//
+// Sibling publishers work in parallel and poolV2 skips over messages,
+// hence both could reorder messages within a message group. poolFIFO
+// keeps the receive order of every group and only offers the oldest
+// message of a group. That message remains the head of its group until
+// it is acknowledged, so the next message in a group waits until the
+// previous one is acknowledged by SNS. Heads of different groups are
+// batched together, like poolV2 does.
+//
+// The reader tracks messages in receive order before handing them to the
+// publishers, since publishers might add them to the pool out of order.
+type poolFIFO struct {
+	snsPublishPayloadLimit int
+	perMessagePadding      int
+	groups                 map[string]*fifoGroup
+	evicted                []message // released groups, waiting for takeEvicted
+	mu                     sync.Mutex
+}
+
+type fifoGroup struct {
+	order   []*sqstypes.Message            // receive order, head first
+	pending map[*sqstypes.Message]message  // added, not extracted yet
+	evicted map[*sqstypes.Message]struct{} // released before being added
+}
+
+func newPoolFIFO(snsPublishPayloadLimit, perMessagePadding int) *poolFIFO {
+	return &poolFIFO{
+		snsPublishPayloadLimit: snsPublishPayloadLimit,
+		perMessagePadding:      perMessagePadding,
+		groups:                 map[string]*fifoGroup{},
+	}
+}
+
+func messageGroupID(m message) string {
+	return m.sqsMessage.Attributes["MessageGroupId"]
+}
+
+// groupUnsafe returns the group of m, creating it if needed.
+func (p *poolFIFO) groupUnsafe(m message) *fifoGroup {
+	id := messageGroupID(m)
+	g, found := p.groups[id]
+	if !found {
+		g = &fifoGroup{
+			pending: map[*sqstypes.Message]message{},
+			evicted: map[*sqstypes.Message]struct{}{},
+		}
+		p.groups[id] = g
+	}
+	return g
+}
+
+// removeIfEmptyUnsafe forgets the group of m when it holds nothing.
+func (p *poolFIFO) removeIfEmptyUnsafe(m message, g *fifoGroup) {
+	if len(g.order) == 0 && len(g.pending) == 0 && len(g.evicted) == 0 {
+		delete(p.groups, messageGroupID(m))
+	}
+}
+
+// track records the receive order of messages. Nil-safe.
+func (p *poolFIFO) track(msg []message) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, m := range msg {
+		g := p.groupUnsafe(m)
+		g.order = append(g.order, m.sqsMessage)
+	}
+}
+
+// add implements pool. A message that was not tracked is appended
+// to the order of its group.
+func (p *poolFIFO) add(m message) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	g := p.groupUnsafe(m)
+
+	if _, found := g.evicted[m.sqsMessage]; found {
+		delete(g.evicted, m.sqsMessage)
+		p.evicted = append(p.evicted, m)
+		p.removeIfEmptyUnsafe(m, g)
+		return
+	}
+
+	if !slices.Contains(g.order, m.sqsMessage) {
+		g.order = append(g.order, m.sqsMessage)
+	}
+
+	g.pending[m.sqsMessage] = m
+}
+
+// ack removes messages acknowledged by SNS (or otherwise done with),
+// unblocking the next message in their groups. Nil-safe.
+func (p *poolFIFO) ack(msg []message) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, m := range msg {
+		g, found := p.groups[messageGroupID(m)]
+		if !found {
+			continue
+		}
+		if i := slices.Index(g.order, m.sqsMessage); i >= 0 {
+			g.order = slices.Delete(g.order, i, i+1)
+		}
+		delete(g.evicted, m.sqsMessage) // done with before being added
+		p.removeIfEmptyUnsafe(m, g)
+	}
+}
+
+// evict is used when messages are handed back to SQS. The messages
+// queued behind them in their groups must not be published before them,
+// so they are evicted as well. Evicted messages already in the pool are
+// returned, the others are held for takeEvicted once added. Nil-safe.
+func (p *poolFIFO) evict(msg []message) []message {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var evicted []message
+
+	for _, m := range msg {
+		g, found := p.groups[messageGroupID(m)]
+		if !found {
+			continue
+		}
+		i := slices.Index(g.order, m.sqsMessage)
+		if i < 0 {
+			continue
+		}
+		for _, sqsMsg := range g.order[i+1:] {
+			if pending, isPending := g.pending[sqsMsg]; isPending {
+				delete(g.pending, sqsMsg)
+				evicted = append(evicted, pending)
+				continue
+			}
+			g.evicted[sqsMsg] = struct{}{}
+		}
+		g.order = g.order[:i]
+		p.removeIfEmptyUnsafe(m, g)
+	}
+
+	return evicted
+}
+
+// takeEvicted returns evicted messages that were added after their
+// group was evicted. Nil-safe.
+func (p *poolFIFO) takeEvicted() []message {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	evicted := p.evicted
+	p.evicted = nil
+	return evicted
+}
+
+// headsUnsafe returns the pending group heads, oldest first.
+func (p *poolFIFO) headsUnsafe() []message {
+	var heads []message
+	for _, g := range p.groups {
+		if len(g.order) == 0 {
+			continue
+		}
+		if m, found := g.pending[g.order[0]]; found {
+			heads = append(heads, m)
+		}
+	}
+	slices.SortStableFunc(heads, func(a, b message) int {
+		return a.receivedAt.Compare(b.receivedAt)
+	})
+	return heads
+}
+
+// findUnsafe picks heads that fit one batch, like poolV2.findIndices.
+func (p *poolFIFO) findUnsafe(heads []message) ([]message, int) {
+	var payloadSum int
+	batch := make([]message, 0, maxBatchItems)
+
+	for _, m := range heads {
+		if len(batch) >= maxBatchItems {
+			break
+		}
+		messageSnsPayloadSize := m.snsPayloadSize + p.perMessagePadding
+		if payloadSum+messageSnsPayloadSize <= p.snsPublishPayloadLimit {
+			payloadSum += messageSnsPayloadSize
+			batch = append(batch, m)
+		}
+	}
+
+	return batch, payloadSum
+}
+
+func (p *poolFIFO) extractUnsafe(batch []message) []message {
+	for _, m := range batch {
+		delete(p.groups[messageGroupID(m)].pending, m.sqsMessage)
+	}
+	return batch
+}
+
+// getFullBatch implements pool, following poolV2 criteria applied
+// to the group heads.
+func (p *poolFIFO) getFullBatch() ([]message, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	heads := p.headsUnsafe()
+
+	batch, payloadSum := p.findUnsafe(heads)
+
+	switch {
+	case len(batch) == 0:
+		return nil, false
+	case len(batch) >= maxBatchItems, // Full by Count
+		payloadSum == p.snsPublishPayloadLimit, // Full by Exact Weight
+		len(batch) < len(heads):                // Full by Density
+		return p.extractUnsafe(batch), true
+	}
+
+	return nil, false
+}
+
+// getAvailable implements pool.
+func (p *poolFIFO) getAvailable() []message {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	batch, _ := p.findUnsafe(p.headsUnsafe())
+	if len(batch) == 0 {
+		return nil
+	}
+
+	return p.extractUnsafe(batch)
+}
+
+// trackGroups records the receive order of messages in the FIFO pool
+// of every destination they are forwarded to.
+func (q *queue) trackGroups(msg []message) {
+	for _, d := range q.destinations {
+		if d.fifo == nil {
+			continue
+		}
+		var tracked []message
+		for _, m := range msg {
+			if q.targets(m)&d.bit() != 0 {
+				tracked = append(tracked, m)
+			}
+		}
+		d.fifo.track(tracked)
+	}
+}
+
+// ackGroups unblocks the groups of messages we are done with
+// before publishing, in every destination.
+func (q *queue) ackGroups(msg []message) {
+	for _, d := range q.destinations {
+		d.fifo.ack(msg)
+	}
+}
+
+// releaseGroups hands back to SQS the messages queued behind msg
+// in their FIFO groups.
+func (app *application) releaseGroups(q *queue, msg []message) {
+	for _, d := range q.destinations {
+		if evicted := d.fifo.evict(msg); len(evicted) > 0 {
+			app.undelivered(q, d, evicted, true)
+		}
+	}
+}
+
+// leave stops tracking messages, letting SQS redeliver them.
+func (app *application) leave(q *queue, msg []message) {
+	q.heartbeat.untrack(msg)
+	app.releaseGroups(q, msg)
+}
+
+// publish publishes a batch. For FIFO queues, it then keeps publishing
+// the messages unblocked by the acknowledgement of their predecessors,
+// so they do not wait for the next add or flush.
+func (app *application) publish(q *queue, d *destination, msg []message) {
+	for len(msg) > 0 {
+		app.batchPublish(q, d, msg)
+		if d.fifo == nil {
+			return
+		}
+		msg = d.fifo.getAvailable()
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/udhos/boilerplate/envconfig"
+)
+
+func newFifoTestMessage(group, id string, receivedAt time.Time) message {
+	return message{
+		sqsMessage: &sqstypes.Message{
+			MessageId:  aws.String(id),
+			Body:       aws.String(id),
+			Attributes: map[string]string{"MessageGroupId": group},
+		},
+		receivedAt:     receivedAt,
+		snsPayloadSize: len(id),
+	}
+}
+
+func messageIDs(msg []message) []string {
+	var ids []string
+	for _, m := range msg {
+		ids = append(ids, aws.ToString(m.sqsMessage.MessageId))
+	}
+	return ids
+}
+
+// go test -count 1 -run '^TestPoolFIFOOrder$' ./...
+func TestPoolFIFOOrder(t *testing.T) {
+	now := time.Now()
+	a1 := newFifoTestMessage("a", "a1", now)
+	a2 := newFifoTestMessage("a", "a2", now)
+	b1 := newFifoTestMessage("b", "b1", now.Add(time.Millisecond))
+
+	p := newPoolFIFO(maxSnsPublishPayload, 0)
+
+	p.track([]message{a1, a2, b1})
+
+	// publishers add out of order
+	p.add(a2)
+	if got := p.getAvailable(); len(got) != 0 {
+		t.Fatalf("a2 must wait for a1, got %v", messageIDs(got))
+	}
+	p.add(b1)
+	p.add(a1)
+
+	got := p.getAvailable()
+	if ids := messageIDs(got); !slices.Equal(ids, []string{"a1", "b1"}) {
+		t.Fatalf("expected heads [a1 b1], got %v", ids)
+	}
+
+	if got := p.getAvailable(); len(got) != 0 {
+		t.Fatalf("a2 must wait for a1 acknowledgement, got %v", messageIDs(got))
+	}
+
+	p.ack([]message{a1})
+
+	if ids := messageIDs(p.getAvailable()); !slices.Equal(ids, []string{"a2"}) {
+		t.Fatalf("expected [a2] after ack, got %v", ids)
+	}
+
+	p.ack([]message{a2, b1})
+
+	if len(p.groups) != 0 {
+		t.Errorf("expected no groups left, got %d", len(p.groups))
+	}
+}
+
+// go test -count 1 -run '^TestPoolFIFOEvict$' ./...
+func TestPoolFIFOEvict(t *testing.T) {
+	now := time.Now()
+	a1 := newFifoTestMessage("a", "a1", now)
+	a2 := newFifoTestMessage("a", "a2", now)
+	a3 := newFifoTestMessage("a", "a3", now)
+
+	p := newPoolFIFO(maxSnsPublishPayload, 0)
+
+	p.track([]message{a1, a2, a3})
+	p.add(a1)
+	p.add(a2)
+
+	if ids := messageIDs(p.getAvailable()); !slices.Equal(ids, []string{"a1"}) {
+		t.Fatalf("expected [a1], got %v", ids)
+	}
+
+	// a1 failed and is handed back to SQS: a2 and a3 must follow it
+	evicted := p.evict([]message{a1})
+	if ids := messageIDs(evicted); !slices.Equal(ids, []string{"a2"}) {
+		t.Fatalf("expected pending [a2] evicted, got %v", ids)
+	}
+
+	// a3 arrives late
+	p.add(a3)
+	if got := p.getAvailable(); len(got) != 0 {
+		t.Fatalf("evicted message must not be published, got %v", messageIDs(got))
+	}
+	if ids := messageIDs(p.takeEvicted()); !slices.Equal(ids, []string{"a3"}) {
+		t.Fatalf("expected late [a3] evicted, got %v", ids)
+	}
+
+	if len(p.groups) != 0 {
+		t.Errorf("expected no groups left, got %d", len(p.groups))
+	}
+}
+
+// go test -count 1 -run '^TestAppFIFO$' ./...
+func TestAppFIFO(t *testing.T) {
+
+	queues := applyQueuesDefaults([]queueConfig{
+		{
+			QueueURL: "queue1.fifo",
+			TopicArn: "topic1.fifo",
+		},
+	})
+
+	t.Setenv("QUEUES", "/dev/null")
+
+	cfg := newConfig(envconfig.NewSimple("test"))
+	cfg.queues = queues
+	cfg.flushIntervalPublish = 10 * time.Millisecond
+
+	const groups = 5
+	const perGroup = 8
+
+	var msg []message
+	now := time.Now()
+	for i := range perGroup {
+		for g := range groups {
+			group := fmt.Sprintf("g%d", g)
+			msg = append(msg, newFifoTestMessage(group,
+				fmt.Sprintf("%s-%d", group, i), now))
+		}
+	}
+
+	pub := &publisherOrderMock{t: t}
+
+	app := newApp(cfg,
+		func(_ queueConfig) queueClients {
+			return queueClients{
+				receive:      &receiverListMock{msg: msg},
+				newPublisher: func(string) publisher { return pub },
+				delete:       &deleterMock{},
+			}
+		},
+	)
+
+	if app.queues[0].destinations[0].fifo == nil {
+		t.Fatalf("expected FIFO mode for .fifo queue")
+	}
+
+	app.run()
+
+	time.Sleep(time.Second)
+
+	published := pub.getPublished()
+
+	if len(published) != groups*perGroup {
+		t.Fatalf("expected %d published, got %d", groups*perGroup, len(published))
+	}
+
+	// per group, messages must be published in receive order
+	for g := range groups {
+		group := fmt.Sprintf("g%d", g)
+		var got []string
+		var expected []string
+		for i := range perGroup {
+			expected = append(expected, fmt.Sprintf("%s-%d", group, i))
+		}
+		for _, m := range published {
+			if messageGroupID(m) == group {
+				got = append(got, aws.ToString(m.sqsMessage.MessageId))
+			}
+		}
+		if !slices.Equal(got, expected) {
+			t.Errorf("group %s: expected order %v, got %v", group, expected, got)
+		}
+	}
+}
+
+// publisherOrderMock records published messages, with random latency.
+type publisherOrderMock struct {
+	t         *testing.T
+	published []message
+	mu        sync.Mutex
+}
+
+func (p *publisherOrderMock) getPublished() []message {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return slices.Clone(p.published)
+}
+
+func (p *publisherOrderMock) publish(_ *queue, msg []message) ([]message, []publishFailure, error) {
+	groups := map[string]bool{}
+	for _, m := range msg {
+		g := messageGroupID(m)
+		if groups[g] {
+			p.t.Errorf("batch holds two messages of group %s", g)
+		}
+		groups[g] = true
+	}
+
+	time.Sleep(time.Duration(rand.IntN(5)) * time.Millisecond)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.published = append(p.published, msg...)
+	return msg, nil, nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash/fnv"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// maxMessageGroupIDLength is the SNS limit for MessageGroupId.
+const maxMessageGroupIDLength = 128
+
+// messageGroupIDFrom derives the MessageGroupId of published entries,
+// for instance when a standard queue forwards to a FIFO topic.
+//
+// The key is taken from one of attribute, json_path or constant. If the
+// key is missing from a message, constant is used, then the MessageId.
+// With hash_buckets, the key (or the MessageId, when no key source is set)
+// is hashed into one of hash_buckets groups named "0".."hash_buckets-1".
+type messageGroupIDFrom struct {
+	Attribute   string `yaml:"attribute"`    // message attribute name
+	JSONPath    string `yaml:"json_path"`    // dotted path into JSON body
+	Constant    string `yaml:"constant"`     // fixed group id, or fallback
+	HashBuckets int    `yaml:"hash_buckets"` // 0 disables hashing
+}
+
+func (g messageGroupIDFrom) enabled() bool {
+	return g.Attribute != "" || g.JSONPath != "" || g.Constant != "" || g.HashBuckets > 0
+}
+
+func (g messageGroupIDFrom) validate() error {
+	if g.Attribute != "" && g.JSONPath != "" {
+		return errors.New("message_group_id_from must set either attribute or json_path, not both")
+	}
+	if g.HashBuckets < 0 {
+		return errors.New("message_group_id_from hash_buckets must not be negative")
+	}
+	if g.Constant != "" && !validGroupID(g.Constant) {
+		return errors.New("message_group_id_from constant must have up to 128 alphanumeric or punctuation characters")
+	}
+	return nil
+}
+
+// validGroupID reports whether id is accepted by SNS as MessageGroupId:
+// up to 128 alphanumeric or punctuation characters, that is, printable
+// ASCII except space.
+func validGroupID(id string) bool {
+	if len(id) > maxMessageGroupIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < '!' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// groupID derives the MessageGroupId for an SQS message.
+func (g messageGroupIDFrom) groupID(sqsMessage *sqstypes.Message) string {
+	key, found := g.key(sqsMessage)
+
+	if g.HashBuckets > 0 {
+		if !found {
+			key = aws.ToString(sqsMessage.MessageId)
+		}
+		h := fnv.New32a()
+		h.Write([]byte(key))
+		return strconv.Itoa(int(h.Sum32() % uint32(g.HashBuckets)))
+	}
+
+	if !found {
+		if g.Constant != "" {
+			return g.Constant
+		}
+		return aws.ToString(sqsMessage.MessageId)
+	}
+
+	if !validGroupID(key) {
+		// Hash keys SNS would reject, keeping messages with the
+		// same key in the same group.
+		sum := sha256.Sum256([]byte(key))
+		return hex.EncodeToString(sum[:])
+	}
+
+	return key
+}
+
+// key returns the grouping key from the configured source.
+func (g messageGroupIDFrom) key(sqsMessage *sqstypes.Message) (string, bool) {
+	switch {
+	case g.Attribute != "":
+		attr, found := sqsMessage.MessageAttributes[g.Attribute]
+		if !found || aws.ToString(attr.StringValue) == "" {
+			return "", false
+		}
+		return aws.ToString(attr.StringValue), true
+	case g.JSONPath != "":
+		value, found := jsonPathLookup(aws.ToString(sqsMessage.Body), g.JSONPath)
+		if value == "" {
+			return "", false
+		}
+		return value, found
+	case g.Constant != "":
+		return g.Constant, true
+	}
+	return "", false
+}
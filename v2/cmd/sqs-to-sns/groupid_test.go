@@ -0,0 +1,166 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/udhos/sqs-to-sns/v2/snsutils"
+)
+
+// go test -count 1 -run '^TestJSONPathLookup$' ./...
+func TestJSONPathLookup(t *testing.T) {
+	const doc = `{"order":{"id":42,"customer":"c1","items":[{"sku":"s1"},{"sku":"s2"}],"paid":true,"tags":["a","<b>"]}}`
+
+	table := []struct {
+		path     string
+		expected string
+		found    bool
+	}{
+		{"order.customer", "c1", true},
+		{"order.id", "42", true},
+		{"order.items.1.sku", "s2", true},
+		{"order.paid", "true", true},
+		{"order.tags", `["a","<b>"]`, true},
+		{"order.missing", "", false},
+		{"order.items.9.sku", "", false},
+		{"order.customer.name", "", false},
+	}
+
+	for _, data := range table {
+		got, found := jsonPathLookup(doc, data.path)
+		if got != data.expected || found != data.found {
+			t.Errorf("path=%s: expected=%q/%t got=%q/%t",
+				data.path, data.expected, data.found, got, found)
+		}
+	}
+
+	if _, found := jsonPathLookup("not json", "a"); found {
+		t.Errorf("expected not found for invalid JSON")
+	}
+}
+
+// go test -count 1 -run '^TestMessageGroupIDFrom$' ./...
+func TestMessageGroupIDFrom(t *testing.T) {
+	sqsMsg := &sqstypes.Message{
+		MessageId: aws.String("id1"),
+		Body:      aws.String(`{"customer":{"id":"c7"}}`),
+		MessageAttributes: map[string]sqstypes.MessageAttributeValue{
+			"tenant": {DataType: aws.String("String"), StringValue: aws.String("t1")},
+		},
+	}
+
+	table := []struct {
+		name     string
+		from     messageGroupIDFrom
+		expected string
+	}{
+		{"attribute", messageGroupIDFrom{Attribute: "tenant"}, "t1"},
+		{"json path", messageGroupIDFrom{JSONPath: "customer.id"}, "c7"},
+		{"constant", messageGroupIDFrom{Constant: "all"}, "all"},
+		{"missing attribute falls back to constant", messageGroupIDFrom{Attribute: "missing", Constant: "all"}, "all"},
+		{"missing attribute falls back to message id", messageGroupIDFrom{Attribute: "missing"}, "id1"},
+	}
+
+	for _, data := range table {
+		if got := data.from.groupID(sqsMsg); got != data.expected {
+			t.Errorf("%s: expected=%s got=%s", data.name, data.expected, got)
+		}
+	}
+
+	for _, data := range table {
+		if got := data.from.groupID(sqsMsg); got != data.expected {
+			t.Errorf("%s: expected=%s got=%s", data.name, data.expected, got)
+		}
+	}
+
+	// hash buckets are stable and bounded
+	from := messageGroupIDFrom{JSONPath: "customer.id", HashBuckets: 4}
+	first := from.groupID(sqsMsg)
+	bucket, err := strconv.Atoi(first)
+	if err != nil || bucket < 0 || bucket >= 4 {
+		t.Errorf("hash bucket out of range: %s", first)
+	}
+	if again := from.groupID(sqsMsg); again != first {
+		t.Errorf("hash bucket not stable: %s != %s", first, again)
+	}
+}
+
+// go test -count 1 -run '^TestMessageGroupIDInvalid$' ./...
+func TestMessageGroupIDInvalid(t *testing.T) {
+	valid := "Az09!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~"
+	if !validGroupID(valid) {
+		t.Errorf("expected valid group id: %s", valid)
+	}
+
+	from := messageGroupIDFrom{Attribute: "tenant"}
+
+	groupID := func(key string) string {
+		return from.groupID(&sqstypes.Message{
+			MessageId: aws.String("id1"),
+			MessageAttributes: map[string]sqstypes.MessageAttributeValue{
+				"tenant": {DataType: aws.String("String"), StringValue: aws.String(key)},
+			},
+		})
+	}
+
+	if got := groupID(valid); got != valid {
+		t.Errorf("expected valid key kept, got %s", got)
+	}
+
+	long := strings.Repeat("a", maxMessageGroupIDLength)
+
+	seen := map[string]string{}
+	for _, key := range []string{"tenant one", "tenant\tone", "caf\u00e9", long + "b", long + "c"} {
+		got := groupID(key)
+		if !validGroupID(got) {
+			t.Errorf("key=%q: invalid group id %q", key, got)
+		}
+		if again := groupID(key); again != got {
+			t.Errorf("key=%q: group id not stable: %s != %s", key, got, again)
+		}
+		if other, found := seen[got]; found {
+			t.Errorf("keys %q and %q share group id %s", other, key, got)
+		}
+		seen[got] = key
+	}
+
+	if err := (messageGroupIDFrom{Constant: "all tenants"}).validate(); err == nil {
+		t.Errorf("expected invalid constant")
+	}
+}
+
+// go test -count 1 -run '^TestNewMessageDerivedGroupID$' ./...
+func TestNewMessageDerivedGroupID(t *testing.T) {
+	sqsMsg := &sqstypes.Message{
+		MessageId: aws.String("id1"),
+		Body:      aws.String(`{"customer":"c7"}`),
+	}
+
+	opt := newTestMessageOptions(t, queueDefaults(queueConfig{
+		QueueURL:              "https://sqs.us-east-1.amazonaws.com/123456789012/q1",
+		TopicArn:              "arn:aws:sns:us-east-1:123456789012:t1.fifo",
+		DeduplicationIDSource: deduplicationIDSourceMessageID,
+		MessageGroupIDFrom:    messageGroupIDFrom{JSONPath: "customer"},
+	}))
+
+	m, err := newMessage(sqsMsg, time.Now(), opt, 0)
+	if err != nil {
+		t.Fatalf("message: %v", err)
+	}
+	if got := aws.ToString(m.snsBatchEntry.MessageGroupId); got != "c7" {
+		t.Errorf("expected group id=c7 got=%s", got)
+	}
+
+	// derived ids count in the payload size
+	body := len(aws.ToString(sqsMsg.Body))
+	if expected := body + len("c7") + len("id1"); m.snsPayloadSize != expected {
+		t.Errorf("payload size: expected=%d got=%d", expected, m.snsPayloadSize)
+	}
+	if _, _, total, _ := snsutils.GetSNSPayloadSize(*m.snsBatchEntry, false); total != m.snsPayloadSize {
+		t.Errorf("payload size mismatch: %d != %d", total, m.snsPayloadSize)
+	}
+}
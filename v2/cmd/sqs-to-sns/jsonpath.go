@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// jsonPathLookup returns the value found at a dotted path, like
+// "order.items.0.sku", in a JSON document. Array elements are addressed
+// by index. Strings are returned as is, other values as JSON.
+func jsonPathLookup(doc, path string) (string, bool) {
+	v, errDecode := jsonDecode(doc)
+	if errDecode != nil {
+		return "", false
+	}
+	value, found := jsonPathFind(v, path)
+	if !found {
+		return "", false
+	}
+	return jsonValueString(value)
+}
+
+// jsonDecode decodes a JSON document, keeping numbers as json.Number.
+func jsonDecode(doc string) (any, error) {
+	dec := json.NewDecoder(strings.NewReader(doc))
+	dec.UseNumber()
+
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// jsonPathFind returns the value found at a dotted path in a decoded
+// JSON document.
+func jsonPathFind(v any, path string) (any, bool) {
+	for _, field := range strings.Split(path, ".") {
+		switch node := v.(type) {
+		case map[string]any:
+			child, found := node[field]
+			if !found {
+				return nil, false
+			}
+			v = child
+		case []any:
+			i, err := strconv.Atoi(field)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, false
+			}
+			v = node[i]
+		default:
+			return nil, false
+		}
+	}
+
+	return v, true
+}
+
+func jsonValueString(v any) (string, bool) {
+	switch value := v.(type) {
+	case nil:
+		return "", false
+	case string:
+		return value, true
+	case json.Number:
+		return value.String(), true
+	case bool:
+		return strconv.FormatBool(value), true
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return fmt.Sprint(v), true
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), true
+}
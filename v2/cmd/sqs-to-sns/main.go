@@ -15,6 +15,8 @@ import (
 	_ "github.com/KimMachineGun/automemlimit"
 	"github.com/udhos/boilerplate/boilerplate"
 	"github.com/udhos/boilerplate/envconfig"
+	"github.com/udhos/sqs-to-sns/v2/internal/eventbridgeclient"
+	"github.com/udhos/sqs-to-sns/v2/internal/s3client"
 	"github.com/udhos/sqs-to-sns/v2/internal/snsclient"
 	"github.com/udhos/sqs-to-sns/v2/internal/sqsclient"
 	"gopkg.in/yaml.v3"
@@ -74,18 +76,94 @@ func main() {
 
 		// this client generator is called by every queue
 		// to generate its clients.
-		func(queueCfg queueConfig) (receiver, publisher, deleter) {
+		func(queueCfg queueConfig) queueClients {
 
-			snsClient := snsclient.NewClient(sessionName, queueCfg.TopicArn,
-				queueCfg.QueueRoleArn, cfg.endpointURL)
 			sqsClient := sqsclient.NewClient(sessionName, queueCfg.QueueURL,
 				queueCfg.QueueRoleArn, cfg.endpointURL)
 
-			return newReceiverReal(sqsClient, cfg.awsAPITimeout, cfg.perMessagePadding),
-				&publisherReal{snsClient: snsClient,
+			clients := queueClients{
+				receive: newReceiverReal(sqsClient, cfg.awsAPITimeout, cfg.perMessagePadding),
+				newPublisher: func(target string) publisher {
+					roleArn := queueCfg.Destination.RoleArn
+					if roleArn == "" {
+						roleArn = queueCfg.QueueRoleArn
+					}
+					switch queueCfg.Destination.Type {
+					case destinationSQS:
+						return &publisherSQSReal{
+							sqsClient: sqsclient.NewClient(sessionName, target,
+								roleArn, cfg.endpointURL),
+							awsAPITimeout: cfg.awsAPITimeout,
+							queueURL:      target,
+						}
+					case destinationFile:
+						sink, err := getSinkFile(target, queueCfg.Destination.RotateBytes)
+						if err != nil {
+							fatalf("queue=%s destination path=%s: %v", queueCfg.ID, target, err)
+						}
+						return &publisherFile{sink: sink}
+					case destinationWebhook:
+						return newPublisherWebhook(queueCfg.Destination)
+					case destinationEventBridge:
+						d := queueCfg.Destination
+						return &publisherEventBridgeReal{
+							client: eventbridgeclient.NewClient(sessionName,
+								d.eventBusRegion(queueCfg.QueueURL), roleArn, cfg.endpointURL),
+							awsAPITimeout:       cfg.awsAPITimeout,
+							eventBus:            target,
+							source:              d.Source,
+							detailType:          d.DetailType,
+							detailTypeAttribute: d.DetailTypeAttribute,
+						}
+					}
+					topicArn := target
+					return &publisherReal{
+						snsClient: snsclient.NewClient(sessionName, topicArn,
+							roleArn, cfg.endpointURL),
+						awsAPITimeout: cfg.awsAPITimeout,
+						topicArn:      topicArn,
+					}
+				},
+				delete: &deleterReal{sqsClient: sqsClient,
+					awsAPITimeout: cfg.awsAPITimeout},
+				visibility: &visibilityReal{sqsClient: sqsClient,
 					awsAPITimeout: cfg.awsAPITimeout},
-				&deleterReal{sqsClient: sqsClient,
-					awsAPITimeout: cfg.awsAPITimeout}
+			}
+
+			quarantineCfg := queueCfg.Quarantine
+
+			switch {
+			case quarantineCfg.QueueURL != "":
+				clients.quarantine = &quarantineQueueReal{
+					sqsClient: sqsclient.NewClient(sessionName, quarantineCfg.QueueURL,
+						quarantineCfg.RoleArn, cfg.endpointURL),
+					awsAPITimeout: cfg.awsAPITimeout,
+					queueURL:      quarantineCfg.QueueURL,
+				}
+			case quarantineCfg.TopicArn != "":
+				clients.quarantine = &quarantineTopicReal{
+					snsClient: snsclient.NewClient(sessionName, quarantineCfg.TopicArn,
+						quarantineCfg.RoleArn, cfg.endpointURL),
+					awsAPITimeout: cfg.awsAPITimeout,
+					topicArn:      quarantineCfg.TopicArn,
+				}
+			}
+
+			if queueCfg.OversizePolicy == oversizePolicyClaimCheck {
+				claimCheckCfg := queueCfg.ClaimCheck
+				endpointURL := claimCheckCfg.EndpointURL
+				if endpointURL == "" {
+					endpointURL = cfg.endpointURL
+				}
+				clients.claimCheck = &claimCheckS3Real{
+					s3Client: s3client.NewClient(sessionName, claimCheckCfg.Region,
+						claimCheckCfg.RoleArn, endpointURL),
+					awsAPITimeout: cfg.awsAPITimeout,
+					bucket:        claimCheckCfg.Bucket,
+				}
+			}
+
+			return clients
 		})
 
 	app.run()
@@ -94,11 +172,15 @@ func main() {
 
 	app.stopReaders() // stop getting messages
 
+	app.stopHeartbeats() // stop extending messages about to be abandoned
+
 	app.health.shutdown() // stop answering health checks
 
 	infof("main: sleeping %v before exiting", cfg.exitDelay)
 	time.Sleep(cfg.exitDelay)
 
+	app.stopArchivers() // complete the archive files
+
 	slog.Info("main: exiting")
 }
 
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"text/template"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -12,52 +14,185 @@ import (
 
 const maxSnsPublishPayload = 262144
 
+var (
+	errInvalidPayloadSize = errors.New("invalid payload size")
+	errNoRoomForBody      = errors.New("attributes and padding leave no room for body")
+)
+
 type message struct {
 	sqsMessage     *sqstypes.Message
 	receivedAt     time.Time
 	snsBatchEntry  *snstypes.PublishBatchRequestEntry
 	snsPayloadSize int
+	attempts       int       // failed publish attempts
+	oversize       bool      // does not fit the destination payload limit
+	overflow       bool      // hit the attribute limit
+	invalid        bool      // could not be converted, see errTransform
+	retry          bool      // could not be converted yet, see errRetryLater
+	compressed     bool      // body is gzip+base64 encoded
+	bodySize       int       // body size before compression
+	skip           uint64    // destinations that published it before
+	delivery       *delivery // outcome across destinations, set by forward
+	parts          []message // source messages of an aggregate
+	children       []message // elements of a split message, see split_json_array
+	split          *delivery // outcome across the children of a split message
+	publishedID    string    // MessageId assigned by the destination, for the archive
+}
+
+// messageOptions defines how an SQS message is converted to an SNS entry.
+type messageOptions struct {
+	copyAttributes        bool
+	attributes            attributesConfig              // filter, rename and limit
+	addTemplates          map[string]*template.Template // parsed attributes.add
+	extractAttributes     []extractAttribute            // promote JSON body fields
+	bodyTemplate          *template.Template            // reshape body, nil if disabled
+	cloudEvents           cloudEventsConfig
+	queueID               string // for attributes.add templates
+	queueURL              string // for attributes.add templates
+	copyMessageGroupID    bool
+	groupIDFrom           messageGroupIDFrom // overrides copyMessageGroupID
+	copyDeduplicationID   bool               // only for FIFO topics
+	deduplicationIDSource string             // sqs, message_id, body_hash
+	compress              bool
+	compressThreshold     int  // only bodies larger than this are compressed
+	unwrapEnvelope        bool // body is an SNS envelope
+	verifyEnvelope        bool // check the envelope signature
+	payloadLimit          int  // destination limit per message, 0 means maxSnsPublishPayload
+}
+
+// maxPayload returns the destination limit per message.
+func (opt messageOptions) maxPayload() int {
+	if opt.payloadLimit < 1 {
+		return maxSnsPublishPayload
+	}
+	return opt.payloadLimit
+}
+
+// newMessageOptions parses the queue templates once, to be reused for
+// every message received from the queue.
+func newMessageOptions(q queueConfig) (messageOptions, error) {
+	addTemplates, err := parseAttributeTemplates(q.Attributes.Add)
+	if err != nil {
+		return messageOptions{}, err
+	}
+
+	var bodyTemplate *template.Template
+	if q.BodyTemplate != "" {
+		bodyTemplate, err = parseBodyTemplate(q.BodyTemplate)
+		if err != nil {
+			return messageOptions{}, fmt.Errorf("body_template: %w", err)
+		}
+	}
+
+	return messageOptions{
+		copyAttributes:        aws.ToBool(q.CopyAttributes),
+		attributes:            q.Attributes,
+		addTemplates:          addTemplates,
+		extractAttributes:     q.ExtractAttributes,
+		bodyTemplate:          bodyTemplate,
+		cloudEvents:           q.CloudEvents,
+		queueID:               q.ID,
+		queueURL:              q.QueueURL,
+		copyMessageGroupID:    aws.ToBool(q.CopyMesssageGroupID),
+		groupIDFrom:           q.MessageGroupIDFrom,
+		copyDeduplicationID:   aws.ToBool(q.CopyMessageDeduplicationID) && q.anyFifoTopic(),
+		deduplicationIDSource: q.DeduplicationIDSource,
+		compress:              q.Compress,
+		compressThreshold:     q.CompressThreshold,
+		unwrapEnvelope:        q.UnwrapSNSEnvelope,
+		verifyEnvelope:        q.VerifySNSEnvelope,
+		payloadLimit:          q.Destination.limits().messageLimit(),
+	}, nil
 }
 
 func newMessage(sqsMessage *sqstypes.Message, receivedAt time.Time,
-	copyAttributes, copyMessageGroupID bool, perMessagePadding int) (message, error) {
+	opt messageOptions, perMessagePadding int) (message, error) {
 
-	m, snsPayloadBodySize, snsPayloadAttrSize := newMessageUnsafe(sqsMessage,
-		receivedAt, copyAttributes, copyMessageGroupID)
+	m, snsPayloadBodySize, snsPayloadAttrSize, err := newMessageUnsafe(sqsMessage,
+		receivedAt, opt)
+	if err != nil {
+		return message{}, err
+	}
 
 	messagePayloadSize := m.snsPayloadSize + perMessagePadding
 
-	if messagePayloadSize > maxSnsPublishPayload {
-		return message{}, fmt.Errorf("invalid payload size for SNS (body=%d attributes=%d padding=%d): total=%d > limit=%d",
-			snsPayloadBodySize, snsPayloadAttrSize, perMessagePadding, messagePayloadSize, maxSnsPublishPayload)
+	if limit := opt.maxPayload(); messagePayloadSize > limit {
+		// Return the converted message, for the oversize policy.
+		return m, fmt.Errorf("%w for destination (body=%d attributes=%d padding=%d): total=%d > limit=%d",
+			errInvalidPayloadSize, snsPayloadBodySize, snsPayloadAttrSize, perMessagePadding, messagePayloadSize, limit)
 	}
 
 	return m, nil
 }
 
 func newMessageUnsafe(sqsMessage *sqstypes.Message, receivedAt time.Time,
-	copyAttributes, copyMessageGroupID bool) (message, int, int) {
+	opt messageOptions) (message, int, int, error) {
+
+	if opt.unwrapEnvelope {
+		//
+		// replace the SNS envelope with the message it holds
+		//
+		unwrapped, err := unwrapEnvelope(sqsMessage, opt.verifyEnvelope)
+		if err != nil {
+			return message{}, 0, 0, err
+		}
+		sqsMessage = unwrapped
+	}
 
 	snsEntry := snstypes.PublishBatchRequestEntry{
 		Message: sqsMessage.Body,
 	}
 
-	if copyAttributes {
+	if opt.bodyTemplate != nil {
+		//
+		// reshape body
+		//
+		body, err := renderBody(opt.bodyTemplate, sqsMessage, opt)
+		if err != nil {
+			return message{}, 0, 0, fmt.Errorf("%w: %w", errTransform, err)
+		}
+		snsEntry.Message = aws.String(body)
+	}
+
+	if opt.copyAttributes {
 		//
 		// copy attributes from SQS to SNS
 		//
-		attr := map[string]snstypes.MessageAttributeValue{}
-		for k, v := range sqsMessage.MessageAttributes {
-			attr[k] = snstypes.MessageAttributeValue{
-				DataType:    v.DataType,
-				BinaryValue: v.BinaryValue,
-				StringValue: v.StringValue,
-			}
+		snsEntry.MessageAttributes = opt.attributes.copyAttributes(sqsMessage.MessageAttributes)
+	}
+
+	if len(opt.addTemplates) > 0 {
+		//
+		// add static or templated attributes
+		//
+		if snsEntry.MessageAttributes == nil {
+			snsEntry.MessageAttributes = map[string]snstypes.MessageAttributeValue{}
+		}
+		data := attributeTemplateData{
+			QueueID:   opt.queueID,
+			QueueURL:  opt.queueURL,
+			Hostname:  hostname,
+			MessageID: aws.ToString(sqsMessage.MessageId),
+		}
+		if err := addAttributes(snsEntry.MessageAttributes, opt.addTemplates, data); err != nil {
+			return message{}, 0, 0, fmt.Errorf("%w: %w", errTransform, err)
+		}
+	}
+
+	if len(opt.extractAttributes) > 0 {
+		//
+		// promote JSON body fields to attributes
+		//
+		if snsEntry.MessageAttributes == nil {
+			snsEntry.MessageAttributes = map[string]snstypes.MessageAttributeValue{}
+		}
+		if err := extractAttributes(opt.extractAttributes, aws.ToString(sqsMessage.Body),
+			snsEntry.MessageAttributes); err != nil {
+			return message{}, 0, 0, fmt.Errorf("%w: %w", errTransform, err)
 		}
-		snsEntry.MessageAttributes = attr
 	}
 
-	if copyMessageGroupID {
+	if opt.copyMessageGroupID {
 		//
 		// copy message group id from SQS to SNS
 		//
@@ -66,6 +201,44 @@ func newMessageUnsafe(sqsMessage *sqstypes.Message, receivedAt time.Time,
 		}
 	}
 
+	if opt.groupIDFrom.enabled() {
+		//
+		// derive message group id
+		//
+		snsEntry.MessageGroupId = aws.String(opt.groupIDFrom.groupID(sqsMessage))
+	}
+
+	if opt.copyDeduplicationID {
+		//
+		// copy or derive message deduplication id
+		//
+		if dedupID := deduplicationID(sqsMessage, opt.deduplicationIDSource); dedupID != "" {
+			snsEntry.MessageDeduplicationId = aws.String(dedupID)
+		}
+	}
+
+	if opt.cloudEvents.Mode != "" {
+		//
+		// map to CloudEvents
+		//
+		if err := opt.cloudEvents.applyCloudEvents(&snsEntry, aws.ToString(sqsMessage.MessageId),
+			sqsMessage.Attributes["SentTimestamp"], receivedAt, opt.queueURL); err != nil {
+			return message{}, 0, 0, fmt.Errorf("%w: cloudevents: %w", errTransform, err)
+		}
+	}
+
+	overflow, errLimit := opt.attributes.limitAttributes(&snsEntry)
+	if errLimit != nil {
+		return message{}, 0, 0, errLimit
+	}
+
+	var compressed bool
+	var bodySize int
+
+	if opt.compress && len(aws.ToString(snsEntry.Message)) > opt.compressThreshold {
+		bodySize, compressed = compressEntry(&snsEntry, opt.attributes.attributeLimit())
+	}
+
 	const debug = false
 
 	snsPayloadBodySize, snsPayloadAttrSize, snsPayloadTotalSize, _ := snsutils.GetSNSPayloadSize(snsEntry, debug)
@@ -75,7 +248,50 @@ func newMessageUnsafe(sqsMessage *sqstypes.Message, receivedAt time.Time,
 		receivedAt:     receivedAt,
 		snsBatchEntry:  &snsEntry,
 		snsPayloadSize: snsPayloadTotalSize,
+		compressed:     compressed,
+		bodySize:       bodySize,
+		overflow:       overflow,
+	}
+
+	return m, snsPayloadBodySize, snsPayloadAttrSize, nil
+}
+
+// compressEntry replaces the entry body with its gzip+base64 encoding and
+// marks it with attribute content-encoding. The entry is left untouched if
+// compression does not shrink the body or there is no room for the attribute.
+// It returns the body size before compression.
+func compressEntry(snsEntry *snstypes.PublishBatchRequestEntry, attributeLimit int) (int, bool) {
+	body := aws.ToString(snsEntry.Message)
+
+	if len(snsEntry.MessageAttributes) >= attributeLimit {
+		return len(body), false
+	}
+	if _, found := snsEntry.MessageAttributes[snsutils.ContentEncodingAttribute]; found {
+		return len(body), false // already encoded by the producer
 	}
 
-	return m, snsPayloadBodySize, snsPayloadAttrSize
+	encoded, errCompress := snsutils.CompressBody(body)
+	if errCompress != nil || len(encoded) >= len(body) {
+		return len(body), false
+	}
+
+	if snsEntry.MessageAttributes == nil {
+		snsEntry.MessageAttributes = map[string]snstypes.MessageAttributeValue{}
+	}
+	snsEntry.MessageAttributes[snsutils.ContentEncodingAttribute] = snstypes.MessageAttributeValue{
+		DataType:    aws.String("String"),
+		StringValue: aws.String(snsutils.ContentEncodingGzipBase64),
+	}
+	snsEntry.Message = aws.String(encoded)
+
+	return len(body), true
+}
+
+// compressionRatio returns the compressed body size as a percentage
+// of the body size before compression.
+func compressionRatio(m message) uint64 {
+	if m.bodySize == 0 {
+		return 100
+	}
+	return uint64(100 * len(aws.ToString(m.snsBatchEntry.Message)) / m.bodySize)
 }
@@ -1,12 +1,14 @@
 package main
 
 import (
+	"math/rand/v2"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/udhos/sqs-to-sns/v2/snsutils"
 )
 
 // go test -count 1 -run '^TestMessage$' ./...
@@ -19,17 +21,16 @@ func TestMessage(t *testing.T) {
 		Body:      aws.String(payload),
 	}
 
-	const (
-		copyAttributes     = true
-		copyMessageGroupID = true
-	)
+	opt := messageOptions{
+		copyAttributes:     true,
+		copyMessageGroupID: true,
+	}
 
 	now := time.Now()
 
 	const perMessagePadding = 0
 
-	m, err := newMessage(sqsMessage, now, copyAttributes, copyMessageGroupID,
-		perMessagePadding)
+	m, err := newMessage(sqsMessage, now, opt, perMessagePadding)
 
 	if err != nil {
 		t.Errorf("message: %v", err)
@@ -50,7 +51,7 @@ func TestNewMessageWithPadding(t *testing.T) {
 
 	t.Run("Accepts message within limit", func(t *testing.T) {
 		// Body is small, padding is small.
-		_, err := newMessage(sqsMsg, time.Now(), false, false, 100)
+		_, err := newMessage(sqsMsg, time.Now(), messageOptions{}, 100)
 		if err != nil {
 			t.Errorf("Expected success, got error: %v", err)
 		}
@@ -63,9 +64,101 @@ func TestNewMessageWithPadding(t *testing.T) {
 		body := "This body is longer than five bytes"
 		sqsMsg.Body = &body
 
-		_, err := newMessage(sqsMsg, time.Now(), false, false, padding)
+		_, err := newMessage(sqsMsg, time.Now(), messageOptions{}, padding)
 		if err == nil {
 			t.Error("Expected error because body + padding > maxSnsPublishPayload, but got nil")
 		}
 	})
 }
+
+// go test -count 1 -run '^TestNewMessageCompress$' ./...
+func TestNewMessageCompress(t *testing.T) {
+	opt := messageOptions{
+		copyAttributes:    true,
+		compress:          true,
+		compressThreshold: 1000,
+	}
+
+	t.Run("compresses body over limit to fit", func(t *testing.T) {
+		body := strings.Repeat(`{"key":"value"}`, maxSnsPublishPayload/10)
+		sqsMsg := &sqstypes.Message{Body: aws.String(body)}
+
+		m, err := newMessage(sqsMsg, time.Now(), opt, 500)
+		if err != nil {
+			t.Fatalf("expected compressed message to fit, got error: %v", err)
+		}
+		if !m.compressed {
+			t.Errorf("expected compressed message")
+		}
+		encoding := m.snsBatchEntry.MessageAttributes[snsutils.ContentEncodingAttribute]
+		if got := aws.ToString(encoding.StringValue); got != snsutils.ContentEncodingGzipBase64 {
+			t.Errorf("content-encoding: expected=%s got=%s", snsutils.ContentEncodingGzipBase64, got)
+		}
+		if _, _, total, _ := snsutils.GetSNSPayloadSize(*m.snsBatchEntry, false); m.snsPayloadSize != total {
+			t.Errorf("size must count compressed body: expected=%d got=%d", total, m.snsPayloadSize)
+		}
+		plain, errDecompress := snsutils.DecompressBody(aws.ToString(m.snsBatchEntry.Message))
+		if errDecompress != nil || plain != body {
+			t.Errorf("compressed body does not decode to original: %v", errDecompress)
+		}
+		if ratio := compressionRatio(m); ratio >= 100 {
+			t.Errorf("expected ratio < 100, got %d", ratio)
+		}
+	})
+
+	t.Run("ratio counts the body before compression", func(t *testing.T) {
+		tmpl, err := parseBodyTemplate(`{{.Body}}{{.Body}}{{.Body}}{{.Body}}`)
+		if err != nil {
+			t.Fatalf("template: %v", err)
+		}
+		reshaped := opt
+		reshaped.bodyTemplate = tmpl
+
+		body := strings.Repeat(`{"key":"value"}`, 1000)
+		sqsMsg := &sqstypes.Message{Body: aws.String(body)}
+
+		m, err := newMessage(sqsMsg, time.Now(), reshaped, 0)
+		if err != nil {
+			t.Fatalf("message: %v", err)
+		}
+		if !m.compressed {
+			t.Fatalf("expected compressed message")
+		}
+		expected := uint64(100 * len(aws.ToString(m.snsBatchEntry.Message)) / (4 * len(body)))
+		if ratio := compressionRatio(m); ratio != expected {
+			t.Errorf("expected ratio=%d got=%d", expected, ratio)
+		}
+	})
+
+	t.Run("leaves body under threshold", func(t *testing.T) {
+		sqsMsg := &sqstypes.Message{Body: aws.String(strings.Repeat("a", 1000))}
+
+		m, err := newMessage(sqsMsg, time.Now(), opt, 0)
+		if err != nil {
+			t.Fatalf("message: %v", err)
+		}
+		if m.compressed {
+			t.Errorf("body under threshold must not be compressed")
+		}
+		if len(m.snsBatchEntry.MessageAttributes) != 0 {
+			t.Errorf("unexpected attributes: %v", m.snsBatchEntry.MessageAttributes)
+		}
+	})
+
+	t.Run("leaves incompressible body", func(t *testing.T) {
+		// random printable characters: base64 overhead outweighs gzip gains
+		var sb strings.Builder
+		for range 2000 {
+			sb.WriteByte(byte(' ' + rand.IntN(95)))
+		}
+		sqsMsg := &sqstypes.Message{Body: aws.String(sb.String())}
+
+		m, err := newMessage(sqsMsg, time.Now(), opt, 0)
+		if err != nil {
+			t.Fatalf("message: %v", err)
+		}
+		if m.compressed {
+			t.Errorf("incompressible body must not be compressed")
+		}
+	})
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"strconv"
+	"time"
+)
+
+// Nack policies applied to messages that failed to publish.
+const (
+	// nackPolicyCooldown keeps the original behavior: the publisher
+	// sleeps PublishErrorCooldown and failed messages are forgotten
+	// until their visibility timeout expires.
+	nackPolicyCooldown = "cooldown"
+
+	// nackPolicyImmediate releases failed messages back to SQS
+	// right away by setting their visibility timeout to zero.
+	nackPolicyImmediate = "immediate"
+
+	// nackPolicyBackoff releases failed messages back to SQS with a
+	// visibility timeout that grows exponentially with the message
+	// ApproximateReceiveCount.
+	nackPolicyBackoff = "backoff"
+)
+
+// nackEnabled reports whether failed messages are released back to SQS.
+func nackEnabled(policy string) bool {
+	return policy == nackPolicyImmediate || policy == nackPolicyBackoff
+}
+
+// nack releases messages back to SQS according to the queue nack policy.
+// The publisher goroutine does not sleep, the retry delay is delegated to
+// the SQS visibility timeout.
+func (app *application) nack(q *queue, msg []message) {
+	const me = "nack"
+
+	q.heartbeat.untrack(msg) // stop extending them
+	app.releaseGroups(q, msg)
+
+	for len(msg) > 0 {
+		size := min(len(msg), maxBatchItems)
+		batch := msg[:size]
+		msg = msg[size:]
+
+		changes := make([]visibilityChange, len(batch))
+		for i, m := range batch {
+			changes[i] = visibilityChange{
+				msg: m,
+				timeout: nackTimeout(q.queueCfg.NackPolicy, receiveCount(m),
+					q.queueCfg.NackBackoffMin, q.queueCfg.NackBackoffMax),
+			}
+		}
+
+		nacked, errNack := q.visibility.changeVisibility(q, changes)
+		if errNack != nil {
+			q.stats.nackErrors.Add(1)
+			q.logger.Error(me,
+				"error", errNack,
+				"messages", len(batch))
+			continue
+		}
+
+		q.stats.nackedMessages.Add(uint64(len(nacked)))
+
+		q.logger.Debug(me,
+			"nacked", len(nacked),
+			"messages", len(batch),
+			"nack_policy", q.queueCfg.NackPolicy)
+	}
+}
+
+// nackTimeout returns the visibility timeout for a failed message.
+// For backoff, the first receive waits backoffMin, then the wait
+// doubles for every further receive, capped at backoffMax.
+func nackTimeout(policy string, receiveCount int,
+	backoffMin, backoffMax time.Duration) time.Duration {
+
+	if policy != nackPolicyBackoff {
+		return 0
+	}
+
+	timeout := backoffMin
+	for i := 1; i < receiveCount; i++ {
+		timeout *= 2
+		if timeout >= backoffMax {
+			return backoffMax
+		}
+	}
+
+	return min(timeout, backoffMax)
+}
+
+// receiveCount returns the SQS ApproximateReceiveCount of a message,
+// or 1 if unknown.
+func receiveCount(m message) int {
+	count, err := strconv.Atoi(m.sqsMessage.Attributes["ApproximateReceiveCount"])
+	if err != nil || count < 1 {
+		return 1
+	}
+	return count
+}
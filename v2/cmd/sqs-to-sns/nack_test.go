@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+// go test -count 1 -run '^TestNackTimeout$' ./...
+func TestNackTimeout(t *testing.T) {
+	const (
+		backoffMin = time.Second
+		backoffMax = 10 * time.Second
+	)
+
+	table := []struct {
+		policy       string
+		receiveCount int
+		expected     time.Duration
+	}{
+		{nackPolicyImmediate, 1, 0},
+		{nackPolicyImmediate, 5, 0},
+		{nackPolicyBackoff, 1, time.Second},
+		{nackPolicyBackoff, 2, 2 * time.Second},
+		{nackPolicyBackoff, 3, 4 * time.Second},
+		{nackPolicyBackoff, 4, 8 * time.Second},
+		{nackPolicyBackoff, 5, 10 * time.Second},
+		{nackPolicyBackoff, 1000, 10 * time.Second},
+	}
+
+	for _, data := range table {
+		got := nackTimeout(data.policy, data.receiveCount, backoffMin, backoffMax)
+		if got != data.expected {
+			t.Errorf("policy=%s receiveCount=%d: expected=%v got=%v",
+				data.policy, data.receiveCount, data.expected, got)
+		}
+	}
+}
+
+// go test -count 1 -run '^TestReceiveCount$' ./...
+func TestReceiveCount(t *testing.T) {
+	m, _ := createTestMessage(1)
+
+	if got := receiveCount(m); got != 1 {
+		t.Errorf("missing attribute: expected=1 got=%d", got)
+	}
+
+	m.sqsMessage.Attributes = map[string]string{"ApproximateReceiveCount": "3"}
+
+	if got := receiveCount(m); got != 3 {
+		t.Errorf("expected=3 got=%d", got)
+	}
+}
+
+// go test -count 1 -run '^TestBatchPublishNack$' ./...
+func TestBatchPublishNack(t *testing.T) {
+
+	t.Run("whole batch failure", func(t *testing.T) {
+		vis := &visibilityMock{}
+		q := newNackTestQueue(nackPolicyBackoff, &publisherErrMock{err: errors.New("boom")}, vis)
+
+		msg := make([]message, 12)
+		for i := range msg {
+			msg[i], _ = createTestMessage(10)
+		}
+		msg[0].sqsMessage.Attributes = map[string]string{"ApproximateReceiveCount": "2"}
+
+		app := &application{}
+
+		begin := time.Now()
+		app.batchPublish(q, q.destinations[0], msg)
+		if elapsed := time.Since(begin); elapsed >= q.queueCfg.PublishErrorCooldown {
+			t.Errorf("publisher should not sleep: elapsed=%v", elapsed)
+		}
+
+		if got := q.stats.nackedMessages.Load(); got != 12 {
+			t.Errorf("nacked messages: expected=12 got=%d", got)
+		}
+		if calls := vis.getCalls(); calls != 2 {
+			t.Errorf("expected 2 batch calls, got %d", calls)
+		}
+		if vis.changes[0].timeout != 2*time.Second {
+			t.Errorf("expected backoff 2s, got %v", vis.changes[0].timeout)
+		}
+		if len(q.deleteCh) != 0 {
+			t.Errorf("expected no message forwarded to janitor, got %d", len(q.deleteCh))
+		}
+	})
+
+	t.Run("partial failure", func(t *testing.T) {
+		vis := &visibilityMock{}
+		q := newNackTestQueue(nackPolicyImmediate,
+			&publisherPartialMock{code: "InvalidParameter", senderFault: true}, vis)
+
+		msg := make([]message, 4)
+		for i := range msg {
+			msg[i], _ = createTestMessage(10)
+		}
+
+		app := &application{}
+		app.batchPublish(q, q.destinations[0], msg)
+
+		if got := q.stats.nackedMessages.Load(); got != 2 {
+			t.Errorf("nacked messages: expected=2 got=%d", got)
+		}
+		if len(q.deleteCh) != 2 {
+			t.Errorf("expected 2 messages forwarded to janitor, got %d", len(q.deleteCh))
+		}
+		for _, c := range vis.changes {
+			if c.timeout != 0 {
+				t.Errorf("expected immediate release, got timeout=%v", c.timeout)
+			}
+		}
+	})
+}
+
+func newNackTestQueue(policy string, pub publisher, vis visibilityChanger) *queue {
+	q := &queue{
+		queueCfg: queueDefaults(queueConfig{
+			NackPolicy:           policy,
+			PublishErrorCooldown: time.Second,
+		}),
+		deleteCh:   make(chan message, 100),
+		visibility: vis,
+		logger:     slog.Default(),
+	}
+	initStats(&q.stats)
+	newTestDestination(q, pub)
+	return q
+}
+
+type publisherErrMock struct {
+	err error
+}
+
+func (p *publisherErrMock) publish(_ *queue, _ []message) ([]message, []publishFailure, error) {
+	return nil, nil, p.err
+}
+
+// publisherPartialMock succeeds only for even-indexed messages.
+// Odd-indexed messages fail with code.
+type publisherPartialMock struct {
+	code        string
+	senderFault bool
+}
+
+func (p *publisherPartialMock) publish(_ *queue, msg []message) ([]message, []publishFailure, error) {
+	var pub []message
+	var failures []publishFailure
+	for i, m := range msg {
+		if i%2 == 0 {
+			pub = append(pub, m)
+			continue
+		}
+		failures = append(failures, publishFailure{
+			msg:         m,
+			code:        p.code,
+			senderFault: p.senderFault,
+		})
+	}
+	return pub, failures, nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	snstypes "github.com/aws/aws-sdk-go-v2/service/sns/types"
+	"github.com/udhos/sqs-to-sns/v2/snsutils"
+)
+
+// Policies applied to messages that exceed the SNS payload limit.
+const (
+	// oversizePolicyLeave logs and leaves the message in the queue.
+	// It reappears every visibility timeout.
+	oversizePolicyLeave = "leave"
+
+	// oversizePolicyDelete deletes the message from SQS, dropping it.
+	oversizePolicyDelete = "delete"
+
+	// oversizePolicyDeadLetter forwards the message to the quarantine
+	// target, then deletes it from SQS.
+	oversizePolicyDeadLetter = "dead_letter"
+
+	// oversizePolicyTruncate truncates the body to fit the limit and
+	// marks the message with attrTruncated.
+	oversizePolicyTruncate = "truncate"
+)
+
+// attrTruncated marks a truncated message. Its value is the original body size.
+const attrTruncated = "sqs_to_sns_truncated"
+
+const quarantineReasonOversize = "oversize"
+
+// handleOversize applies the queue oversize policy to messages that
+// do not fit the SNS payload limit.
+func (app *application) handleOversize(q *queue, msg []message) {
+	const me = "handleOversize"
+
+	policy := q.queueCfg.OversizePolicy
+
+	q.stats.oversizeMessages.add(policy, uint64(len(msg)))
+
+	if policy != oversizePolicyTruncate && policy != oversizePolicyClaimCheck {
+		q.stats.droppedMessages.Add(uint64(len(msg)))
+	}
+
+	switch policy {
+	case oversizePolicyDelete:
+		q.ackGroups(msg)
+		for _, m := range msg {
+			q.logger.Warn(me,
+				"message_id", aws.ToString(m.sqsMessage.MessageId),
+				"oversize_policy", policy)
+			q.deleteCh <- m
+		}
+	case oversizePolicyDeadLetter:
+		app.quarantine(q, msg, quarantineReasonOversize)
+	case oversizePolicyClaimCheck:
+		for _, m := range msg {
+			q.claimCheckCh <- m // Upload out of the reader
+		}
+	case oversizePolicyTruncate:
+		for _, m := range msg {
+			t, errTrunc := truncateMessage(m,
+				q.options, app.cfg.perMessagePadding)
+			if errTrunc != nil {
+				q.logger.Error(me,
+					"message_id", aws.ToString(m.sqsMessage.MessageId),
+					"oversize_policy", policy,
+					"error", errTrunc)
+				q.stats.droppedMessages.Add(1)
+				app.leave(q, []message{m}) // Leave it in the queue
+				continue
+			}
+			app.forward(q, t)
+		}
+	default:
+		app.leave(q, msg) // Leave them in the queue
+	}
+}
+
+// truncateMessage builds an SNS entry whose body is truncated to fit the SNS
+// payload limit. The entry is marked with attribute attrTruncated holding the
+// original body size.
+func truncateMessage(m message, opt messageOptions,
+	perMessagePadding int) (message, error) {
+
+	opt.compress = false       // truncate the plain body
+	opt.unwrapEnvelope = false // already unwrapped by the receiver
+
+	t, _, _, errConv := newMessageUnsafe(m.sqsMessage, m.receivedAt, opt)
+	if errConv != nil {
+		return message{}, errConv
+	}
+	t.skip = m.skip
+
+	body := aws.ToString(t.snsBatchEntry.Message) // rendered by body_template
+
+	if t.snsBatchEntry.MessageAttributes == nil {
+		t.snsBatchEntry.MessageAttributes = map[string]snstypes.MessageAttributeValue{}
+	}
+	t.snsBatchEntry.MessageAttributes[attrTruncated] = snstypes.MessageAttributeValue{
+		DataType:    aws.String("Number"),
+		StringValue: aws.String(strconv.Itoa(len(body))),
+	}
+
+	const debug = false
+	_, _, total, _ := snsutils.GetSNSPayloadSize(*t.snsBatchEntry, debug)
+
+	excess := total + perMessagePadding - opt.maxPayload()
+	if excess >= len(body) {
+		return message{}, errNoRoomForBody
+	}
+
+	size := len(body) - max(excess, 0)
+
+	// Do not split a multi-byte UTF-8 character.
+	for size > 0 && size < len(body) && !utf8.RuneStart(body[size]) {
+		size--
+	}
+
+	t.snsBatchEntry.Message = aws.String(body[:size])
+	_, _, t.snsPayloadSize, _ = snsutils.GetSNSPayloadSize(*t.snsBatchEntry, debug)
+
+	return t, nil
+}
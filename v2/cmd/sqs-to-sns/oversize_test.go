@@ -0,0 +1,97 @@
+package main
+
+import (
+	"log/slog"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// go test -count 1 -run '^TestTruncateMessage$' ./...
+func TestTruncateMessage(t *testing.T) {
+	const padding = 500
+
+	// multi-byte characters force truncation to respect rune boundaries
+	body := strings.Repeat("ação", maxSnsPublishPayload/4)
+
+	m := message{
+		sqsMessage: &sqstypes.Message{
+			MessageId: aws.String(getRandomID()),
+			Body:      aws.String(body),
+		},
+		receivedAt: time.Now(),
+	}
+
+	tr, err := truncateMessage(m, messageOptions{copyAttributes: true, copyMessageGroupID: true}, padding)
+	if err != nil {
+		t.Fatalf("truncate: %v", err)
+	}
+
+	if tr.sqsMessage != m.sqsMessage {
+		t.Errorf("truncated message must keep the SQS message")
+	}
+	if tr.snsPayloadSize+padding > maxSnsPublishPayload {
+		t.Errorf("truncated size=%d + padding=%d exceeds limit=%d",
+			tr.snsPayloadSize, padding, maxSnsPublishPayload)
+	}
+	if !utf8.ValidString(aws.ToString(tr.snsBatchEntry.Message)) {
+		t.Errorf("truncated body is not valid UTF-8")
+	}
+	if got := aws.ToString(tr.snsBatchEntry.MessageAttributes[attrTruncated].StringValue); got != strconv.Itoa(len(body)) {
+		t.Errorf("truncated marker: expected original size, got %s", got)
+	}
+}
+
+// go test -count 1 -run '^TestHandleOversize$' ./...
+func TestHandleOversize(t *testing.T) {
+	newOversize := func() message {
+		return message{
+			sqsMessage: &sqstypes.Message{
+				MessageId: aws.String(getRandomID()),
+				Body:      aws.String(strings.Repeat("a", maxSnsPublishPayload+1)),
+			},
+			receivedAt: time.Now(),
+			oversize:   true,
+		}
+	}
+
+	table := []struct {
+		policy    string
+		published int
+		deleted   int
+	}{
+		{oversizePolicyLeave, 0, 0},
+		{oversizePolicyDelete, 0, 1},
+		{oversizePolicyTruncate, 1, 0},
+	}
+
+	for _, data := range table {
+		q := &queue{
+			queueCfg: queueDefaults(queueConfig{OversizePolicy: data.policy}),
+			deleteCh: make(chan message, 10),
+			logger:   slog.Default(),
+		}
+		initStats(&q.stats)
+		d := newTestDestination(q, &publisherMock{})
+
+		app := &application{}
+		app.handleOversize(q, []message{newOversize()})
+
+		if len(d.publishCh) != data.published {
+			t.Errorf("policy=%s: published expected=%d got=%d",
+				data.policy, data.published, len(d.publishCh))
+		}
+		if len(q.deleteCh) != data.deleted {
+			t.Errorf("policy=%s: deleted expected=%d got=%d",
+				data.policy, data.deleted, len(q.deleteCh))
+		}
+		if got := q.stats.oversizeMessages.get(data.policy); got != 1 {
+			t.Errorf("policy=%s: oversize metric expected=1 got=%d", data.policy, got)
+		}
+	}
+}
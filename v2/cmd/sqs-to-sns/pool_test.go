@@ -189,17 +189,16 @@ func createTestMessage(payloadSize int) (message, error) {
 		Body:      aws.String(payload),
 	}
 
-	const (
-		copyAttributes     = true
-		copyMessageGroupID = true
-	)
+	opt := messageOptions{
+		copyAttributes:     true,
+		copyMessageGroupID: true,
+	}
 
 	now := time.Now()
 
 	const perMessagePadding = 0
 
-	return newMessage(sqsMessage, now, copyAttributes, copyMessageGroupID,
-		perMessagePadding)
+	return newMessage(sqsMessage, now, opt, perMessagePadding)
 }
 
 // go test -run '^TestPoolDeleteBehavior$' ./...
@@ -15,11 +15,18 @@ import (
 type poolV2 struct {
 	snsPublishPayloadLimit int
 	perMessagePadding      int
+	maxItems               int
 	buf                    []message
 	mu                     sync.Mutex
 }
 
 func newPoolV2(snsPublishPayloadLimit, perMessagePadding int) *poolV2 {
+	return newPoolV2Items(snsPublishPayloadLimit, perMessagePadding, maxBatchItems)
+}
+
+// newPoolV2Items creates a poolV2 whose batches hold up to maxItems
+// messages, instead of the maxBatchItems of a PublishBatch.
+func newPoolV2Items(snsPublishPayloadLimit, perMessagePadding, maxItems int) *poolV2 {
 
 	if snsPublishPayloadLimit < 1 {
 		panic("poolV2 does NOT support unlimited payload (but poolV1 does)")
@@ -29,9 +36,14 @@ func newPoolV2(snsPublishPayloadLimit, perMessagePadding int) *poolV2 {
 		panic("perMessagePadding must be non-negative")
 	}
 
+	if maxItems < 1 {
+		panic("maxItems must be positive")
+	}
+
 	return &poolV2{
 		snsPublishPayloadLimit: snsPublishPayloadLimit,
 		perMessagePadding:      perMessagePadding,
+		maxItems:               maxItems,
 		buf:                    make([]message, 0, 100),
 	}
 }
@@ -58,7 +70,7 @@ func (p *poolV2) findIndices() ([]int, int) {
 	// 2 - it could further delay messages by chance. A bad luck
 	//     message size could get delayed over and over.
 	for i := range len(p.buf) {
-		if len(indices) >= maxBatchItems {
+		if len(indices) >= p.maxItems {
 			break
 		}
 
@@ -95,8 +107,8 @@ func (p *poolV2) getFullBatch() ([]message, bool) {
 		return nil, false
 	}
 
-	// 1. Full by Count: We hit 10 items.
-	if len(indices) >= maxBatchItems {
+	// 1. Full by Count: We hit 10 items (maxItems).
+	if len(indices) >= p.maxItems {
 		return p.extractUnsafe(indices), true
 	}
 
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"maps"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// Reasons recorded in the quarantine diagnostic attributes.
+const (
+	quarantineReasonMaxReceiveCount = "max_receive_count_exceeded"
+	quarantineReasonPublishFailure  = "publish_failure"
+	quarantineReasonTransformError  = "transform_error"
+)
+
+// Diagnostic attributes added to quarantined messages.
+const (
+	attrQuarantineReason       = "sqs_to_sns_quarantine_reason"
+	attrQuarantineSourceQueue  = "sqs_to_sns_source_queue"
+	attrQuarantineMessageID    = "sqs_to_sns_source_message_id"
+	attrQuarantineReceiveCount = "sqs_to_sns_receive_count"
+)
+
+// maxMessageAttributes is the limit of message attributes for both SQS and SNS.
+const maxMessageAttributes = 10
+
+// quarantineConfig defines where poison messages are forwarded to.
+// Exactly one of QueueURL or TopicArn must be set.
+type quarantineConfig struct {
+	QueueURL string `yaml:"queue_url"`
+	TopicArn string `yaml:"topic_arn"`
+	RoleArn  string `yaml:"role_arn"`
+}
+
+func (c quarantineConfig) enabled() bool {
+	return c.QueueURL != "" || c.TopicArn != ""
+}
+
+// isPoison reports whether the message was received more than MaxReceiveCount times.
+func (q *queue) isPoison(m message) bool {
+	return q.queueCfg.MaxReceiveCount > 0 && receiveCount(m) > q.queueCfg.MaxReceiveCount
+}
+
+// quarantine forwards messages to the quarantine target and then hands
+// them to the janitor for deletion from the source queue.
+// Messages that fail to be quarantined are handed back to SQS.
+func (app *application) quarantine(q *queue, msg []message, reason string) {
+	const me = "quarantine"
+
+	for len(msg) > 0 {
+		size := min(len(msg), maxBatchItems)
+		batch := msg[:size]
+		msg = msg[size:]
+
+		quarantined, errQuarantine := q.quarantine.quarantine(q, batch, reason)
+		if errQuarantine != nil {
+			q.stats.quarantineErrors.Add(1)
+			q.logger.Error(me,
+				"error", errQuarantine,
+				"reason", reason,
+				"messages", len(batch))
+			app.release(q, batch)
+			continue
+		}
+
+		q.stats.quarantinedMessages.Add(uint64(len(quarantined)))
+
+		if len(quarantined) < len(batch) {
+			q.stats.quarantineErrors.Add(1)
+			app.release(q, messagesNotIn(batch, quarantined))
+		}
+
+		q.ackGroups(quarantined)
+
+		for _, m := range quarantined {
+			q.logger.Warn(me,
+				"message_id", aws.ToString(m.sqsMessage.MessageId),
+				"receive_count", receiveCount(m),
+				"reason", reason)
+
+			q.deleteCh <- m
+		}
+	}
+}
+
+// release hands messages back to SQS, according to the nack policy.
+func (app *application) release(q *queue, msg []message) {
+	if nackEnabled(q.queueCfg.NackPolicy) {
+		app.nack(q, msg)
+		return
+	}
+	app.leave(q, msg) // Let SQS redeliver them
+}
+
+// quarantineAttributes returns the message attributes plus diagnostic
+// attributes. Diagnostic attributes are only added while there is room
+// under the message attribute limit.
+func quarantineAttributes(q *queue, m message, reason string) map[string]sqstypes.MessageAttributeValue {
+	attr := maps.Clone(m.sqsMessage.MessageAttributes)
+	if attr == nil {
+		attr = map[string]sqstypes.MessageAttributeValue{}
+	}
+
+	diagnostics := []struct {
+		name     string
+		dataType string
+		value    string
+	}{
+		{attrQuarantineReason, "String", reason},
+		{attrQuarantineSourceQueue, "String", q.queueCfg.QueueURL},
+		{attrQuarantineMessageID, "String", aws.ToString(m.sqsMessage.MessageId)},
+		{attrQuarantineReceiveCount, "Number", strconv.Itoa(receiveCount(m))},
+	}
+
+	for _, d := range diagnostics {
+		if len(attr) >= maxMessageAttributes {
+			break
+		}
+		attr[d.name] = sqstypes.MessageAttributeValue{
+			DataType:    aws.String(d.dataType),
+			StringValue: aws.String(d.value),
+		}
+	}
+
+	return attr
+}
+
+// messagesNotIn returns the messages from all that are missing from subset.
+func messagesNotIn(all, subset []message) []message {
+	found := make(map[*sqstypes.Message]struct{}, len(subset))
+	for _, m := range subset {
+		found[m.sqsMessage] = struct{}{}
+	}
+	var missing []message
+	for _, m := range all {
+		if _, ok := found[m.sqsMessage]; !ok {
+			missing = append(missing, m)
+		}
+	}
+	return missing
+}
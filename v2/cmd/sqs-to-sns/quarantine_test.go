@@ -0,0 +1,233 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// go test -count 1 -run '^TestQuarantinePoison$' ./...
+func TestQuarantinePoison(t *testing.T) {
+	good, _ := createTestMessage(10)
+	poison, _ := createTestMessage(10)
+	poison.sqsMessage.Attributes = map[string]string{"ApproximateReceiveCount": "6"}
+
+	quarantine := &quarantinerMock{}
+
+	q := &queue{
+		queueCfg: queueDefaults(queueConfig{
+			MaxReceiveCount: 5,
+			Quarantine:      quarantineConfig{QueueURL: "dlq"},
+		}),
+		deleteCh: make(chan message, 10),
+		receive: &receiverListMock{
+			msg: []message{good, poison},
+		},
+		quarantine: quarantine,
+		logger:     slog.Default(),
+	}
+	initStats(&q.stats)
+	d := newTestDestination(q, &publisherMock{})
+	q.readers.Add(1)
+
+	app := &application{}
+	app.startReader(q, false)
+
+	if len(d.publishCh) != 1 {
+		t.Fatalf("expected 1 message forwarded to publisher, got %d", len(d.publishCh))
+	}
+	if m := <-d.publishCh; m.sqsMessage != good.sqsMessage {
+		t.Errorf("expected good message forwarded to publisher")
+	}
+	if len(q.deleteCh) != 1 {
+		t.Fatalf("expected 1 message forwarded to janitor, got %d", len(q.deleteCh))
+	}
+	if m := <-q.deleteCh; m.sqsMessage != poison.sqsMessage {
+		t.Errorf("expected poison message forwarded to janitor")
+	}
+	if got := quarantine.getReasons(); len(got) != 1 || got[0] != quarantineReasonMaxReceiveCount {
+		t.Errorf("unexpected quarantine reasons: %v", got)
+	}
+	if got := q.stats.quarantinedMessages.Load(); got != 1 {
+		t.Errorf("quarantined: expected=1 got=%d", got)
+	}
+}
+
+// go test -count 1 -run '^TestQuarantinePoisonInvalid$' ./...
+func TestQuarantinePoisonInvalid(t *testing.T) {
+	invalid := message{
+		sqsMessage: &sqstypes.Message{
+			MessageId:  aws.String("invalid"),
+			Body:       aws.String("plain"),
+			Attributes: map[string]string{"ApproximateReceiveCount": "6"},
+		},
+		receivedAt: time.Now(),
+		invalid:    true,
+	}
+
+	quarantine := &quarantinerMock{}
+
+	q := &queue{
+		queueCfg: queueDefaults(queueConfig{
+			MaxReceiveCount: 5,
+			Quarantine:      quarantineConfig{QueueURL: "dlq"},
+		}),
+		deleteCh:   make(chan message, 10),
+		receive:    &receiverListMock{msg: []message{invalid}},
+		quarantine: quarantine,
+		logger:     slog.Default(),
+	}
+	initStats(&q.stats)
+	newTestDestination(q, &publisherMock{})
+	q.readers.Add(1)
+
+	app := &application{}
+	app.startReader(q, false)
+
+	if got := quarantine.getReasons(); len(got) != 1 || got[0] != quarantineReasonMaxReceiveCount {
+		t.Errorf("expected invalid poison message quarantined, got reasons: %v", got)
+	}
+	if got := q.stats.transformErrors.Load(); got != 0 {
+		t.Errorf("transform errors: expected=0 got=%d", got)
+	}
+}
+
+// go test -count 1 -run '^TestQuarantineError$' ./...
+func TestQuarantineError(t *testing.T) {
+	poison, _ := createTestMessage(10)
+
+	q := &queue{
+		queueCfg:   queueDefaults(queueConfig{}),
+		deleteCh:   make(chan message, 10),
+		quarantine: &quarantinerMock{err: errors.New("boom")},
+		logger:     slog.Default(),
+	}
+	initStats(&q.stats)
+
+	app := &application{}
+	app.quarantine(q, []message{poison}, quarantineReasonPublishFailure)
+
+	if len(q.deleteCh) != 0 {
+		t.Errorf("failed quarantine must not delete, got %d", len(q.deleteCh))
+	}
+	if got := q.stats.quarantineErrors.Load(); got != 1 {
+		t.Errorf("quarantine errors: expected=1 got=%d", got)
+	}
+}
+
+// go test -count 1 -run '^TestQuarantineAttributes$' ./...
+func TestQuarantineAttributes(t *testing.T) {
+	q := &queue{queueCfg: queueConfig{QueueURL: "queue1"}}
+
+	m, _ := createTestMessage(10)
+
+	attr := quarantineAttributes(q, m, "reason1")
+	if len(attr) != 4 {
+		t.Fatalf("expected 4 diagnostic attributes, got %d", len(attr))
+	}
+	if got := aws.ToString(attr[attrQuarantineReason].StringValue); got != "reason1" {
+		t.Errorf("reason: expected=reason1 got=%s", got)
+	}
+
+	// fill up to 8 attributes: only 2 diagnostic attributes fit
+	m.sqsMessage.MessageAttributes = map[string]sqstypes.MessageAttributeValue{}
+	for i := range 8 {
+		m.sqsMessage.MessageAttributes[fmt.Sprintf("attr%d", i)] = sqstypes.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String("value"),
+		}
+	}
+
+	attr = quarantineAttributes(q, m, "reason1")
+	if len(attr) != maxMessageAttributes {
+		t.Errorf("expected %d attributes, got %d", maxMessageAttributes, len(attr))
+	}
+	if len(m.sqsMessage.MessageAttributes) != 8 {
+		t.Errorf("original attributes must not be modified")
+	}
+}
+
+// go test -count 1 -run '^TestValidateQuarantine$' ./...
+func TestValidateQuarantine(t *testing.T) {
+	table := []struct {
+		name  string
+		q     queueConfig
+		valid bool
+	}{
+		{"disabled", queueConfig{}, true},
+		{"queue", queueConfig{MaxReceiveCount: 5, Quarantine: quarantineConfig{QueueURL: "dlq"}}, true},
+		{"topic", queueConfig{MaxReceiveCount: 5, Quarantine: quarantineConfig{TopicArn: "topic"}}, true},
+		{"missing target", queueConfig{MaxReceiveCount: 5}, false},
+		{"both targets", queueConfig{Quarantine: quarantineConfig{QueueURL: "dlq", TopicArn: "topic"}}, false},
+		{"failure action without target", queueConfig{PublishFailureAction: failureActionQuarantine}, false},
+	}
+
+	for _, data := range table {
+		err := validateQueueConfig(queueDefaults(data.q))
+		if (err == nil) != data.valid {
+			t.Errorf("%s: expected valid=%t, got error: %v", data.name, data.valid, err)
+		}
+	}
+}
+
+// receiverListMock returns msg once, then reports it must stop.
+type receiverListMock struct {
+	msg []message
+}
+
+func (r *receiverListMock) receive(_ *queue) ([]message, bool, error) {
+	msg := r.msg
+	r.msg = nil
+	return msg, true, nil
+}
+
+func (r *receiverListMock) stop(_ *queue) {}
+
+type quarantinerMock struct {
+	err     error
+	reasons []string
+	mu      sync.Mutex
+}
+
+func (d *quarantinerMock) getReasons() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.reasons
+}
+
+func (d *quarantinerMock) quarantine(_ *queue, msg []message, reason string) ([]message, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.err != nil {
+		return nil, d.err
+	}
+	for range msg {
+		d.reasons = append(d.reasons, reason)
+	}
+	return msg, nil
+}
+
+// go test -count 1 -run '^TestMessagesNotIn$' ./...
+func TestMessagesNotIn(t *testing.T) {
+	m1, _ := createTestMessage(1)
+	m2, _ := createTestMessage(2)
+	m3, _ := createTestMessage(3)
+
+	missing := messagesNotIn([]message{m1, m2, m3}, []message{m2})
+	if len(missing) != 2 {
+		t.Fatalf("expected 2 missing messages, got %d", len(missing))
+	}
+	if missing[0].sqsMessage != m1.sqsMessage || missing[1].sqsMessage != m3.sqsMessage {
+		t.Errorf("unexpected missing messages")
+	}
+
+	if missing := messagesNotIn([]message{m1}, []message{m1}); len(missing) != 0 {
+		t.Errorf("expected no missing messages, got %d", len(missing))
+	}
+}
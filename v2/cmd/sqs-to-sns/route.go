@@ -0,0 +1,244 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// Actions applied to messages that match no route.
+const (
+	// unmatchedActionDefault publishes the message to the queue topic_arn.
+	unmatchedActionDefault = "default"
+
+	// unmatchedActionLeave leaves the message in the queue.
+	unmatchedActionLeave = "leave"
+
+	// unmatchedActionDelete deletes the message from SQS, dropping it.
+	unmatchedActionDelete = "delete"
+)
+
+// routeConfig sends messages matching every condition to a topic.
+// A route without conditions matches every message.
+type routeConfig struct {
+	Name     string           `yaml:"name"`
+	TopicArn string           `yaml:"topic_arn"`
+	Match    []routeCondition `yaml:"match"`
+}
+
+// routeCondition tests a message attribute, or a field of the JSON body.
+// All tests set in a condition must pass. A condition without tests
+// requires the value to exist.
+type routeCondition struct {
+	Attribute string   `yaml:"attribute"` // message attribute name
+	JSONPath  string   `yaml:"json_path"` // dotted path into JSON body
+	Equals    *string  `yaml:"equals"`
+	Prefix    string   `yaml:"prefix"`
+	Exists    *bool    `yaml:"exists"`
+	Min       *float64 `yaml:"min"` // numeric range, inclusive
+	Max       *float64 `yaml:"max"`
+}
+
+func (c routeCondition) validate() error {
+	if (c.Attribute == "") == (c.JSONPath == "") {
+		return errors.New("route condition must set either attribute or json_path")
+	}
+	if c.Min != nil && c.Max != nil && *c.Min > *c.Max {
+		return fmt.Errorf("route condition min=%v must not exceed max=%v", *c.Min, *c.Max)
+	}
+	if c.Exists != nil && !*c.Exists && (c.Equals != nil || c.Prefix != "" || c.Min != nil || c.Max != nil) {
+		return errors.New("route condition exists=false excludes other tests")
+	}
+	return nil
+}
+
+// match tests the condition against a message.
+func (c routeCondition) match(m message) bool {
+	value, found := c.lookup(m)
+
+	if c.Exists != nil && !*c.Exists {
+		return !found
+	}
+	if !found {
+		return false
+	}
+	if c.Equals != nil && value != *c.Equals {
+		return false
+	}
+	if !strings.HasPrefix(value, c.Prefix) {
+		return false
+	}
+	if c.Min != nil || c.Max != nil {
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return false
+		}
+		if c.Min != nil && n < *c.Min {
+			return false
+		}
+		if c.Max != nil && n > *c.Max {
+			return false
+		}
+	}
+	return true
+}
+
+func (c routeCondition) lookup(m message) (string, bool) {
+	if c.Attribute != "" {
+		attr, found := m.sqsMessage.MessageAttributes[c.Attribute]
+		if !found {
+			return "", false
+		}
+		return aws.ToString(attr.StringValue), true
+	}
+	return jsonPathLookup(aws.ToString(m.sqsMessage.Body), c.JSONPath)
+}
+
+// validateRoutes checks the routes section.
+func validateRoutes(q queueConfig) error {
+	if len(q.Routes) == 0 {
+		return nil
+	}
+	if len(q.TopicArns) > 0 {
+		return errors.New("routes exclude topic_arns, use topic_arn as default route")
+	}
+	switch q.UnmatchedAction {
+	case unmatchedActionDefault:
+		if q.TopicArn == "" {
+			return errors.New("unmatched_action=default requires topic_arn")
+		}
+	case unmatchedActionLeave, unmatchedActionDelete:
+	default:
+		return fmt.Errorf("unmatched_action=%q must be one of: %s, %s, %s",
+			q.UnmatchedAction, unmatchedActionDefault, unmatchedActionLeave, unmatchedActionDelete)
+	}
+	names := map[string]bool{}
+	for _, r := range q.Routes {
+		if r.Name == "" {
+			return errors.New("route requires name")
+		}
+		if names[r.Name] {
+			return fmt.Errorf("route %s declared twice", r.Name)
+		}
+		names[r.Name] = true
+		if r.TopicArn == "" {
+			return fmt.Errorf("route %s requires topic_arn", r.Name)
+		}
+		for _, c := range r.Match {
+			if err := c.validate(); err != nil {
+				return fmt.Errorf("route %s: %w", r.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// router picks the destination of every message, by the first matching route.
+type router struct {
+	routes     []route
+	defaultBit uint64 // 0 if there is no default topic
+	unmatched  string
+}
+
+type route struct {
+	cfg routeConfig
+	bit uint64 // destination
+}
+
+// newRouter returns nil if the queue has no routes.
+func newRouter(q queueConfig) *router {
+	if len(q.Routes) == 0 {
+		return nil
+	}
+
+	topics := q.topics()
+
+	r := &router{unmatched: q.UnmatchedAction}
+
+	if q.TopicArn != "" {
+		r.defaultBit = 1 << slices.Index(topics, q.TopicArn)
+	}
+
+	for _, cfg := range q.Routes {
+		r.routes = append(r.routes, route{
+			cfg: cfg,
+			bit: 1 << slices.Index(topics, cfg.TopicArn),
+		})
+	}
+
+	return r
+}
+
+// route returns the route name and destination of a message.
+// Without a match, found is false and the destination is the
+// default topic, if any.
+func (r *router) route(m message) (name string, bit uint64, found bool) {
+	for _, rt := range r.routes {
+		if rt.match(m) {
+			return rt.cfg.Name, rt.bit, true
+		}
+	}
+	return "", r.defaultBit, false
+}
+
+func (rt route) match(m message) bool {
+	for _, c := range rt.cfg.Match {
+		if !c.match(m) {
+			return false
+		}
+	}
+	return true
+}
+
+// routeMessages applies the routes to received messages. Routed messages
+// skip every destination but theirs. Unmatched messages are either sent
+// to the default topic, or returned to be left or deleted.
+func (app *application) routeMessages(q *queue, msg []message) (routed, unmatched []message) {
+	if q.router == nil {
+		return msg, nil
+	}
+
+	all := uint64(1)<<len(q.destinations) - 1
+
+	for _, m := range msg {
+		name, bit, found := q.router.route(m)
+		if !found {
+			q.stats.unmatchedMessages.add(q.router.unmatched, 1)
+			if q.router.unmatched != unmatchedActionDefault {
+				unmatched = append(unmatched, m)
+				continue
+			}
+			name = unmatchedActionDefault
+		}
+		q.stats.routedMessages.add(name, 1)
+		m.skip |= all &^ bit
+		routed = append(routed, m)
+	}
+
+	return routed, unmatched
+}
+
+// handleUnmatched applies the unmatched action to messages that match no route.
+func (app *application) handleUnmatched(q *queue, msg []message) {
+	const me = "handleUnmatched"
+
+	for _, m := range msg {
+		q.logger.Debug(me,
+			"message_id", aws.ToString(m.sqsMessage.MessageId),
+			"unmatched_action", q.router.unmatched)
+	}
+
+	if q.router.unmatched == unmatchedActionDelete {
+		q.stats.droppedMessages.Add(uint64(len(msg)))
+		for _, m := range msg {
+			q.deleteCh <- m
+		}
+		return
+	}
+
+	app.leave(q, msg) // Leave them in the queue
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"log/slog"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+func newRouteTestMessage(body string, attr map[string]string) message {
+	sqsMsg := &sqstypes.Message{
+		MessageId:         aws.String(getRandomID()),
+		Body:              aws.String(body),
+		MessageAttributes: map[string]sqstypes.MessageAttributeValue{},
+	}
+	for k, v := range attr {
+		sqsMsg.MessageAttributes[k] = sqstypes.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(v),
+		}
+	}
+	return message{sqsMessage: sqsMsg}
+}
+
+// go test -count 1 -run '^TestRouteConditionMatch$' ./...
+func TestRouteConditionMatch(t *testing.T) {
+	m := newRouteTestMessage(`{"order":{"kind":"retail","total":150}}`,
+		map[string]string{"type": "order.created", "amount": "42"})
+
+	table := []struct {
+		name      string
+		condition routeCondition
+		expected  bool
+	}{
+		{"equals", routeCondition{Attribute: "type", Equals: aws.String("order.created")}, true},
+		{"equals mismatch", routeCondition{Attribute: "type", Equals: aws.String("order")}, false},
+		{"prefix", routeCondition{Attribute: "type", Prefix: "order."}, true},
+		{"prefix mismatch", routeCondition{Attribute: "type", Prefix: "user."}, false},
+		{"exists", routeCondition{Attribute: "type", Exists: aws.Bool(true)}, true},
+		{"implicit exists", routeCondition{Attribute: "missing"}, false},
+		{"not exists", routeCondition{Attribute: "missing", Exists: aws.Bool(false)}, true},
+		{"range", routeCondition{Attribute: "amount", Min: aws.Float64(10), Max: aws.Float64(50)}, true},
+		{"range below", routeCondition{Attribute: "amount", Min: aws.Float64(50)}, false},
+		{"range not a number", routeCondition{Attribute: "type", Min: aws.Float64(0)}, false},
+		{"json equals", routeCondition{JSONPath: "order.kind", Equals: aws.String("retail")}, true},
+		{"json range", routeCondition{JSONPath: "order.total", Max: aws.Float64(100)}, false},
+	}
+
+	for _, data := range table {
+		if got := data.condition.match(m); got != data.expected {
+			t.Errorf("%s: expected=%t got=%t", data.name, data.expected, got)
+		}
+	}
+}
+
+// go test -count 1 -run '^TestRouteMessages$' ./...
+func TestRouteMessages(t *testing.T) {
+	cfg := queueDefaults(queueConfig{
+		TopicArn: "default",
+		Routes: []routeConfig{
+			{Name: "orders", TopicArn: "orders",
+				Match: []routeCondition{{Attribute: "type", Prefix: "order."}}},
+			{Name: "big", TopicArn: "big",
+				Match: []routeCondition{{JSONPath: "total", Min: aws.Float64(1000)}}},
+			{Name: "big-orders", TopicArn: "orders",
+				Match: []routeCondition{{JSONPath: "total", Min: aws.Float64(100)}}},
+		},
+	})
+	if err := validateQueueConfig(cfg); err != nil {
+		t.Fatalf("config: %v", err)
+	}
+
+	q := &queue{
+		queueCfg: cfg,
+		deleteCh: make(chan message, 10),
+		router:   newRouter(cfg),
+		logger:   slog.Default(),
+	}
+	initStats(&q.stats)
+	for i, topic := range cfg.topics() {
+		q.destinations = append(q.destinations,
+			newDestination(i, topic, cfg, 0, &publisherMock{}, q.logger))
+	}
+
+	if len(q.destinations) != 3 {
+		t.Fatalf("expected 3 destinations (default, orders, big), got %d", len(q.destinations))
+	}
+
+	msg := []message{
+		newRouteTestMessage(`{}`, map[string]string{"type": "order.created"}),
+		newRouteTestMessage(`{"total":5000}`, nil),
+		newRouteTestMessage(`{"total":500}`, nil),
+		newRouteTestMessage(`{}`, nil),
+	}
+
+	app := &application{}
+
+	routed, unmatched := app.routeMessages(q, msg)
+	if len(routed) != 4 || len(unmatched) != 0 {
+		t.Fatalf("expected 4 routed, got routed=%d unmatched=%d", len(routed), len(unmatched))
+	}
+
+	expected := []string{"orders", "big", "orders", "default"}
+	for i, m := range routed {
+		targets := q.targets(m)
+		for _, d := range q.destinations {
+			if targets&d.bit() != 0 && d.target != expected[i] {
+				t.Errorf("message %d: expected topic %s, got %s", i, expected[i], d.target)
+			}
+		}
+	}
+
+	if got := q.stats.routedMessages.get("orders"); got != 1 {
+		t.Errorf("route orders: expected=1 got=%d", got)
+	}
+	if got := q.stats.routedMessages.get("big-orders"); got != 1 {
+		t.Errorf("route big-orders: expected=1 got=%d", got)
+	}
+	if got := q.stats.unmatchedMessages.get(unmatchedActionDefault); got != 1 {
+		t.Errorf("unmatched: expected=1 got=%d", got)
+	}
+
+	// unmatched delete
+	q.router.unmatched = unmatchedActionDelete
+	routed, unmatched = app.routeMessages(q, msg[3:])
+	if len(routed) != 0 || len(unmatched) != 1 {
+		t.Fatalf("expected 1 unmatched, got routed=%d unmatched=%d", len(routed), len(unmatched))
+	}
+	app.handleUnmatched(q, unmatched)
+	if len(q.deleteCh) != 1 {
+		t.Errorf("expected unmatched message deleted, got %d", len(q.deleteCh))
+	}
+}
+
+// go test -count 1 -run '^TestValidateRoutes$' ./...
+func TestValidateRoutes(t *testing.T) {
+	table := []struct {
+		name  string
+		cfg   queueConfig
+		valid bool
+	}{
+		{"no default topic", queueConfig{
+			Routes: []routeConfig{{Name: "r1", TopicArn: "t1"}},
+		}, false},
+		{"leave without default topic", queueConfig{
+			UnmatchedAction: unmatchedActionLeave,
+			Routes:          []routeConfig{{Name: "r1", TopicArn: "t1"}},
+		}, true},
+		{"duplicate name", queueConfig{
+			TopicArn: "t0",
+			Routes:   []routeConfig{{Name: "r1", TopicArn: "t1"}, {Name: "r1", TopicArn: "t2"}},
+		}, false},
+		{"condition without source", queueConfig{
+			TopicArn: "t0",
+			Routes:   []routeConfig{{Name: "r1", TopicArn: "t1", Match: []routeCondition{{Prefix: "a"}}}},
+		}, false},
+		{"with topic_arns", queueConfig{
+			TopicArns: []string{"t0", "t1"},
+			Routes:    []routeConfig{{Name: "r1", TopicArn: "t1"}},
+		}, false},
+	}
+
+	for _, data := range table {
+		err := validateQueueConfig(queueDefaults(data.cfg))
+		if (err == nil) != data.valid {
+			t.Errorf("%s: expected valid=%t, got error: %v", data.name, data.valid, err)
+		}
+	}
+}
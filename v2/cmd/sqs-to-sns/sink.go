@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/udhos/sqs-to-sns/v2/snsutils"
+)
+
+// sinkStdout is the file destination path for standard output.
+const sinkStdout = "-"
+
+// rotateSuffixFormat is appended to the path of a rotated file.
+const rotateSuffixFormat = "20060102T150405.000000000"
+
+// sinkFile is an append-only file, rotated by size, shared by all the
+// writers of the same path. Standard output is never rotated.
+type sinkFile struct {
+	mu          sync.Mutex
+	path        string
+	rotateBytes int64 // not positive disables rotation
+	out         io.Writer
+	file        *os.File // nil for standard output
+	size        int64
+}
+
+var sinkFiles = struct {
+	mu    sync.Mutex
+	files map[string]*sinkFile
+}{files: map[string]*sinkFile{}}
+
+// validateSinkFiles checks that queues sharing a file destination path
+// agree on rotate_bytes, since they share the file.
+func validateSinkFiles(queues []queueConfig) error {
+	rotateBytes := map[string]queueConfig{}
+	for _, q := range queues {
+		d := q.Destination
+		if d.Type != destinationFile || d.Path == sinkStdout {
+			continue
+		}
+		path := filepath.Clean(d.Path)
+		if first, found := rotateBytes[path]; found && first.Destination.RotateBytes != d.RotateBytes {
+			return fmt.Errorf("queue %s: destination path=%s rotate_bytes=%d conflicts with rotate_bytes=%d of queue %s",
+				q.ID, d.Path, d.RotateBytes, first.Destination.RotateBytes, first.ID)
+		}
+		rotateBytes[path] = q
+	}
+	return nil
+}
+
+// getSinkFile opens path, or returns the sinkFile already open for it.
+func getSinkFile(path string, rotateBytes int64) (*sinkFile, error) {
+	sinkFiles.mu.Lock()
+	defer sinkFiles.mu.Unlock()
+
+	if s, found := sinkFiles.files[path]; found {
+		return s, nil
+	}
+
+	s := &sinkFile{path: path, rotateBytes: rotateBytes, out: os.Stdout}
+	if path != sinkStdout {
+		if err := s.open(); err != nil {
+			return nil, err
+		}
+	}
+	sinkFiles.files[path] = s
+	return s, nil
+}
+
+func (s *sinkFile) open() error {
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o640)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.file = f
+	s.out = f
+	s.size = info.Size()
+	return nil
+}
+
+// rotate renames the current file with a timestamp suffix, and opens
+// a new one. Should any step fail, the next write opens the path again.
+func (s *sinkFile) rotate() error {
+	errClose := s.file.Close()
+	s.file = nil
+	if errClose != nil {
+		return errClose
+	}
+	rotated := s.path + "." + time.Now().UTC().Format(rotateSuffixFormat)
+	if err := os.Rename(s.path, rotated); err != nil {
+		return err
+	}
+	return s.open()
+}
+
+// write appends data, rotating the file first if data would make it
+// exceed rotateBytes. Files are synced, so written messages survive a
+// crash once they are deleted from SQS.
+func (s *sinkFile) write(data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil && s.path != sinkStdout {
+		if err := s.open(); err != nil {
+			return fmt.Errorf("open %s: %w", s.path, err)
+		}
+	}
+
+	if s.file != nil && s.rotateBytes > 0 && s.size > 0 && s.size+int64(len(data)) > s.rotateBytes {
+		if err := s.rotate(); err != nil {
+			return fmt.Errorf("rotate %s: %w", s.path, err)
+		}
+	}
+
+	n, err := s.out.Write(data)
+	s.size += int64(n)
+	if err != nil {
+		return err
+	}
+
+	if s.file != nil {
+		return s.file.Sync()
+	}
+	return nil
+}
+
+// publisherFile writes messages as JSON lines, see destination type file
+// and snsutils.Record.
+type publisherFile struct {
+	sink *sinkFile
+}
+
+func (p *publisherFile) publish(q *queue, msg []message) ([]message, []publishFailure, error) {
+	if len(msg) == 0 {
+		return nil, nil, errors.New("publisherFile.publish: unexpected empty message list")
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+
+	for _, m := range msg {
+		r := snsutils.NewRecord(aws.ToString(m.sqsMessage.MessageId), *m.snsBatchEntry,
+			m.sqsMessage.Attributes, m.receivedAt, q.queueCfg.ID)
+		if err := enc.Encode(r); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if err := p.sink.write(buf.Bytes()); err != nil {
+		return nil, nil, err
+	}
+
+	return msg, nil, nil
+}
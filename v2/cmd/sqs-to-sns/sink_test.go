@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/udhos/sqs-to-sns/v2/snsutils"
+)
+
+// go test -count 1 -run '^TestPublishFile$' ./...
+func TestPublishFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.jsonl")
+
+	cfg := queueDefaults(queueConfig{
+		ID:             "q1",
+		CopyAttributes: aws.Bool(true),
+		Destination:    destinationConfig{Type: destinationFile, Path: path, RotateBytes: 300},
+	})
+	if err := validateDestination(cfg); err != nil {
+		t.Fatalf("config: %v", err)
+	}
+
+	sink, err := getSinkFile(path, cfg.Destination.RotateBytes)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if again, _ := getSinkFile(path, cfg.Destination.RotateBytes); again != sink {
+		t.Errorf("expected writers of the same path to share the file")
+	}
+	pub := &publisherFile{sink: sink}
+
+	receivedAt := time.Now()
+	newMsg := func(id string) message {
+		m, err := newMessage(&sqstypes.Message{
+			MessageId:  aws.String(id),
+			Body:       aws.String(`{"id":"` + id + `"}`),
+			Attributes: map[string]string{"SentTimestamp": "1700000000000"},
+			MessageAttributes: map[string]sqstypes.MessageAttributeValue{
+				"tenant": {DataType: aws.String("String"), StringValue: aws.String("t1")},
+			},
+		}, receivedAt, newTestMessageOptions(t, cfg), 0)
+		if err != nil {
+			t.Fatalf("new message: %v", err)
+		}
+		return m
+	}
+
+	q := &queue{queueCfg: cfg, logger: slog.Default()}
+
+	for _, batch := range [][]message{{newMsg("id1"), newMsg("id2")}, {newMsg("id3")}} {
+		pubMsg, failures, err := pub.publish(q, batch)
+		if err != nil || len(pubMsg) != len(batch) || len(failures) != 0 {
+			t.Fatalf("publish: published=%d failures=%d err=%v", len(pubMsg), len(failures), err)
+		}
+	}
+
+	// second batch rotated the first one away
+	rotated, _ := filepath.Glob(path + ".*")
+	if len(rotated) != 1 {
+		t.Fatalf("expected 1 rotated file, got %v", rotated)
+	}
+
+	var ids []string
+	for _, p := range []string{rotated[0], path} {
+		f, err := os.Open(p)
+		if err != nil {
+			t.Fatalf("open: %v", err)
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var r snsutils.Record
+			if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+				t.Fatalf("line %q: %v", scanner.Text(), err)
+			}
+			if r.QueueID != "q1" || r.Attributes["SentTimestamp"] != "1700000000000" ||
+				r.MessageAttributes["tenant"].Value != "t1" || !r.ReceivedAt.Equal(receivedAt) {
+				t.Errorf("unexpected record: %s", scanner.Text())
+			}
+			ids = append(ids, r.MessageID)
+		}
+		f.Close()
+	}
+	if len(ids) != 3 || ids[0] != "id1" || ids[2] != "id3" {
+		t.Errorf("expected records id1, id2, id3, got %v", ids)
+	}
+}
+
+// go test -count 1 -run '^TestSinkFileReopen$' ./...
+func TestSinkFileReopen(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "sink")
+	if err := os.Mkdir(dir, 0o750); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	path := filepath.Join(dir, "capture.jsonl")
+
+	s := &sinkFile{path: path, rotateBytes: 10}
+	if err := s.open(); err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if err := s.write([]byte("0123456789\n")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	// rotation fails while the directory is missing
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	for range 2 {
+		if err := s.write([]byte("lost\n")); err == nil {
+			t.Fatalf("expected write error without directory")
+		}
+	}
+
+	// writes recover once the file can be opened again
+	if err := os.Mkdir(dir, 0o750); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := s.write([]byte("kept\n")); err != nil {
+		t.Fatalf("expected write to reopen the file: %v", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "kept\n" {
+		t.Errorf("unexpected file content: %q", data)
+	}
+}
+
+// go test -count 1 -run '^TestValidateSinkFiles$' ./...
+func TestValidateSinkFiles(t *testing.T) {
+	file := func(id, path string, rotateBytes int64) queueConfig {
+		return queueConfig{ID: id, Destination: destinationConfig{Type: destinationFile, Path: path, RotateBytes: rotateBytes}}
+	}
+
+	table := []struct {
+		name   string
+		queues []queueConfig
+		valid  bool
+	}{
+		{"distinct paths", []queueConfig{file("q1", "/tmp/a", 100), file("q2", "/tmp/b", 200)}, true},
+		{"same rotate_bytes", []queueConfig{file("q1", "/tmp/a", 100), file("q2", "/tmp/a", 100)}, true},
+		{"conflicting rotate_bytes", []queueConfig{file("q1", "/tmp/a", 100), file("q2", "/tmp/./a", 200)}, false},
+		{"stdout", []queueConfig{file("q1", sinkStdout, 100), file("q2", sinkStdout, 200)}, true},
+	}
+	for _, data := range table {
+		if err := validateSinkFiles(data.queues); (err == nil) != data.valid {
+			t.Errorf("%s: expected valid=%t, got error: %v", data.name, data.valid, err)
+		}
+	}
+}
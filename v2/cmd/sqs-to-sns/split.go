@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+func validateSplit(q queueConfig) error {
+	if !q.SplitJSONArray {
+		return nil
+	}
+	if isFifo(q.QueueURL) {
+		return errors.New("split_json_array does not support FIFO queues")
+	}
+	return nil
+}
+
+// newSplitMessage converts an SQS message whose body is a JSON array into
+// a parent message holding one child per element. Children share the
+// parent SQS message, so deleting or releasing any of them acts on the
+// parent, see settle. Elements that cannot be converted, or do not fit
+// the SNS limit, fail the whole parent as a transform error. A body that
+// is not a JSON array is converted as is, see newMessage.
+func newSplitMessage(sqsMessage *sqstypes.Message, receivedAt time.Time,
+	opt messageOptions, perMessagePadding int) (message, error) {
+
+	if opt.unwrapEnvelope {
+		//
+		// split the message held by the SNS envelope
+		//
+		unwrapped, err := unwrapEnvelope(sqsMessage, opt.verifyEnvelope)
+		if err != nil {
+			return message{}, err
+		}
+		sqsMessage = unwrapped
+		opt.unwrapEnvelope = false
+	}
+
+	var elements []json.RawMessage
+	if err := json.Unmarshal([]byte(aws.ToString(sqsMessage.Body)), &elements); err != nil {
+		return newMessage(sqsMessage, receivedAt, opt, perMessagePadding)
+	}
+
+	parent := message{
+		sqsMessage: sqsMessage,
+		receivedAt: receivedAt,
+		children:   make([]message, 0, len(elements)),
+	}
+
+	for i, e := range elements {
+		element := *sqsMessage
+		element.Body = aws.String(string(e))
+
+		child, err := newMessage(&element, receivedAt, opt, perMessagePadding)
+		if err != nil {
+			if errors.Is(err, errTransform) {
+				return message{}, fmt.Errorf("split_json_array element %d: %w", i, err)
+			}
+			return message{}, fmt.Errorf("%w: split_json_array element %d: %w", errTransform, i, err)
+		}
+		child.sqsMessage = sqsMessage // Delete, release or quarantine the parent
+
+		parent.children = append(parent.children, child)
+		parent.snsPayloadSize += child.snsPayloadSize
+	}
+
+	return parent, nil
+}
+
+// forwardSplit forwards the children of a split message. The parent is
+// settled once every child reported from every destination.
+func (app *application) forwardSplit(q *queue, parent message) {
+	if len(parent.children) == 0 {
+		q.deleteCh <- parent // Empty array, nothing to publish
+		return
+	}
+
+	split := &delivery{}
+	split.pending.Store(int32(len(parent.children)))
+
+	for _, c := range parent.children {
+		c.skip = parent.skip
+		c.split = split
+		app.forward(q, c)
+	}
+}
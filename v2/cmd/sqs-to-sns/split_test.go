@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+func newSplitTestMessage(t *testing.T, body string) message {
+	sqsMsg := &sqstypes.Message{
+		MessageId:     aws.String(getRandomID()),
+		ReceiptHandle: aws.String("rh1"),
+		Body:          aws.String(body),
+		MessageAttributes: map[string]sqstypes.MessageAttributeValue{
+			"tenant": {DataType: aws.String("String"), StringValue: aws.String("t1")},
+		},
+	}
+	m, err := newSplitMessage(sqsMsg, time.Now(), messageOptions{copyAttributes: true}, 0)
+	if err != nil {
+		t.Fatalf("split: %v", err)
+	}
+	return m
+}
+
+// go test -count 1 -run '^TestNewSplitMessage$' ./...
+func TestNewSplitMessage(t *testing.T) {
+	m := newSplitTestMessage(t, `[{"n":1}, "two", 3]`)
+
+	expected := []string{`{"n":1}`, `"two"`, `3`}
+	if len(m.children) != len(expected) {
+		t.Fatalf("expected %d children, got %d", len(expected), len(m.children))
+	}
+	for i, c := range m.children {
+		if got := aws.ToString(c.snsBatchEntry.Message); got != expected[i] {
+			t.Errorf("child %d: expected=%s got=%s", i, expected[i], got)
+		}
+		if got := aws.ToString(c.snsBatchEntry.MessageAttributes["tenant"].StringValue); got != "t1" {
+			t.Errorf("child %d: expected attribute copied, got %q", i, got)
+		}
+		if c.sqsMessage != m.sqsMessage {
+			t.Errorf("child %d: expected to share the parent SQS message", i)
+		}
+	}
+
+	opt := messageOptions{}
+
+	for _, body := range []string{`{"n":1}`, `plain text`} {
+		notArray := &sqstypes.Message{MessageId: aws.String("id2"), Body: aws.String(body)}
+		m, err := newSplitMessage(notArray, time.Now(), opt, 0)
+		if err != nil {
+			t.Fatalf("%s: expected body published as is, got %v", body, err)
+		}
+		if m.children != nil || aws.ToString(m.snsBatchEntry.Message) != body {
+			t.Errorf("%s: expected a single message, got children=%d", body, len(m.children))
+		}
+	}
+
+	big := `["` + strings.Repeat("a", maxSnsPublishPayload) + `"]`
+	oversize := &sqstypes.Message{MessageId: aws.String("id3"), Body: aws.String(big)}
+	if _, err := newSplitMessage(oversize, time.Now(), opt, 0); !errors.Is(err, errTransform) {
+		t.Errorf("expected transform error for oversize element, got %v", err)
+	}
+}
+
+// go test -count 1 -run '^TestSplitDeleteAfterEveryChild$' ./...
+func TestSplitDeleteAfterEveryChild(t *testing.T) {
+	q := newFanoutTestQueue(&publisherMock{}, &publisherMock{})
+	q.tracker = nil
+	app := &application{}
+
+	app.forward(q, newSplitTestMessage(t, `[1,2]`))
+
+	for _, d := range q.destinations {
+		app.batchPublish(q, d, []message{<-d.publishCh})
+	}
+	if len(q.deleteCh) != 0 {
+		t.Fatalf("parent must not be deleted before every child published, got %d", len(q.deleteCh))
+	}
+
+	for _, d := range q.destinations {
+		app.batchPublish(q, d, []message{<-d.publishCh})
+	}
+	if len(q.deleteCh) != 1 {
+		t.Fatalf("expected parent deleted once, got %d", len(q.deleteCh))
+	}
+	if m := <-q.deleteCh; aws.ToString(m.sqsMessage.ReceiptHandle) != "rh1" {
+		t.Errorf("expected parent receipt handle")
+	}
+}
+
+// go test -count 1 -run '^TestSplitReleaseOnce$' ./...
+func TestSplitReleaseOnce(t *testing.T) {
+	q := newFanoutTestQueue(&publisherMock{})
+	app := &application{}
+
+	app.forward(q, newSplitTestMessage(t, `[1,2,3]`))
+
+	d := q.destinations[0]
+	app.batchPublish(q, d, []message{<-d.publishCh})
+
+	d.publish = &publisherErrMock{err: errors.New("boom")}
+	app.batchPublish(q, d, []message{<-d.publishCh})
+
+	d.publish = &publisherMock{}
+	app.batchPublish(q, d, []message{<-d.publishCh})
+
+	if len(q.deleteCh) != 0 {
+		t.Errorf("parent with a failed child must not be deleted, got %d", len(q.deleteCh))
+	}
+	if got := q.stats.nackedMessages.Load(); got != 1 {
+		t.Errorf("nacked: expected=1 got=%d", got)
+	}
+}
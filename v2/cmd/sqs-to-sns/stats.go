@@ -2,6 +2,7 @@ package main
 
 import (
 	"math"
+	"sync"
 	"sync/atomic"
 )
 
@@ -26,6 +27,38 @@ type stats struct {
 	goroutineSpawns atomic.Uint64 // count
 	goroutineExits  atomic.Uint64 // count
 
+	visibilityExtensions atomic.Uint64 // count
+	visibilityErrors     atomic.Uint64 // count
+
+	nackedMessages atomic.Uint64 // count
+	nackErrors     atomic.Uint64 // count
+
+	retriedMessages atomic.Uint64 // count
+	failedMessages  atomic.Uint64 // count
+	publishFailures counterMap    // count per error code
+
+	quarantinedMessages atomic.Uint64 // count
+	quarantineErrors    atomic.Uint64 // count
+
+	oversizeMessages counterMap    // count per oversize policy
+	claimCheckErrors atomic.Uint64 // count
+
+	compressedMessages atomic.Uint64 // count
+	compressionRatio   gauge         // percentage 0..100 (100 * compressed/original)
+
+	transformErrors    atomic.Uint64 // count
+	attributeOverflows counterMap    // count per overflow strategy
+
+	aggregates         atomic.Uint64 // count
+	aggregatedMessages atomic.Uint64 // count
+
+	archivedMessages atomic.Uint64 // count
+	archiveDrops     atomic.Uint64 // count
+	archiveErrors    atomic.Uint64 // count
+
+	routedMessages    counterMap // count per route
+	unmatchedMessages counterMap // count per unmatched action
+
 	publishChLoad  gauge // percentage 0..100 (100 * len/cap)
 	deleteChLoad   gauge // percentage 0..100 (100 * len/cap)
 	forwardLatency gauge // milliseconds
@@ -55,6 +88,38 @@ type statsSnapshot struct {
 	goroutineSpawns uint64 // count
 	goroutineExits  uint64 // count
 
+	visibilityExtensions uint64 // count
+	visibilityErrors     uint64 // count
+
+	nackedMessages uint64 // count
+	nackErrors     uint64 // count
+
+	retriedMessages uint64            // count
+	failedMessages  uint64            // count
+	publishFailures map[string]uint64 // count per error code
+
+	quarantinedMessages uint64 // count
+	quarantineErrors    uint64 // count
+
+	oversizeMessages map[string]uint64 // count per oversize policy
+	claimCheckErrors uint64            // count
+
+	compressedMessages uint64        // count
+	compressionRatio   gaugeSnapshot // percentage 0..100 (100 * compressed/original)
+
+	transformErrors    uint64            // count
+	attributeOverflows map[string]uint64 // count per overflow strategy
+
+	aggregates         uint64 // count
+	aggregatedMessages uint64 // count
+
+	archivedMessages uint64 // count
+	archiveDrops     uint64 // count
+	archiveErrors    uint64 // count
+
+	routedMessages    map[string]uint64 // count per route
+	unmatchedMessages map[string]uint64 // count per unmatched action
+
 	publishChLoad  gaugeSnapshot // percentage 0..100 (100 * len/cap)
 	deleteChLoad   gaugeSnapshot // percentage 0..100 (100 * len/cap)
 	forwardLatency gaugeSnapshot // milliseconds
@@ -68,6 +133,7 @@ func initStats(s *stats) {
 	s.publishChLoad.min.Store(math.MaxUint64)
 	s.deleteChLoad.min.Store(math.MaxUint64)
 	s.forwardLatency.min.Store(math.MaxUint64)
+	s.compressionRatio.min.Store(math.MaxUint64)
 
 	s.receiverGoroutines.min.Store(math.MaxUint64)
 	s.publisherGoroutines.min.Store(math.MaxUint64)
@@ -97,17 +163,80 @@ func (s *stats) harvest() statsSnapshot {
 		goroutineSpawns: s.goroutineSpawns.Swap(0),
 		goroutineExits:  s.goroutineExits.Swap(0),
 
+		visibilityExtensions: s.visibilityExtensions.Swap(0),
+		visibilityErrors:     s.visibilityErrors.Swap(0),
+
+		nackedMessages: s.nackedMessages.Swap(0),
+		nackErrors:     s.nackErrors.Swap(0),
+
+		retriedMessages: s.retriedMessages.Swap(0),
+		failedMessages:  s.failedMessages.Swap(0),
+		publishFailures: s.publishFailures.harvest(),
+
+		quarantinedMessages: s.quarantinedMessages.Swap(0),
+		quarantineErrors:    s.quarantineErrors.Swap(0),
+
+		oversizeMessages: s.oversizeMessages.harvest(),
+		claimCheckErrors: s.claimCheckErrors.Swap(0),
+
+		compressedMessages: s.compressedMessages.Swap(0),
+
+		transformErrors:    s.transformErrors.Swap(0),
+		attributeOverflows: s.attributeOverflows.harvest(),
+
+		aggregates:         s.aggregates.Swap(0),
+		aggregatedMessages: s.aggregatedMessages.Swap(0),
+
+		archivedMessages: s.archivedMessages.Swap(0),
+		archiveDrops:     s.archiveDrops.Swap(0),
+		archiveErrors:    s.archiveErrors.Swap(0),
+
+		routedMessages:    s.routedMessages.harvest(),
+		unmatchedMessages: s.unmatchedMessages.harvest(),
+
 		// Gauges already use Swap(0) internally
 		publishChLoad:  s.publishChLoad.harvest(),
 		deleteChLoad:   s.deleteChLoad.harvest(),
 		forwardLatency: s.forwardLatency.harvest(),
 
+		compressionRatio: s.compressionRatio.harvest(),
+
 		receiverGoroutines:  s.receiverGoroutines.harvest(),
 		publisherGoroutines: s.publisherGoroutines.harvest(),
 		janitorGoroutines:   s.janitorGoroutines.harvest(),
 	}
 }
 
+// counterMap holds counters keyed by a label, like error code.
+type counterMap struct {
+	counters map[string]uint64
+	mu       sync.Mutex
+}
+
+func (c *counterMap) add(key string, delta uint64) {
+	c.mu.Lock()
+	if c.counters == nil {
+		c.counters = map[string]uint64{}
+	}
+	c.counters[key] += delta
+	c.mu.Unlock()
+}
+
+func (c *counterMap) get(key string) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.counters[key]
+}
+
+// harvest returns the counts since last harvest.
+func (c *counterMap) harvest() map[string]uint64 {
+	c.mu.Lock()
+	counters := c.counters
+	c.counters = nil
+	c.mu.Unlock()
+	return counters
+}
+
 // gauges uses count/sum to calcutage avg.
 type gauge struct {
 	sum   atomic.Uint64
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// visibilityHeartbeat keeps in-flight messages invisible in SQS while they
+// wait in the publish and delete pools.
+//
+// Every message is tracked from receive until delete. Periodically we
+// extend the visibility timeout of messages whose deadline is getting
+// close, so that SQS does not redeliver a message we are still holding.
+// Otherwise a slow SNS or piled up error cooldowns could expire the
+// receipt handle and cause the message to be published twice.
+//
+// Methods are safe to call on a nil *visibilityHeartbeat, which
+// means the heartbeat is disabled for the queue.
+type visibilityHeartbeat struct {
+	timeout  time.Duration
+	inflight map[*sqstypes.Message]inflightMessage
+	mu       sync.Mutex
+	stopCh   chan struct{} // closed by stop
+	stopOnce sync.Once
+}
+
+type inflightMessage struct {
+	msg      message
+	deadline time.Time
+}
+
+func newVisibilityHeartbeat(timeout time.Duration) *visibilityHeartbeat {
+	if timeout < time.Second {
+		panic("visibility heartbeat timeout must be at least 1s")
+	}
+	return &visibilityHeartbeat{
+		timeout:  timeout,
+		inflight: map[*sqstypes.Message]inflightMessage{},
+		stopCh:   make(chan struct{}),
+	}
+}
+
+// stop ends startHeartbeat, so messages abandoned on shutdown are
+// no longer extended.
+func (h *visibilityHeartbeat) stop() {
+	if h == nil {
+		return
+	}
+	h.stopOnce.Do(func() { close(h.stopCh) })
+}
+
+// interval is how often we look for messages that need extension.
+func (h *visibilityHeartbeat) interval() time.Duration {
+	return h.timeout / 3
+}
+
+// track starts tracking messages. Their visibility deadline is
+// counted from the receive time.
+func (h *visibilityHeartbeat) track(msg []message) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	for _, m := range msg {
+		h.inflight[m.sqsMessage] = inflightMessage{
+			msg:      m,
+			deadline: m.receivedAt.Add(h.timeout),
+		}
+	}
+	h.mu.Unlock()
+}
+
+// untrack stops tracking messages. Must be called when we are done
+// with a message, either because it was deleted or because we gave up
+// on it and want SQS to redeliver it.
+func (h *visibilityHeartbeat) untrack(msg []message) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	for _, m := range msg {
+		delete(h.inflight, m.sqsMessage)
+	}
+	h.mu.Unlock()
+}
+
+// due returns the messages whose remaining visibility is below half the timeout.
+func (h *visibilityHeartbeat) due(now time.Time) []message {
+	if h == nil {
+		return nil
+	}
+	threshold := now.Add(h.timeout / 2)
+	var msg []message
+	h.mu.Lock()
+	for _, f := range h.inflight {
+		if f.deadline.Before(threshold) {
+			msg = append(msg, f.msg)
+		}
+	}
+	h.mu.Unlock()
+	return msg
+}
+
+// extended records that the visibility of messages has been extended at now.
+// Messages untracked in the meantime are ignored.
+func (h *visibilityHeartbeat) extended(now time.Time, msg []message) {
+	if h == nil {
+		return
+	}
+	deadline := now.Add(h.timeout)
+	h.mu.Lock()
+	for _, m := range msg {
+		if f, found := h.inflight[m.sqsMessage]; found {
+			f.deadline = deadline
+			h.inflight[m.sqsMessage] = f
+		}
+	}
+	h.mu.Unlock()
+}
+
+// size returns the number of tracked messages.
+func (h *visibilityHeartbeat) size() int {
+	if h == nil {
+		return 0
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.inflight)
+}
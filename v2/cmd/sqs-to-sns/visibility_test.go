@@ -0,0 +1,136 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+)
+
+// go test -count 1 -run '^TestVisibilityHeartbeat$' ./...
+func TestVisibilityHeartbeat(t *testing.T) {
+	h := newVisibilityHeartbeat(30 * time.Second)
+
+	now := time.Now()
+
+	fresh, _ := createTestMessage(10)
+	fresh.receivedAt = now
+
+	old, _ := createTestMessage(10)
+	old.receivedAt = now.Add(-20 * time.Second) // 10s left < 15s threshold
+
+	h.track([]message{fresh, old})
+
+	if h.size() != 2 {
+		t.Fatalf("expected 2 tracked messages, got %d", h.size())
+	}
+
+	due := h.due(now)
+	if len(due) != 1 || due[0].sqsMessage != old.sqsMessage {
+		t.Fatalf("expected only old message due, got %d", len(due))
+	}
+
+	h.extended(now, due)
+
+	if due := h.due(now); len(due) != 0 {
+		t.Errorf("expected no message due after extension, got %d", len(due))
+	}
+
+	h.untrack([]message{fresh})
+
+	if h.size() != 1 {
+		t.Errorf("expected 1 tracked message, got %d", h.size())
+	}
+
+	// extending an untracked message must not resurrect it
+	h.extended(now, []message{fresh})
+
+	if h.size() != 1 {
+		t.Errorf("expected 1 tracked message after extending untracked, got %d", h.size())
+	}
+}
+
+// go test -count 1 -run '^TestVisibilityHeartbeatNil$' ./...
+func TestVisibilityHeartbeatNil(_ *testing.T) {
+	var h *visibilityHeartbeat
+	m, _ := createTestMessage(10)
+	h.track([]message{m})
+	h.extended(time.Now(), []message{m})
+	h.untrack([]message{m})
+	_ = h.due(time.Now())
+	_ = h.size()
+}
+
+// go test -count 1 -run '^TestStartHeartbeat$' ./...
+func TestStartHeartbeat(t *testing.T) {
+	vis := &visibilityMock{}
+
+	q := &queue{
+		queueCfg:   queueConfig{VisibilityTimeout: time.Second},
+		visibility: vis,
+		heartbeat:  newVisibilityHeartbeat(time.Second),
+		logger:     slog.Default(),
+	}
+	initStats(&q.stats)
+
+	var msg []message
+	for range 15 {
+		m, _ := createTestMessage(10)
+		msg = append(msg, m)
+	}
+	q.heartbeat.track(msg)
+
+	app := &application{queues: []*queue{q}}
+	done := make(chan struct{})
+	go func() {
+		app.startHeartbeat(q)
+		close(done)
+	}()
+
+	deadline := time.After(2 * time.Second)
+	for q.stats.visibilityExtensions.Load() < 15 {
+		select {
+		case <-deadline:
+			t.Fatalf("timeout waiting for extensions: got %d",
+				q.stats.visibilityExtensions.Load())
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	if calls := vis.getCalls(); calls < 2 {
+		t.Errorf("expected at least 2 batch calls for 15 messages, got %d", calls)
+	}
+
+	app.stopHeartbeats()
+	app.stopHeartbeats() // idempotent
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("heartbeat did not stop")
+	}
+}
+
+type visibilityMock struct {
+	calls   int
+	changes []visibilityChange
+	mu      sync.Mutex
+}
+
+func (v *visibilityMock) getCalls() int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.calls
+}
+
+func (v *visibilityMock) changeVisibility(_ *queue, changes []visibilityChange) ([]message, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.calls++
+	v.changes = append(v.changes, changes...)
+	msg := make([]message, len(changes))
+	for i, c := range changes {
+		msg[i] = c.msg
+	}
+	return msg, nil
+}
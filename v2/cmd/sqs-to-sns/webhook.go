@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// webhookMessageIDHeader carries the SQS MessageId of a single message.
+const webhookMessageIDHeader = "X-Message-Id"
+
+// webhookTimestampHeader carries the signing time, in Unix seconds. It is
+// part of the signed content, so receivers can reject replayed requests.
+const webhookTimestampHeader = "X-Signature-Timestamp"
+
+// webhookEntry is an element of the JSON array POSTed in batch mode.
+type webhookEntry struct {
+	MessageID  string            `json:"message_id"`
+	Body       string            `json:"body"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// publisherWebhook POSTs messages to an HTTP endpoint, see destination
+// type webhook. 2xx acknowledges the messages, 4xx hands them to the
+// publish_failure_action without retry, 5xx and transport errors are
+// retried.
+type publisherWebhook struct {
+	client          *http.Client
+	url             string
+	headers         map[string]string
+	secret          []byte
+	signatureHeader string
+	timeout         time.Duration // per request, and per batch of requests
+	batch           bool
+}
+
+func newPublisherWebhook(d destinationConfig) *publisherWebhook {
+	p := &publisherWebhook{
+		client:          &http.Client{Timeout: d.Timeout},
+		url:             d.URL,
+		headers:         d.Headers,
+		signatureHeader: d.SignatureHeader,
+		timeout:         d.Timeout,
+		batch:           d.Batch,
+	}
+	if d.SecretEnv != "" {
+		p.secret = []byte(os.Getenv(d.SecretEnv))
+	}
+	return p
+}
+
+// sign returns the HMAC-SHA256 signature of timestamp.body, as sha256=<hex>.
+func sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte{'.'})
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookStatusFailure classifies a non-2xx response. 4xx is a sender
+// fault, except 408 and 429 which are worth retrying.
+func webhookStatusFailure(status int) (string, bool) {
+	code := fmt.Sprintf("HTTP%d", status)
+	switch {
+	case status == http.StatusRequestTimeout, status == http.StatusTooManyRequests:
+		return code, false
+	case status >= 400 && status < 500:
+		return code, true
+	}
+	return code, false
+}
+
+// post sends one request, and returns the response status code.
+func (p *publisherWebhook) post(ctx context.Context, body []byte, contentType, messageID string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if messageID != "" {
+		req.Header.Set(webhookMessageIDHeader, messageID)
+	}
+	for k, v := range p.headers {
+		req.Header.Set(k, v)
+	}
+	if len(p.secret) > 0 {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(webhookTimestampHeader, timestamp)
+		req.Header.Set(p.signatureHeader, sign(p.secret, timestamp, body))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body) // Allow connection reuse
+
+	return resp.StatusCode, nil
+}
+
+func (p *publisherWebhook) publish(q *queue, msg []message) ([]message, []publishFailure, error) {
+	if len(msg) == 0 {
+		return nil, nil, errors.New("publisherWebhook.publish: unexpected empty message list")
+	}
+	if p.batch {
+		return p.publishBatch(q, msg)
+	}
+	return p.publishEach(q, msg)
+}
+
+// publishBatch POSTs the messages as one JSON array.
+func (p *publisherWebhook) publishBatch(q *queue, msg []message) ([]message, []publishFailure, error) {
+
+	const me = "publisherWebhook.publishBatch"
+
+	entries := make([]webhookEntry, len(msg))
+	for i, m := range msg {
+		entries[i] = webhookEntry{
+			MessageID: aws.ToString(m.sqsMessage.MessageId),
+			Body:      aws.ToString(m.snsBatchEntry.Message),
+		}
+		if len(m.snsBatchEntry.MessageAttributes) > 0 {
+			entries[i].Attributes = make(map[string]string, len(m.snsBatchEntry.MessageAttributes))
+			for k, v := range m.snsBatchEntry.MessageAttributes {
+				if v.BinaryValue != nil {
+					entries[i].Attributes[k] = base64.StdEncoding.EncodeToString(v.BinaryValue)
+					continue
+				}
+				entries[i].Attributes[k] = aws.ToString(v.StringValue)
+			}
+		}
+	}
+
+	body, err := json.Marshal(entries)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	status, err := p.post(context.Background(), body, "application/json", "")
+	if err != nil {
+		return nil, nil, err
+	}
+	if status >= 200 && status < 300 {
+		return msg, nil, nil
+	}
+
+	code, senderFault := webhookStatusFailure(status)
+	q.logger.Error(me,
+		"error", "webhook rejected batch",
+		"error_code", code,
+		"sender_fault", senderFault,
+		"total_batch_size", len(msg),
+	)
+
+	failures := make([]publishFailure, len(msg))
+	for i, m := range msg {
+		failures[i] = publishFailure{
+			msg:         m,
+			code:        code,
+			explanation: http.StatusText(status),
+			senderFault: senderFault,
+		}
+	}
+	return nil, failures, nil
+}
+
+// publishEach POSTs the messages one by one, in order, with the entry
+// body as request body. The whole batch shares one timeout, so a slow
+// endpoint holds the publisher no longer than a single request would.
+// Messages not posted in time fail, to be retried.
+func (p *publisherWebhook) publishEach(q *queue, msg []message) ([]message, []publishFailure, error) {
+
+	const me = "publisherWebhook.publishEach"
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+
+	successMessages := make([]message, 0, len(msg))
+	var failures []publishFailure
+	var errPost error
+	var transportErrors int
+
+	for _, m := range msg {
+		body := []byte(aws.ToString(m.snsBatchEntry.Message))
+
+		contentType := "text/plain; charset=utf-8"
+		if json.Valid(body) {
+			contentType = "application/json"
+		}
+
+		messageID := aws.ToString(m.sqsMessage.MessageId)
+
+		status, err := p.post(ctx, body, contentType, messageID)
+		if err != nil {
+			q.logger.Error(me,
+				"error", err,
+				"error_code", "RequestError",
+				"message_id", messageID,
+				"total_batch_size", len(msg),
+			)
+			errPost = err
+			transportErrors++
+			failures = append(failures, publishFailure{
+				msg:         m,
+				code:        "RequestError",
+				explanation: err.Error(),
+			})
+			continue
+		}
+		if status >= 200 && status < 300 {
+			successMessages = append(successMessages, m)
+			continue
+		}
+
+		code, senderFault := webhookStatusFailure(status)
+		q.logger.Error(me,
+			"error", "webhook rejected message",
+			"error_code", code,
+			"message_id", messageID,
+			"sender_fault", senderFault,
+			"total_batch_size", len(msg),
+		)
+		failures = append(failures, publishFailure{
+			msg:         m,
+			code:        code,
+			explanation: http.StatusText(status),
+			senderFault: senderFault,
+		})
+	}
+
+	if transportErrors == len(msg) {
+		return nil, nil, errPost // Endpoint unreachable, cool down
+	}
+
+	return successMessages, failures, nil
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	snstypes "github.com/aws/aws-sdk-go-v2/service/sns/types"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+func newWebhookTestMessage(id, body string) message {
+	return message{
+		sqsMessage: &sqstypes.Message{MessageId: aws.String(id)},
+		snsBatchEntry: &snstypes.PublishBatchRequestEntry{
+			Message: aws.String(body),
+			MessageAttributes: map[string]snstypes.MessageAttributeValue{
+				"tenant": {DataType: aws.String("String"), StringValue: aws.String("t1")},
+			},
+		},
+	}
+}
+
+// go test -count 1 -run '^TestPublishWebhookEach$' ./...
+func TestPublishWebhookEach(t *testing.T) {
+	t.Setenv("WEBHOOK_SECRET", "secret1")
+
+	// Local endpoint: the body is the status code to answer.
+	var mu sync.Mutex
+	var ids []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		timestamp := r.Header.Get(webhookTimestampHeader)
+		if r.Header.Get("X-Signature-256") != sign([]byte("secret1"), timestamp, body) {
+			http.Error(w, "bad signature", http.StatusUnauthorized)
+			return
+		}
+		if unix, _ := strconv.ParseInt(timestamp, 10, 64); time.Since(time.Unix(unix, 0)) > time.Minute {
+			http.Error(w, "stale timestamp", http.StatusUnauthorized)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer token1" {
+			http.Error(w, "missing header", http.StatusUnauthorized)
+			return
+		}
+		mu.Lock()
+		ids = append(ids, r.Header.Get(webhookMessageIDHeader))
+		mu.Unlock()
+		status, _ := strconv.Atoi(string(body))
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	cfg := queueDefaults(queueConfig{
+		Destination: destinationConfig{
+			Type:      destinationWebhook,
+			URL:       server.URL,
+			Headers:   map[string]string{"Authorization": "Bearer token1"},
+			SecretEnv: "WEBHOOK_SECRET",
+		},
+	})
+	if err := validateDestination(cfg); err != nil {
+		t.Fatalf("config: %v", err)
+	}
+	pub := newPublisherWebhook(cfg.Destination)
+
+	msg := []message{
+		newWebhookTestMessage("ok", "200"),
+		newWebhookTestMessage("accepted", "202"),
+		newWebhookTestMessage("poison", "400"),
+		newWebhookTestMessage("throttled", "429"),
+		newWebhookTestMessage("unavailable", "503"),
+	}
+
+	q := &queue{logger: slog.Default()}
+
+	pubMsg, failures, err := pub.publish(q, msg)
+	if err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	if len(ids) != len(msg) || ids[0] != "ok" || ids[4] != "unavailable" {
+		t.Errorf("expected one request per message in order, got %v", ids)
+	}
+	if len(pubMsg) != 2 {
+		t.Errorf("expected 2 acknowledged messages, got %d", len(pubMsg))
+	}
+
+	expected := map[string]bool{"poison": false, "throttled": true, "unavailable": true}
+	if len(failures) != len(expected) {
+		t.Fatalf("expected %d failures, got %d", len(expected), len(failures))
+	}
+	for _, f := range failures {
+		id := aws.ToString(f.msg.sqsMessage.MessageId)
+		if r, found := expected[id]; !found || r != f.retryable() {
+			t.Errorf("%s: unexpected failure code=%s retryable=%t", id, f.code, f.retryable())
+		}
+	}
+
+	// unreachable endpoint: error, so the batch cools down
+	server.Close()
+	if _, _, err := pub.publish(q, msg[:2]); err == nil {
+		t.Errorf("expected error from unreachable endpoint")
+	}
+}
+
+// go test -count 1 -run '^TestPublishWebhookEachTimeout$' ./...
+func TestPublishWebhookEachTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	cfg := queueDefaults(queueConfig{
+		Destination: destinationConfig{
+			Type:    destinationWebhook,
+			URL:     server.URL,
+			Timeout: 300 * time.Millisecond,
+		},
+	})
+	pub := newPublisherWebhook(cfg.Destination)
+
+	msg := make([]message, 20) // 1s one by one
+	for i := range msg {
+		msg[i] = newWebhookTestMessage(strconv.Itoa(i), "200")
+	}
+
+	q := &queue{logger: slog.Default()}
+
+	begin := time.Now()
+	pubMsg, failures, err := pub.publish(q, msg)
+	if elapsed := time.Since(begin); elapsed > 700*time.Millisecond {
+		t.Errorf("expected batch bounded by timeout, took %v", elapsed)
+	}
+	if err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	if len(pubMsg) == 0 || len(pubMsg)+len(failures) != len(msg) {
+		t.Fatalf("expected some messages acknowledged, the others failed: published=%d failures=%d",
+			len(pubMsg), len(failures))
+	}
+	for _, f := range failures {
+		if !f.retryable() {
+			t.Errorf("%s: expected retryable failure, got code=%s", aws.ToString(f.msg.sqsMessage.MessageId), f.code)
+		}
+	}
+}
+
+// go test -count 1 -run '^TestPublishWebhookBatch$' ./...
+func TestPublishWebhookBatch(t *testing.T) {
+	status := http.StatusOK
+	var received []webhookEntry
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "unexpected content type", http.StatusBadRequest)
+			return
+		}
+		received = nil
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	pub := newPublisherWebhook(destinationConfig{
+		URL:     server.URL,
+		Timeout: 5 * time.Second,
+		Batch:   true,
+	})
+
+	msg := []message{
+		newWebhookTestMessage("id1", `{"a":1}`),
+		newWebhookTestMessage("id2", "text"),
+	}
+
+	q := &queue{logger: slog.Default()}
+
+	pubMsg, failures, err := pub.publish(q, msg)
+	if err != nil || len(pubMsg) != 2 || len(failures) != 0 {
+		t.Fatalf("expected batch acknowledged, got published=%d failures=%d err=%v", len(pubMsg), len(failures), err)
+	}
+	if len(received) != 2 || received[0].MessageID != "id1" || received[1].Body != "text" ||
+		received[0].Attributes["tenant"] != "t1" {
+		t.Errorf("unexpected batch: %+v", received)
+	}
+
+	// 4xx rejects the whole batch as poison
+	status = http.StatusUnprocessableEntity
+	pubMsg, failures, err = pub.publish(q, msg)
+	if err != nil || len(pubMsg) != 0 || len(failures) != 2 || failures[0].retryable() {
+		t.Errorf("expected batch rejected as poison, got published=%d failures=%d err=%v", len(pubMsg), len(failures), err)
+	}
+
+	// 5xx is retried
+	status = http.StatusBadGateway
+	if _, failures, _ := pub.publish(q, msg); len(failures) != 2 || !failures[1].retryable() {
+		t.Errorf("expected batch retryable, got failures=%d", len(failures))
+	}
+}
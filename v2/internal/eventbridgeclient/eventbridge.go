@@ -0,0 +1,36 @@
+// Package eventbridgeclient provides eventbridge utilities.
+package eventbridgeclient
+
+import (
+	"log"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	"github.com/udhos/boilerplate/awsconfig"
+)
+
+// NewClient creates an EventBridge client.
+// When endpointURL is set, it points the client to a local stand-in.
+func NewClient(sessionName, region, roleArn, endpointURL string) *eventbridge.Client {
+	const me = "NewClient"
+
+	awsConfOptions := awsconfig.Options{
+		Region:          region,
+		RoleArn:         roleArn,
+		RoleSessionName: sessionName,
+		EndpointURL:     endpointURL,
+	}
+
+	awsConf, errAwsConf := awsconfig.AwsConfig(awsConfOptions)
+	if errAwsConf != nil {
+		log.Fatalf("%s: aws config error: %v", me, errAwsConf)
+	}
+
+	client := eventbridge.NewFromConfig(awsConf.AwsConfig, func(o *eventbridge.Options) {
+		if endpointURL != "" {
+			o.BaseEndpoint = aws.String(endpointURL)
+		}
+	})
+
+	return client
+}
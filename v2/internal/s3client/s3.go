@@ -0,0 +1,38 @@
+// Package s3client provides s3 utilities.
+package s3client
+
+import (
+	"log"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/udhos/boilerplate/awsconfig"
+)
+
+// NewClient creates an S3 client.
+// When endpointURL is set, path-style addressing is used in order
+// to support S3-compatible stand-ins like MinIO or LocalStack.
+func NewClient(sessionName, region, roleArn, endpointURL string) *s3.Client {
+	const me = "NewClient"
+
+	awsConfOptions := awsconfig.Options{
+		Region:          region,
+		RoleArn:         roleArn,
+		RoleSessionName: sessionName,
+		EndpointURL:     endpointURL,
+	}
+
+	awsConf, errAwsConf := awsconfig.AwsConfig(awsConfOptions)
+	if errAwsConf != nil {
+		log.Fatalf("%s: aws config error: %v", me, errAwsConf)
+	}
+
+	client := s3.NewFromConfig(awsConf.AwsConfig, func(o *s3.Options) {
+		if endpointURL != "" {
+			o.BaseEndpoint = aws.String(endpointURL)
+			o.UsePathStyle = true
+		}
+	})
+
+	return client
+}
@@ -0,0 +1,49 @@
+package snsutils
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"io"
+)
+
+// ContentEncodingAttribute is the message attribute that marks an encoded body.
+const ContentEncodingAttribute = "content-encoding"
+
+// ContentEncodingGzipBase64 identifies a body compressed with gzip and then
+// encoded with standard base64.
+const ContentEncodingGzipBase64 = "gzip+base64"
+
+// CompressBody compresses body with gzip and encodes the result with base64,
+// as identified by ContentEncodingGzipBase64.
+func CompressBody(body string) (string, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := io.WriteString(w, body); err != nil {
+		return "", fmt.Errorf("gzip write: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return "", fmt.Errorf("gzip close: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// DecompressBody reverts CompressBody. Consumers call it for messages with
+// attribute ContentEncodingAttribute set to ContentEncodingGzipBase64.
+func DecompressBody(body string) (string, error) {
+	data, errDecode := base64.StdEncoding.DecodeString(body)
+	if errDecode != nil {
+		return "", fmt.Errorf("base64 decode: %w", errDecode)
+	}
+	r, errReader := gzip.NewReader(bytes.NewReader(data))
+	if errReader != nil {
+		return "", fmt.Errorf("gzip reader: %w", errReader)
+	}
+	defer r.Close()
+	out, errRead := io.ReadAll(r)
+	if errRead != nil {
+		return "", fmt.Errorf("gzip read: %w", errRead)
+	}
+	return string(out), nil
+}
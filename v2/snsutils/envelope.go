@@ -0,0 +1,229 @@
+package snsutils
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Envelope is the JSON document SNS delivers to SQS subscriptions
+// without raw message delivery.
+type Envelope struct {
+	Type              string                       `json:"Type"`
+	MessageID         string                       `json:"MessageId"`
+	TopicArn          string                       `json:"TopicArn"`
+	Subject           *string                      `json:"Subject,omitempty"`
+	Message           string                       `json:"Message"`
+	Timestamp         string                       `json:"Timestamp"`
+	SignatureVersion  string                       `json:"SignatureVersion"`
+	Signature         string                       `json:"Signature"`
+	SigningCertURL    string                       `json:"SigningCertURL"`
+	UnsubscribeURL    string                       `json:"UnsubscribeURL"`
+	MessageAttributes map[string]EnvelopeAttribute `json:"MessageAttributes,omitempty"`
+}
+
+// EnvelopeAttribute is a message attribute nested in an Envelope.
+// Binary values are base64-encoded.
+type EnvelopeAttribute struct {
+	Type  string `json:"Type"`
+	Value string `json:"Value"`
+}
+
+// ErrNotEnvelope reports a body that is not an SNS notification envelope.
+var ErrNotEnvelope = errors.New("not an SNS notification envelope")
+
+// ParseEnvelope decodes an SNS notification envelope.
+func ParseEnvelope(body string) (Envelope, error) {
+	var e Envelope
+	if err := json.Unmarshal([]byte(body), &e); err != nil {
+		return Envelope{}, fmt.Errorf("%w: %v", ErrNotEnvelope, err)
+	}
+	if e.Type != "Notification" || e.TopicArn == "" || e.MessageID == "" {
+		return Envelope{}, fmt.Errorf("%w: type=%q topic_arn=%q message_id=%q",
+			ErrNotEnvelope, e.Type, e.TopicArn, e.MessageID)
+	}
+	return e, nil
+}
+
+// stringToSign builds the canonical notification string signed by SNS.
+func (e Envelope) stringToSign() string {
+	var sb strings.Builder
+	add := func(k, v string) {
+		sb.WriteString(k)
+		sb.WriteByte('\n')
+		sb.WriteString(v)
+		sb.WriteByte('\n')
+	}
+	add("Message", e.Message)
+	add("MessageId", e.MessageID)
+	if e.Subject != nil {
+		add("Subject", *e.Subject)
+	}
+	add("Timestamp", e.Timestamp)
+	add("TopicArn", e.TopicArn)
+	add("Type", e.Type)
+	return sb.String()
+}
+
+// ErrCertUnavailable reports a signing certificate that could not be
+// downloaded. Unlike a signature mismatch it is transient, and the
+// envelope should be verified again later.
+var ErrCertUnavailable = errors.New("signing certificate unavailable")
+
+// DefaultCertHost matches the hosts SNS serves signing certificates from.
+var DefaultCertHost = regexp.MustCompile(`^sns\.[a-z0-9-]+\.amazonaws\.com(\.cn)?$`)
+
+// Verifier checks envelope signatures. Signing certificates are
+// downloaded once and cached by URL. It is safe for concurrent use.
+type Verifier struct {
+	// Client downloads signing certificates. Its timeout bounds how long
+	// Verify blocks on a download.
+	Client *http.Client
+
+	// CertHost restricts the hosts signing certificates are downloaded from.
+	CertHost *regexp.Regexp
+
+	// RetryAfter is how long a failed download is remembered. Meanwhile,
+	// envelopes signed by that certificate fail with ErrCertUnavailable
+	// without waiting for another download.
+	RetryAfter time.Duration
+
+	certs  map[string]*x509.Certificate
+	failed map[string]time.Time // download failure time by URL
+	mu     sync.Mutex
+}
+
+// NewVerifier creates a Verifier that only trusts certificates served by SNS.
+func NewVerifier() *Verifier {
+	return &Verifier{
+		Client:     &http.Client{Timeout: 3 * time.Second},
+		CertHost:   DefaultCertHost,
+		RetryAfter: 10 * time.Second,
+	}
+}
+
+// Verify checks the envelope signature.
+func (v *Verifier) Verify(e Envelope) error {
+	var hash crypto.Hash
+	var digest []byte
+
+	switch e.SignatureVersion {
+	case "1":
+		sum := sha1.Sum([]byte(e.stringToSign()))
+		hash, digest = crypto.SHA1, sum[:]
+	case "2":
+		sum := sha256.Sum256([]byte(e.stringToSign()))
+		hash, digest = crypto.SHA256, sum[:]
+	default:
+		return fmt.Errorf("unsupported signature version: %q", e.SignatureVersion)
+	}
+
+	signature, errDecode := base64.StdEncoding.DecodeString(e.Signature)
+	if errDecode != nil {
+		return fmt.Errorf("signature decode: %w", errDecode)
+	}
+
+	cert, errCert := v.cert(e.SigningCertURL)
+	if errCert != nil {
+		return errCert
+	}
+
+	key, isRSA := cert.PublicKey.(*rsa.PublicKey)
+	if !isRSA {
+		return errors.New("signing certificate key is not RSA")
+	}
+
+	if err := rsa.VerifyPKCS1v15(key, hash, digest, signature); err != nil {
+		return fmt.Errorf("signature verify: %w", err)
+	}
+
+	return nil
+}
+
+// cert returns the signing certificate, downloading it on first use.
+func (v *Verifier) cert(certURL string) (*x509.Certificate, error) {
+	v.mu.Lock()
+	cert, found := v.certs[certURL]
+	failedAt, failed := v.failed[certURL]
+	v.mu.Unlock()
+	if found {
+		return cert, nil
+	}
+	if failed && time.Since(failedAt) < v.RetryAfter {
+		return nil, fmt.Errorf("%w: download failed %v ago",
+			ErrCertUnavailable, time.Since(failedAt).Truncate(time.Millisecond))
+	}
+
+	u, errURL := url.Parse(certURL)
+	if errURL != nil {
+		return nil, fmt.Errorf("signing cert url: %w", errURL)
+	}
+	if u.Scheme != "https" || !v.CertHost.MatchString(u.Hostname()) {
+		return nil, fmt.Errorf("untrusted signing cert url: %s", certURL)
+	}
+
+	data, errDownload := v.download(certURL)
+	if errDownload != nil {
+		v.mu.Lock()
+		if v.failed == nil {
+			v.failed = map[string]time.Time{}
+		}
+		v.failed[certURL] = time.Now()
+		v.mu.Unlock()
+		return nil, fmt.Errorf("%w: %w", ErrCertUnavailable, errDownload)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("signing cert: no PEM block")
+	}
+
+	cert, errParse := x509.ParseCertificate(block.Bytes)
+	if errParse != nil {
+		return nil, fmt.Errorf("signing cert parse: %w", errParse)
+	}
+
+	v.mu.Lock()
+	if v.certs == nil {
+		v.certs = map[string]*x509.Certificate{}
+	}
+	v.certs[certURL] = cert
+	delete(v.failed, certURL)
+	v.mu.Unlock()
+
+	return cert, nil
+}
+
+// download fetches the PEM data of a signing certificate.
+func (v *Verifier) download(certURL string) ([]byte, error) {
+	resp, errGet := v.Client.Get(certURL)
+	if errGet != nil {
+		return nil, fmt.Errorf("signing cert download: %w", errGet)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("signing cert download: status=%d", resp.StatusCode)
+	}
+
+	data, errRead := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if errRead != nil {
+		return nil, fmt.Errorf("signing cert read: %w", errRead)
+	}
+
+	return data, nil
+}
@@ -0,0 +1,123 @@
+package snsutils
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// go test -count 1 -run '^TestParseEnvelope$' ./...
+func TestParseEnvelope(t *testing.T) {
+	const body = `{"Type":"Notification","MessageId":"m1","TopicArn":"arn:aws:sns:us-east-1:111111111111:t1",` +
+		`"Message":"{\"a\":1}","Timestamp":"2024-01-01T00:00:00.000Z",` +
+		`"MessageAttributes":{"tenant":{"Type":"String","Value":"t1"}}}`
+
+	e, err := ParseEnvelope(body)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if e.Message != `{"a":1}` {
+		t.Errorf("unexpected message: %s", e.Message)
+	}
+	if e.MessageAttributes["tenant"].Value != "t1" {
+		t.Errorf("unexpected attributes: %v", e.MessageAttributes)
+	}
+
+	for _, bad := range []string{`not json`, `{"a":1}`, `{"Type":"SubscriptionConfirmation","MessageId":"m1","TopicArn":"t"}`} {
+		if _, err := ParseEnvelope(bad); !errors.Is(err, ErrNotEnvelope) {
+			t.Errorf("%s: expected ErrNotEnvelope, got %v", bad, err)
+		}
+	}
+}
+
+// go test -count 1 -run '^TestVerifyEnvelope$' ./...
+func TestVerifyEnvelope(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sns.test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("cert: %v", err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+
+	var downloads int
+	unavailable := true
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		downloads++
+		if unavailable {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write(certPEM)
+	}))
+	defer srv.Close()
+
+	e := Envelope{
+		Type:             "Notification",
+		MessageID:        "m1",
+		TopicArn:         "arn:aws:sns:us-east-1:111111111111:t1",
+		Message:          "hello",
+		Timestamp:        "2024-01-01T00:00:00.000Z",
+		SignatureVersion: "2",
+		SigningCertURL:   srv.URL + "/cert.pem",
+	}
+	sum := sha256.Sum256([]byte(e.stringToSign()))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	e.Signature = base64.StdEncoding.EncodeToString(sig)
+
+	v := &Verifier{Client: srv.Client(), CertHost: regexp.MustCompile(`^127\.0\.0\.1$`), RetryAfter: time.Hour}
+
+	// failed download is transient, and remembered
+	for range 2 {
+		if err := v.Verify(e); !errors.Is(err, ErrCertUnavailable) {
+			t.Errorf("expected ErrCertUnavailable, got %v", err)
+		}
+	}
+	if downloads != 1 {
+		t.Errorf("expected failed download remembered, got %d downloads", downloads)
+	}
+
+	unavailable = false
+	v.RetryAfter = 0
+
+	if err := v.Verify(e); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+
+	tampered := e
+	tampered.Message = "bye"
+	if err := v.Verify(tampered); err == nil || errors.Is(err, ErrCertUnavailable) {
+		t.Errorf("expected tampered message to fail verification, got %v", err)
+	}
+
+	if downloads != 2 {
+		t.Errorf("expected certificate downloaded once, got %d", downloads-1)
+	}
+
+	if err := NewVerifier().Verify(e); err == nil || errors.Is(err, ErrCertUnavailable) {
+		t.Errorf("expected untrusted certificate host to fail")
+	}
+}
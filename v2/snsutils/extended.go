@@ -0,0 +1,34 @@
+package snsutils
+
+import (
+	"encoding/json"
+)
+
+// ExtendedPayloadSizeAttribute is the message attribute that carries the
+// original body size of a message offloaded to S3, following the AWS
+// extended client libraries convention.
+const ExtendedPayloadSizeAttribute = "ExtendedPayloadSize"
+
+// extendedPayloadPointerClass identifies the S3 pointer payload
+// in the AWS extended client libraries.
+const extendedPayloadPointerClass = "software.amazon.payloadoffloading.PayloadS3Pointer"
+
+// S3Pointer locates a message body offloaded to S3.
+type S3Pointer struct {
+	S3BucketName string `json:"s3BucketName"`
+	S3Key        string `json:"s3Key"`
+}
+
+// NewExtendedPayloadPointer builds the pointer payload published in place of
+// a body offloaded to S3. The format is compatible with the AWS extended
+// client libraries:
+//
+// ["software.amazon.payloadoffloading.PayloadS3Pointer",{"s3BucketName":"bucket","s3Key":"key"}]
+func NewExtendedPayloadPointer(bucket, key string) string {
+	pointer := []any{
+		extendedPayloadPointerClass,
+		S3Pointer{S3BucketName: bucket, S3Key: key},
+	}
+	data, _ := json.Marshal(pointer)
+	return string(data)
+}
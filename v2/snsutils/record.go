@@ -0,0 +1,88 @@
+package snsutils
+
+import (
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	snstypes "github.com/aws/aws-sdk-go-v2/service/sns/types"
+)
+
+// Record is a forwarded message as written, one JSON document per line,
+// by the sqs-to-sns file destination. Body and MessageAttributes are the
+// converted SNS entry, ready to publish again, see PublishEntry.
+// Attribute binary values are base64-encoded, as in Envelope. FIFO
+// topics need MessageGroupId, and MessageDeduplicationId unless they
+// have content-based deduplication.
+type Record struct {
+	MessageID              string                       `json:"MessageId"`
+	Body                   string                       `json:"Body"`
+	MessageGroupID         string                       `json:"MessageGroupId,omitempty"`
+	MessageDeduplicationID string                       `json:"MessageDeduplicationId,omitempty"`
+	MessageAttributes      map[string]EnvelopeAttribute `json:"MessageAttributes,omitempty"`
+	Attributes             map[string]string            `json:"Attributes,omitempty"` // SQS system attributes
+	ReceivedAt             time.Time                    `json:"ReceivedAt"`
+	QueueID                string                       `json:"QueueId,omitempty"`
+}
+
+// NewRecord builds a record from an SNS entry.
+func NewRecord(messageID string, entry snstypes.PublishBatchRequestEntry,
+	systemAttributes map[string]string, receivedAt time.Time, queueID string) Record {
+
+	r := Record{
+		MessageID:              messageID,
+		Body:                   aws.ToString(entry.Message),
+		MessageGroupID:         aws.ToString(entry.MessageGroupId),
+		MessageDeduplicationID: aws.ToString(entry.MessageDeduplicationId),
+		Attributes:             systemAttributes,
+		ReceivedAt:             receivedAt.UTC(),
+		QueueID:                queueID,
+	}
+
+	if len(entry.MessageAttributes) > 0 {
+		r.MessageAttributes = make(map[string]EnvelopeAttribute, len(entry.MessageAttributes))
+		for name, v := range entry.MessageAttributes {
+			value := aws.ToString(v.StringValue)
+			if v.BinaryValue != nil {
+				value = base64.StdEncoding.EncodeToString(v.BinaryValue)
+			}
+			r.MessageAttributes[name] = EnvelopeAttribute{Type: aws.ToString(v.DataType), Value: value}
+		}
+	}
+
+	return r
+}
+
+// PublishEntry builds an SNS entry from the record, for replay.
+// The entry Id is left for the caller.
+func (r Record) PublishEntry() (snstypes.PublishBatchRequestEntry, error) {
+	entry := snstypes.PublishBatchRequestEntry{
+		Message: aws.String(r.Body),
+	}
+	if r.MessageGroupID != "" {
+		entry.MessageGroupId = aws.String(r.MessageGroupID)
+	}
+	if r.MessageDeduplicationID != "" {
+		entry.MessageDeduplicationId = aws.String(r.MessageDeduplicationID)
+	}
+
+	if len(r.MessageAttributes) > 0 {
+		entry.MessageAttributes = make(map[string]snstypes.MessageAttributeValue, len(r.MessageAttributes))
+		for name, a := range r.MessageAttributes {
+			v := snstypes.MessageAttributeValue{DataType: aws.String(a.Type)}
+			if a.Type == "Binary" {
+				data, err := base64.StdEncoding.DecodeString(a.Value)
+				if err != nil {
+					return snstypes.PublishBatchRequestEntry{}, fmt.Errorf("record %s attribute %s: %w", r.MessageID, name, err)
+				}
+				v.BinaryValue = data
+			} else {
+				v.StringValue = aws.String(a.Value)
+			}
+			entry.MessageAttributes[name] = v
+		}
+	}
+
+	return entry, nil
+}
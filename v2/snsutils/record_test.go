@@ -0,0 +1,61 @@
+package snsutils
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	snstypes "github.com/aws/aws-sdk-go-v2/service/sns/types"
+)
+
+// go test -count 1 -run '^TestRecordRoundTrip$' ./...
+func TestRecordRoundTrip(t *testing.T) {
+	entry := snstypes.PublishBatchRequestEntry{
+		Message:                aws.String(`{"a":1}`),
+		MessageGroupId:         aws.String("g1"),
+		MessageDeduplicationId: aws.String("d1"),
+		MessageAttributes: map[string]snstypes.MessageAttributeValue{
+			"tenant": {DataType: aws.String("String"), StringValue: aws.String("t1")},
+			"blob":   {DataType: aws.String("Binary"), BinaryValue: []byte{0, 1, 2}},
+		},
+	}
+	receivedAt := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	r := NewRecord("id1", entry, map[string]string{"SentTimestamp": "1700000000000"}, receivedAt, "q1")
+
+	line, err := json.Marshal(r)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+
+	var decoded Record
+	if err := json.Unmarshal(line, &decoded); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if decoded.MessageID != "id1" || decoded.QueueID != "q1" || !decoded.ReceivedAt.Equal(receivedAt) ||
+		decoded.Attributes["SentTimestamp"] != "1700000000000" {
+		t.Errorf("unexpected record: %s", line)
+	}
+
+	replay, err := decoded.PublishEntry()
+	if err != nil {
+		t.Fatalf("publish entry: %v", err)
+	}
+	if aws.ToString(replay.Message) != `{"a":1}` {
+		t.Errorf("unexpected body: %s", aws.ToString(replay.Message))
+	}
+	if aws.ToString(replay.MessageGroupId) != "g1" || aws.ToString(replay.MessageDeduplicationId) != "d1" {
+		t.Errorf("expected FIFO ids replayed, got group=%q dedup=%q",
+			aws.ToString(replay.MessageGroupId), aws.ToString(replay.MessageDeduplicationId))
+	}
+	if aws.ToString(replay.MessageAttributes["tenant"].StringValue) != "t1" ||
+		string(replay.MessageAttributes["blob"].BinaryValue) != string([]byte{0, 1, 2}) {
+		t.Errorf("unexpected attributes: %v", replay.MessageAttributes)
+	}
+
+	decoded.MessageAttributes["blob"] = EnvelopeAttribute{Type: "Binary", Value: "not base64!"}
+	if _, err := decoded.PublishEntry(); err == nil {
+		t.Errorf("expected error for invalid binary attribute")
+	}
+}
@@ -10,7 +10,8 @@ import (
 )
 
 // GetSNSPayloadSize calculates the total payload size of an SNS PublishBatchRequestEntry,
-// including the message body and all message attributes.
+// including the message body, all message attributes, and the FIFO MessageGroupId
+// and MessageDeduplicationId, which are accounted as attributes.
 // It returns the size of the message body, the total size of all attributes, and the combined total size.
 func GetSNSPayloadSize(snsEntry snstypes.PublishBatchRequestEntry,
 	debug bool) (body, attributes, total int, debugInfo string) {
@@ -57,6 +58,22 @@ func GetSNSPayloadSize(snsEntry snstypes.PublishBatchRequestEntry,
 		}
 	}
 
+	// 5. FIFO ids
+	if snsEntry.MessageGroupId != nil {
+		groupIDLen := awsStringByteSize(snsEntry.MessageGroupId)
+		attributes += groupIDLen
+		if debug {
+			debugAttr = append(debugAttr, fmt.Sprintf("messageGroupId=%d:%q", groupIDLen, aws.ToString(snsEntry.MessageGroupId)))
+		}
+	}
+	if snsEntry.MessageDeduplicationId != nil {
+		dedupIDLen := awsStringByteSize(snsEntry.MessageDeduplicationId)
+		attributes += dedupIDLen
+		if debug {
+			debugAttr = append(debugAttr, fmt.Sprintf("messageDeduplicationId=%d:%q", dedupIDLen, aws.ToString(snsEntry.MessageDeduplicationId)))
+		}
+	}
+
 	total = body + attributes
 
 	if debug {
@@ -44,6 +44,18 @@ func TestGetSNSPayloadSize(t *testing.T) {
 			wantAttributes: 0,
 			wantTotal:      4,
 		},
+		{
+			name: "FIFO Ids",
+			entry: snstypes.PublishBatchRequestEntry{
+				Message:                aws.String("msg"),
+				MessageGroupId:         aws.String("group1"),
+				MessageDeduplicationId: aws.String("dedup1"),
+			},
+			// group id (6) + dedup id (6) = 12
+			wantBody:       3,
+			wantAttributes: 12,
+			wantTotal:      15,
+		},
 		{
 			name: "Single String Attribute",
 			entry: snstypes.PublishBatchRequestEntry{
@@ -130,3 +142,47 @@ func TestGetSNSPayloadSize(t *testing.T) {
 		})
 	}
 }
+
+// go test -count 1 -run '^TestNewExtendedPayloadPointer$' ./...
+func TestNewExtendedPayloadPointer(t *testing.T) {
+	pointer := NewExtendedPayloadPointer("bucket1", "prefix/key1")
+
+	const expected = `["software.amazon.payloadoffloading.PayloadS3Pointer",{"s3BucketName":"bucket1","s3Key":"prefix/key1"}]`
+
+	if pointer != expected {
+		t.Errorf("expected=%s got=%s", expected, pointer)
+	}
+
+	// size accounting uses the pointer, not the offloaded body
+	entry := snstypes.PublishBatchRequestEntry{
+		Message: aws.String(pointer),
+	}
+	if _, _, total, _ := GetSNSPayloadSize(entry, false); total != len(expected) {
+		t.Errorf("pointer size: expected=%d got=%d", len(expected), total)
+	}
+}
+
+// go test -count 1 -run '^TestCompressBody$' ./...
+func TestCompressBody(t *testing.T) {
+	body := strings.Repeat(`{"key":"value"}`, 1000)
+
+	compressed, err := CompressBody(body)
+	if err != nil {
+		t.Fatalf("compress: %v", err)
+	}
+	if len(compressed) >= len(body) {
+		t.Errorf("expected compressed size < %d, got %d", len(body), len(compressed))
+	}
+
+	plain, err := DecompressBody(compressed)
+	if err != nil {
+		t.Fatalf("decompress: %v", err)
+	}
+	if plain != body {
+		t.Errorf("round trip mismatch")
+	}
+
+	if _, err := DecompressBody("not base64!"); err == nil {
+		t.Errorf("expected error for invalid body")
+	}
+}